@@ -0,0 +1,276 @@
+// Command fbmtool inspects and converts FixedBlockMap snapshot files.
+//
+// Usage:
+//
+//	fbmtool info [-value-size n] file
+//	fbmtool get [-value-size n] [-hex] file key
+//	fbmtool dump [-value-size n] file
+//	fbmtool validate [-value-size n] file
+//	fbmtool convert [-value-size n] -to raw|compact|json [-compression none|gzip|flate] input output
+//
+// Files written by WriteTo (raw), WriteCompactTo (compact) and
+// RawFixedBlockMap.WriteJSONLines (JSON lines) are detected automatically.
+// Since the value type is unknown, values are handled as opaque bytes: the
+// value size is derived from raw files and can be passed with -value-size to
+// check it, and is required for JSON lines without entries.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/schraf/collections"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "fbmtool: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+const usage = `usage:
+  fbmtool info [-value-size n] file
+  fbmtool get [-value-size n] [-hex] file key
+  fbmtool dump [-value-size n] file
+  fbmtool validate [-value-size n] file
+  fbmtool convert [-value-size n] -to raw|compact|json [-compression none|gzip|flate] input output`
+
+// run executes the command line args, writing its output to stdout
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	flags := flag.NewFlagSet("fbmtool "+args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	valueSize := flags.Int("value-size", 0, "size of the values in bytes, derived from raw files when 0")
+
+	var command func(args []string) error
+	switch args[0] {
+	case "info":
+		command = func(args []string) error {
+			if len(args) != 1 {
+				return errors.New(usage)
+			}
+			return info(stdout, args[0], *valueSize)
+		}
+	case "get":
+		hexKey := flags.Bool("hex", false, "the key is 32 hexadecimal digits instead of a string")
+		command = func(args []string) error {
+			if len(args) != 2 {
+				return errors.New(usage)
+			}
+			return get(stdout, args[0], *valueSize, args[1], *hexKey)
+		}
+	case "dump":
+		command = func(args []string) error {
+			if len(args) != 1 {
+				return errors.New(usage)
+			}
+			return dump(stdout, args[0], *valueSize)
+		}
+	case "validate":
+		command = func(args []string) error {
+			if len(args) != 1 {
+				return errors.New(usage)
+			}
+			return validate(stdout, args[0], *valueSize)
+		}
+	case "convert":
+		to := flags.String("to", "", "output format: raw, compact or json")
+		compression := flags.String("compression", "none", "compression of compact output: none, gzip or flate")
+		command = func(args []string) error {
+			if len(args) != 2 {
+				return errors.New(usage)
+			}
+			return convert(args[0], args[1], *valueSize, *to, *compression)
+		}
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w\n%s", err, usage)
+	}
+
+	return command(flags.Args())
+}
+
+// load reads a map from a raw, compact or JSON lines file and returns it with
+// the name of the format
+func load(path string, valueSize int) (*collections.RawFixedBlockMap, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	magic, _ := r.Peek(4)
+
+	var m *collections.RawFixedBlockMap
+	var format string
+
+	switch string(magic) {
+	case "FBMR":
+		format = "raw"
+		m, err = collections.ReadRawFixedBlockMap(r, valueSize)
+	case "FBMC":
+		format = "compact"
+		m, err = collections.ReadRawCompactFrom(r)
+	default:
+		format = "json"
+		m, err = collections.ReadRawJSONLines(r, valueSize)
+	}
+
+	if err != nil {
+		return nil, format, fmt.Errorf("reading %s as %s: %w", path, format, err)
+	}
+	if valueSize != 0 && m.ValueSize() != valueSize {
+		return nil, format, fmt.Errorf("reading %s: values are %d bytes, not %d", path, m.ValueSize(), valueSize)
+	}
+
+	return m, format, nil
+}
+
+// info prints the layout and health of a map
+func info(w io.Writer, path string, valueSize int) error {
+	m, format, err := load(path, valueSize)
+	if err != nil {
+		return err
+	}
+
+	stats := m.CollectInfo()
+
+	fmt.Fprintf(w, "format:           %s\n", format)
+	fmt.Fprintf(w, "blocks:           %d\n", m.BlockCount())
+	fmt.Fprintf(w, "block size:       %d bytes\n", m.BlockSize())
+	fmt.Fprintf(w, "value size:       %d bytes\n", m.ValueSize())
+	fmt.Fprintf(w, "capacity:         %d\n", m.Capacity())
+	fmt.Fprintf(w, "entries:          %d\n", m.Len())
+	fmt.Fprintf(w, "load factor:      %.4f\n", stats.LoadFactor)
+	fmt.Fprintf(w, "tombstone factor: %.4f\n", stats.TombstoneFactor)
+	fmt.Fprintf(w, "recommend grow:   %t\n", stats.RecommendGrow)
+	fmt.Fprintf(w, "recommend rehash: %t\n", stats.RecommendRehash)
+
+	fmt.Fprintln(w, "probe lengths:")
+	for distance, count := range m.ProbeLengths() {
+		var percent float64
+		if m.Len() > 0 {
+			percent = 100 * float64(count) / float64(m.Len())
+		}
+		fmt.Fprintf(w, "  %4d blocks: %10d (%6.2f%%)\n", distance, count, percent)
+	}
+
+	return nil
+}
+
+// get prints the value stored for a key given as a string or in hex
+func get(w io.Writer, path string, valueSize int, text string, hexKey bool) error {
+	var key collections.FixedBlockKey
+	if hexKey {
+		if err := key.UnmarshalText([]byte(text)); err != nil {
+			return err
+		}
+	} else {
+		key.FromString(text)
+	}
+
+	m, _, err := load(path, valueSize)
+	if err != nil {
+		return err
+	}
+
+	value, found := m.Get(key)
+	if !found {
+		return fmt.Errorf("key %s not found", key)
+	}
+
+	fmt.Fprintf(w, "key:   %s\n", key)
+	fmt.Fprintf(w, "value: %x\n", value)
+
+	return nil
+}
+
+// dump writes every entry as JSON lines
+func dump(w io.Writer, path string, valueSize int) error {
+	m, _, err := load(path, valueSize)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.WriteJSONLines(w, nil)
+	return err
+}
+
+// validate checks the integrity of a map
+func validate(w io.Writer, path string, valueSize int) error {
+	m, _, err := load(path, valueSize)
+	if err != nil {
+		return err
+	}
+
+	if err = m.Validate(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "ok: %d entries in %d blocks\n", m.Len(), m.BlockCount())
+	return nil
+}
+
+// convert rewrites a map in another format
+func convert(input, output string, valueSize int, to, compressionName string) error {
+	var compression collections.FixedBlockCompression
+	switch compressionName {
+	case "none":
+		compression = collections.NoCompression{}
+	case "gzip":
+		compression = collections.GzipCompression{}
+	case "flate":
+		compression = collections.FlateCompression{}
+	default:
+		return fmt.Errorf("unknown compression %q", compressionName)
+	}
+
+	m, _, err := load(input, valueSize)
+	if err != nil {
+		return err
+	}
+
+	var write func(w io.Writer) (int64, error)
+	switch strings.ToLower(to) {
+	case "raw":
+		write = m.WriteTo
+	case "compact":
+		write = func(w io.Writer) (int64, error) {
+			return m.WriteCompactTo(w, compression)
+		}
+	case "json":
+		write = func(w io.Writer) (int64, error) {
+			return m.WriteJSONLines(w, nil)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", to)
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	if _, err = write(buffered); err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/schraf/collections"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testValue struct {
+	ID    uint64
+	Score uint32
+}
+
+// writeTestMap writes a raw snapshot holding count entries keyed "key<i>"
+func writeTestMap(t *testing.T, count int) string {
+	m := collections.NewFixedBlockMap[testValue](uint64(count * 2))
+	for i := 0; i < count; i++ {
+		var key collections.FixedBlockKey
+		key.FromString(fmt.Sprintf("key%d", i))
+		require.NoError(t, m.Put(key, testValue{ID: uint64(i), Score: uint32(i * 10)}))
+	}
+
+	path := filepath.Join(t.TempDir(), "map.fbm")
+	file, err := os.Create(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = m.WriteTo(file)
+	require.NoError(t, err)
+
+	return path
+}
+
+func runTool(t *testing.T, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	err := run(args, &stdout)
+	return stdout.String(), err
+}
+
+func TestInfo(t *testing.T) {
+	path := writeTestMap(t, 50)
+
+	output, err := runTool(t, "info", path)
+	require.NoError(t, err)
+	assert.Contains(t, output, "format:           raw")
+	assert.Contains(t, output, "value size:       16 bytes")
+	assert.Contains(t, output, "entries:          50")
+	assert.Contains(t, output, "probe lengths:")
+
+	_, err = runTool(t, "info", "-value-size", "12", path)
+	assert.Error(t, err)
+}
+
+func TestGet(t *testing.T) {
+	path := writeTestMap(t, 20)
+
+	output, err := runTool(t, "get", path, "key7")
+	require.NoError(t, err)
+
+	expected := binary.NativeEndian.AppendUint64(nil, 7)
+	expected = binary.NativeEndian.AppendUint32(expected, 70)
+	assert.Contains(t, output, fmt.Sprintf("value: %x", expected))
+
+	var key collections.FixedBlockKey
+	key.FromString("key7")
+	hexOutput, err := runTool(t, "get", "-hex", path, key.String())
+	require.NoError(t, err)
+	assert.Equal(t, output, hexOutput)
+
+	_, err = runTool(t, "get", path, "missing")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestDumpAndValidate(t *testing.T) {
+	path := writeTestMap(t, 15)
+
+	output, err := runTool(t, "dump", path)
+	require.NoError(t, err)
+	assert.Len(t, strings.Split(strings.TrimSpace(output), "\n"), 15)
+
+	output, err = runTool(t, "validate", path)
+	require.NoError(t, err)
+	assert.Contains(t, output, "ok: 15 entries")
+
+	// Corrupt data fails the checksum
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[100] ^= 0xFF
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = runTool(t, "validate", path)
+	assert.ErrorIs(t, err, collections.ErrChecksumMismatch)
+}
+
+func TestConvert(t *testing.T) {
+	path := writeTestMap(t, 30)
+	dir := t.TempDir()
+
+	compact := filepath.Join(dir, "map.fbmc")
+	_, err := runTool(t, "convert", "-to", "compact", "-compression", "gzip", path, compact)
+	require.NoError(t, err)
+
+	jsonPath := filepath.Join(dir, "map.jsonl")
+	_, err = runTool(t, "convert", "-to", "json", compact, jsonPath)
+	require.NoError(t, err)
+
+	raw := filepath.Join(dir, "map.fbm")
+	_, err = runTool(t, "convert", "-to", "raw", jsonPath, raw)
+	require.NoError(t, err)
+
+	// The converted raw snapshot can be read by the typed map
+	file, err := os.Open(raw)
+	require.NoError(t, err)
+	defer file.Close()
+
+	m := collections.NewFixedBlockMap[testValue](8)
+	_, err = m.ReadFrom(file)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(30), m.Len())
+
+	var key collections.FixedBlockKey
+	key.FromString("key12")
+	value, found := m.Get(key)
+	require.True(t, found)
+	assert.Equal(t, testValue{ID: 12, Score: 120}, *value)
+
+	_, err = runTool(t, "convert", "-to", "xml", path, raw)
+	assert.Error(t, err)
+}
+
+func TestUsage(t *testing.T) {
+	_, err := runTool(t)
+	assert.ErrorContains(t, err, "usage")
+
+	_, err = runTool(t, "frobnicate")
+	assert.ErrorContains(t, err, "unknown command")
+
+	_, err = runTool(t, "info")
+	assert.ErrorContains(t, err, "usage")
+}
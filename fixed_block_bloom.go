@@ -0,0 +1,409 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+)
+
+const (
+	// bloomBlockBits is the number of bits, or counters, per block: one
+	// 64-byte cache line of bits, so a lookup touches a single cache line
+	bloomBlockBits = 512
+
+	// bloomMaxHashes caps the number of bits set per key
+	bloomMaxHashes = 16
+
+	bloomVersion    = 1
+	bloomHeaderSize = 32
+)
+
+var (
+	bloomMagic         = [4]byte{'F', 'B', 'B', 'F'}
+	countingBloomMagic = [4]byte{'F', 'B', 'C', 'B'}
+)
+
+// bloomSize returns the number of blocks and hashes for a filter holding
+// expectedItems keys with the given false-positive rate, using the standard
+// formulas m = -n ln(p) / ln(2)^2 and k = m/n ln(2)
+func bloomSize(expectedItems uint64, falsePositiveRate float64) (uint64, int) {
+	n := float64(max(expectedItems, 1))
+	p := min(max(falsePositiveRate, 1e-12), 0.5)
+
+	totalBits := math.Ceil(-n * math.Log(p) / (math.Ln2 * math.Ln2))
+	blockCount := max(1, uint64(math.Ceil(totalBits/bloomBlockBits)))
+
+	bitsPerItem := float64(blockCount*bloomBlockBits) / n
+	hashes := int(math.Round(bitsPerItem * math.Ln2))
+
+	return blockCount, min(max(hashes, 1), bloomMaxHashes)
+}
+
+// bloomHash splits a key into a block index and the two hashes used for
+// double hashing within the block. The block comes from the first half of
+// the key and the bit positions from the second, so both use independent
+// bits of the 128-bit hash.
+func bloomHash(key *FixedBlockKey, blockCount uint64) (block uint64, h1, h2 uint32) {
+	// Multiply-shift maps the hash onto [0, blockCount) without a division
+	block, _ = bits.Mul64(binary.LittleEndian.Uint64(key[0:8]), blockCount)
+
+	h1 = binary.LittleEndian.Uint32(key[8:12])
+	h2 = binary.LittleEndian.Uint32(key[12:16]) | 1 // odd, so positions don't repeat early
+	return block, h1, h2
+}
+
+// FixedBlockBloomFilter is a blocked Bloom filter for FixedBlockKeys. Each key
+// maps to a single 512-bit block, one cache line, in which it sets a few bits
+// picked by double hashing the already well-mixed key, so adding and looking
+// up a key touches one cache line and computes no hashes.
+//
+// A filter answers whether a key may have been added: false positives occur
+// at roughly the configured rate, while false negatives never do. That makes
+// it a cheap guard in front of slower lookups, such as a disk-backed map.
+type FixedBlockBloomFilter struct {
+	words  []uint64 // bloomBlockBits/64 words per block
+	blocks uint64
+	hashes int
+	count  uint64 // number of calls to Add
+}
+
+// NewFixedBlockBloomFilter creates a filter sized for expectedItems keys at
+// the given false-positive rate, such as 0.01 for 1%
+func NewFixedBlockBloomFilter(expectedItems uint64, falsePositiveRate float64) *FixedBlockBloomFilter {
+	blocks, hashes := bloomSize(expectedItems, falsePositiveRate)
+
+	return &FixedBlockBloomFilter{
+		words:  make([]uint64, blocks*bloomBlockBits/64),
+		blocks: blocks,
+		hashes: hashes,
+	}
+}
+
+// Add records a key in the filter
+func (f *FixedBlockBloomFilter) Add(key FixedBlockKey) {
+	block, h1, h2 := bloomHash(&key, f.blocks)
+	words := f.words[block*bloomBlockBits/64 : (block+1)*bloomBlockBits/64]
+
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint32(i)*h2) % bloomBlockBits
+		words[bit/64] |= 1 << (bit % 64)
+	}
+
+	f.count++
+}
+
+// MayContain reports whether key may have been added. It returns false only
+// for keys that were never added.
+func (f *FixedBlockBloomFilter) MayContain(key FixedBlockKey) bool {
+	block, h1, h2 := bloomHash(&key, f.blocks)
+	words := f.words[block*bloomBlockBits/64 : (block+1)*bloomBlockBits/64]
+
+	for i := 0; i < f.hashes; i++ {
+		bit := (h1 + uint32(i)*h2) % bloomBlockBits
+		if words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Count returns the number of times Add was called
+func (f *FixedBlockBloomFilter) Count() uint64 {
+	return f.count
+}
+
+// Hashes returns the number of bits set for every key
+func (f *FixedBlockBloomFilter) Hashes() int {
+	return f.hashes
+}
+
+// SizeBits returns the size of the filter in bits
+func (f *FixedBlockBloomFilter) SizeBits() uint64 {
+	return f.blocks * bloomBlockBits
+}
+
+// FillRatio returns the fraction of bits that are set. The false-positive
+// rate of the filter is close to FillRatio raised to the power of Hashes.
+func (f *FixedBlockBloomFilter) FillRatio() float64 {
+	var set int
+	for _, word := range f.words {
+		set += bits.OnesCount64(word)
+	}
+
+	return float64(set) / float64(f.SizeBits())
+}
+
+// Union adds every key of other to the filter. Both filters must have been
+// created with the same size and number of hashes.
+func (f *FixedBlockBloomFilter) Union(other *FixedBlockBloomFilter) error {
+	if f.blocks != other.blocks || f.hashes != other.hashes {
+		return fmt.Errorf("cannot union filters of %d blocks and %d hashes with %d blocks and %d hashes", f.blocks, f.hashes, other.blocks, other.hashes)
+	}
+
+	for i, word := range other.words {
+		f.words[i] |= word
+	}
+	f.count += other.count
+
+	return nil
+}
+
+// WriteTo writes the filter to an io.Writer. Every word is written in
+// little-endian byte order, so the data can be read on any architecture, and
+// is followed by a CRC32C checksum.
+func (f *FixedBlockBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return writeBloom(w, bloomMagic, f.blocks, f.hashes, f.count, f.words)
+}
+
+// ReadFrom replaces the filter with data written by WriteTo. The size of the
+// filter is read from the data, so the filter can be the zero value.
+func (f *FixedBlockBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	blocks, hashes, count, words, total, err := readBloom(r, bloomMagic, bloomBlockBits/64)
+	if err != nil {
+		return total, err
+	}
+
+	f.words, f.blocks, f.hashes, f.count = words, blocks, hashes, count
+	return total, nil
+}
+
+// FixedBlockCountingBloomFilter is a blocked Bloom filter whose bits are 4-bit
+// counters, so keys can be removed again. It uses the same hashing and sizing
+// as FixedBlockBloomFilter and four times the memory. Counters saturate at 15
+// and then stay there, so a key sharing a saturated counter is never removed
+// completely, which keeps the filter free of false negatives.
+type FixedBlockCountingBloomFilter struct {
+	words  []uint64 // 16 counters per word, bloomBlockBits/16 words per block
+	blocks uint64
+	hashes int
+	count  uint64 // number of keys added minus removed
+}
+
+const countingBloomWordsPerBlock = bloomBlockBits / 16
+
+// NewFixedBlockCountingBloomFilter creates a counting filter sized for
+// expectedItems keys at the given false-positive rate
+func NewFixedBlockCountingBloomFilter(expectedItems uint64, falsePositiveRate float64) *FixedBlockCountingBloomFilter {
+	blocks, hashes := bloomSize(expectedItems, falsePositiveRate)
+
+	return &FixedBlockCountingBloomFilter{
+		words:  make([]uint64, blocks*countingBloomWordsPerBlock),
+		blocks: blocks,
+		hashes: hashes,
+	}
+}
+
+// counters returns the words of the block of key and the double hashes
+func (f *FixedBlockCountingBloomFilter) counters(key *FixedBlockKey) ([]uint64, uint32, uint32) {
+	block, h1, h2 := bloomHash(key, f.blocks)
+	return f.words[block*countingBloomWordsPerBlock : (block+1)*countingBloomWordsPerBlock], h1, h2
+}
+
+// Add records a key in the filter
+func (f *FixedBlockCountingBloomFilter) Add(key FixedBlockKey) {
+	words, h1, h2 := f.counters(&key)
+
+	for i := 0; i < f.hashes; i++ {
+		counter := (h1 + uint32(i)*h2) % bloomBlockBits
+		word, shift := &words[counter/16], (counter%16)*4
+		if (*word>>shift)&0xF != 0xF {
+			*word += 1 << shift
+		}
+	}
+
+	f.count++
+}
+
+// MayContain reports whether key may have been added and not removed
+func (f *FixedBlockCountingBloomFilter) MayContain(key FixedBlockKey) bool {
+	words, h1, h2 := f.counters(&key)
+
+	for i := 0; i < f.hashes; i++ {
+		counter := (h1 + uint32(i)*h2) % bloomBlockBits
+		if (words[counter/16]>>((counter%16)*4))&0xF == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Remove removes a key that was added before and reports whether it may have
+// been present. Removing a key that was never added can remove other keys, so
+// keys for which MayContain returns false are ignored, but false positives
+// cannot be detected.
+func (f *FixedBlockCountingBloomFilter) Remove(key FixedBlockKey) bool {
+	if !f.MayContain(key) {
+		return false
+	}
+
+	words, h1, h2 := f.counters(&key)
+	for i := 0; i < f.hashes; i++ {
+		counter := (h1 + uint32(i)*h2) % bloomBlockBits
+		word, shift := &words[counter/16], (counter%16)*4
+		if value := (*word >> shift) & 0xF; value != 0 && value != 0xF {
+			*word -= 1 << shift
+		}
+	}
+
+	// Removing false positives could otherwise wrap the count around
+	if f.count > 0 {
+		f.count--
+	}
+	return true
+}
+
+// Count returns the number of keys added minus the number removed
+func (f *FixedBlockCountingBloomFilter) Count() uint64 {
+	return f.count
+}
+
+// Hashes returns the number of counters incremented for every key
+func (f *FixedBlockCountingBloomFilter) Hashes() int {
+	return f.hashes
+}
+
+// Union adds the counters of other to the filter, saturating at 15. Both
+// filters must have been created with the same size and number of hashes.
+func (f *FixedBlockCountingBloomFilter) Union(other *FixedBlockCountingBloomFilter) error {
+	if f.blocks != other.blocks || f.hashes != other.hashes {
+		return fmt.Errorf("cannot union filters of %d blocks and %d hashes with %d blocks and %d hashes", f.blocks, f.hashes, other.blocks, other.hashes)
+	}
+
+	for i, word := range other.words {
+		var sum uint64
+		for shift := 0; shift < 64; shift += 4 {
+			counter := min((f.words[i]>>shift)&0xF+(word>>shift)&0xF, 0xF)
+			sum |= counter << shift
+		}
+		f.words[i] = sum
+	}
+	f.count += other.count
+
+	return nil
+}
+
+// WriteTo writes the filter in the same style as FixedBlockBloomFilter.WriteTo
+func (f *FixedBlockCountingBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	return writeBloom(w, countingBloomMagic, f.blocks, f.hashes, f.count, f.words)
+}
+
+// ReadFrom replaces the filter with data written by WriteTo. The filter can be
+// the zero value.
+func (f *FixedBlockCountingBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	blocks, hashes, count, words, total, err := readBloom(r, countingBloomMagic, countingBloomWordsPerBlock)
+	if err != nil {
+		return total, err
+	}
+
+	f.words, f.blocks, f.hashes, f.count = words, blocks, hashes, count
+	return total, nil
+}
+
+// writeBloom writes a header followed by the words of a filter in
+// little-endian byte order and their CRC32C checksum
+func writeBloom(w io.Writer, magic [4]byte, blocks uint64, hashes int, count uint64, words []uint64) (int64, error) {
+	var header [bloomHeaderSize]byte
+	copy(header[0:4], magic[:])
+	binary.LittleEndian.PutUint32(header[4:8], bloomVersion)
+	binary.LittleEndian.PutUint64(header[8:16], blocks)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(hashes))
+	binary.LittleEndian.PutUint64(header[20:28], count)
+	binary.LittleEndian.PutUint32(header[28:32], crc32.Checksum(header[0:28], castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	// Encode the words in chunks to keep the number of writes low
+	var checksum uint32
+	buf := make([]byte, 0, min(len(words)*8, 64*1024))
+	for start := 0; start < len(words); start += cap(buf) / 8 {
+		buf = buf[:0]
+		for _, word := range words[start:min(start+cap(buf)/8, len(words))] {
+			buf = binary.LittleEndian.AppendUint64(buf, word)
+		}
+		checksum = crc32.Update(checksum, castagnoliTable, buf)
+
+		written, err = w.Write(buf)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	written, err = w.Write(binary.LittleEndian.AppendUint32(nil, checksum))
+	total += int64(written)
+	return total, err
+}
+
+// readBloom reads a filter written by writeBloom
+func readBloom(r io.Reader, magic [4]byte, wordsPerBlock uint64) (blocks uint64, hashes int, count uint64, words []uint64, total int64, err error) {
+	var header [bloomHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total = int64(read)
+	if err != nil {
+		return 0, 0, 0, nil, total, err
+	}
+
+	if [4]byte(header[0:4]) != magic {
+		return 0, 0, 0, nil, total, errors.New("invalid filter data: bad magic")
+	}
+	if crc32.Checksum(header[0:28], castagnoliTable) != binary.LittleEndian.Uint32(header[28:32]) {
+		return 0, 0, 0, nil, total, fmt.Errorf("invalid filter data: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != bloomVersion {
+		return 0, 0, 0, nil, total, fmt.Errorf("invalid filter data: unsupported version %d", version)
+	}
+
+	blocks = binary.LittleEndian.Uint64(header[8:16])
+	hashes = int(binary.LittleEndian.Uint32(header[16:20]))
+	count = binary.LittleEndian.Uint64(header[20:28])
+	if blocks == 0 || hashes < 1 || hashes > bloomMaxHashes {
+		return 0, 0, 0, nil, total, fmt.Errorf("invalid filter data: %d blocks with %d hashes", blocks, hashes)
+	}
+
+	// The header checksum can be computed over any block count, so the size is
+	// checked for overflow and the words grow with the data read
+	if blocks > math.MaxInt/8/wordsPerBlock {
+		return 0, 0, 0, nil, total, fmt.Errorf("invalid filter data: %d blocks do not fit in memory", blocks)
+	}
+	wordCount := int(blocks * wordsPerBlock)
+	buf := make([]byte, min(wordCount*8, 64*1024))
+	words = make([]uint64, 0, len(buf)/8)
+
+	var checksum uint32
+	for len(words) < wordCount {
+		chunk := buf[:min(wordCount-len(words), len(buf)/8)*8]
+
+		read, err = io.ReadFull(r, chunk)
+		total += int64(read)
+		if err != nil {
+			return 0, 0, 0, nil, total, unexpectedEOF(err)
+		}
+		checksum = crc32.Update(checksum, castagnoliTable, chunk)
+
+		for i := 0; i < len(chunk); i += 8 {
+			words = append(words, binary.LittleEndian.Uint64(chunk[i:]))
+		}
+	}
+
+	var stored [4]byte
+	read, err = io.ReadFull(r, stored[:])
+	total += int64(read)
+	if err != nil {
+		return 0, 0, 0, nil, total, unexpectedEOF(err)
+	}
+	if checksum != binary.LittleEndian.Uint32(stored[:]) {
+		return 0, 0, 0, nil, total, fmt.Errorf("invalid filter data: %w", ErrChecksumMismatch)
+	}
+
+	return blocks, hashes, count, words, total, nil
+}
@@ -0,0 +1,167 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func bloomKey(prefix string, i int) FixedBlockKey {
+	var key FixedBlockKey
+	key.FromString(fmt.Sprintf("%s%d", prefix, i))
+	return key
+}
+
+func TestFixedBlockBloomFilter_AddAndMayContain(t *testing.T) {
+	const items = 10000
+	f := NewFixedBlockBloomFilter(items, 0.01)
+
+	for i := 0; i < items; i++ {
+		f.Add(bloomKey("in", i))
+	}
+	assert.Equal(t, uint64(items), f.Count())
+
+	// No false negatives
+	for i := 0; i < items; i++ {
+		require.True(t, f.MayContain(bloomKey("in", i)))
+	}
+
+	// Blocking costs a little accuracy, so allow twice the configured rate
+	var falsePositives int
+	for i := 0; i < items; i++ {
+		if f.MayContain(bloomKey("out", i)) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, float64(falsePositives)/items, 0.02)
+	assert.InDelta(t, 0.5, f.FillRatio(), 0.1)
+}
+
+func TestFixedBlockBloomFilter_Union(t *testing.T) {
+	a := NewFixedBlockBloomFilter(1000, 0.01)
+	b := NewFixedBlockBloomFilter(1000, 0.01)
+	for i := 0; i < 100; i++ {
+		a.Add(bloomKey("a", i))
+		b.Add(bloomKey("b", i))
+	}
+
+	require.NoError(t, a.Union(b))
+	assert.Equal(t, uint64(200), a.Count())
+	for i := 0; i < 100; i++ {
+		assert.True(t, a.MayContain(bloomKey("a", i)))
+		assert.True(t, a.MayContain(bloomKey("b", i)))
+	}
+
+	assert.Error(t, a.Union(NewFixedBlockBloomFilter(100000, 0.01)))
+}
+
+func TestFixedBlockBloomFilter_WriteTo(t *testing.T) {
+	f := NewFixedBlockBloomFilter(5000, 0.001)
+	for i := 0; i < 5000; i++ {
+		f.Add(bloomKey("in", i))
+	}
+
+	var buf bytes.Buffer
+	written, err := f.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	var loaded FixedBlockBloomFilter
+	read, err := loaded.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, f, &loaded)
+
+	corrupted := bytes.Clone(data)
+	corrupted[bloomHeaderSize+5] ^= 0xFF
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// A corrupt block count fails on the missing data instead of allocating
+	// the announced words, and one whose size overflows is rejected
+	for blocks, expected := range map[uint64]string{1 << 40: io.ErrUnexpectedEOF.Error(), 1 << 60: "do not fit in memory"} {
+		huge := bytes.Clone(data)
+		binary.LittleEndian.PutUint64(huge[8:16], blocks)
+		binary.LittleEndian.PutUint32(huge[28:32], crc32.Checksum(huge[0:28], castagnoliTable))
+		_, err = loaded.ReadFrom(bytes.NewReader(huge))
+		assert.ErrorContains(t, err, expected)
+	}
+	assert.Equal(t, f, &loaded)
+
+	// A counting filter is not read as a plain one
+	buf.Reset()
+	_, err = NewFixedBlockCountingBloomFilter(10, 0.01).WriteTo(&buf)
+	require.NoError(t, err)
+	_, err = loaded.ReadFrom(&buf)
+	assert.Error(t, err)
+}
+
+func TestFixedBlockCountingBloomFilter_Remove(t *testing.T) {
+	f := NewFixedBlockCountingBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(bloomKey("in", i))
+	}
+
+	for i := 0; i < 500; i++ {
+		require.True(t, f.Remove(bloomKey("in", i)))
+	}
+	assert.Equal(t, uint64(500), f.Count())
+
+	// The remaining keys are still found and most removed ones are gone
+	var stillFound int
+	for i := 0; i < 1000; i++ {
+		if i >= 500 {
+			require.True(t, f.MayContain(bloomKey("in", i)))
+		} else if f.MayContain(bloomKey("in", i)) {
+			stillFound++
+		}
+	}
+	assert.Less(t, stillFound, 25)
+}
+
+func TestFixedBlockCountingBloomFilter_Saturation(t *testing.T) {
+	f := NewFixedBlockCountingBloomFilter(10, 0.01)
+	key := bloomKey("hot", 0)
+
+	// Counters stop at 15 and are never decremented afterwards
+	for i := 0; i < 20; i++ {
+		f.Add(key)
+	}
+	for i := 0; i < 25; i++ {
+		f.Remove(key)
+	}
+	assert.True(t, f.MayContain(key))
+
+	// Removing more keys than were added must not wrap the count around
+	assert.Equal(t, uint64(0), f.Count())
+
+	other := NewFixedBlockCountingBloomFilter(10, 0.01)
+	other.Add(bloomKey("cold", 0))
+	require.NoError(t, f.Union(other))
+	assert.True(t, f.MayContain(bloomKey("cold", 0)))
+	assert.True(t, f.Remove(bloomKey("cold", 0)))
+}
+
+func TestFixedBlockCountingBloomFilter_WriteTo(t *testing.T) {
+	f := NewFixedBlockCountingBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add(bloomKey("in", i))
+	}
+
+	var buf bytes.Buffer
+	_, err := f.WriteTo(&buf)
+	require.NoError(t, err)
+
+	var loaded FixedBlockCountingBloomFilter
+	_, err = loaded.ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, f, &loaded)
+	assert.True(t, loaded.Remove(bloomKey("in", 1)))
+}
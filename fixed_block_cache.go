@@ -0,0 +1,262 @@
+package collections
+
+import (
+	"iter"
+	"math/bits"
+)
+
+// FixedBlockCacheOptions configures a FixedBlockCache
+type FixedBlockCacheOptions[V any] struct {
+	// MaxProbe is the number of blocks, starting at a key's home block, in
+	// which the key can be stored. Lookups and insertions never look further,
+	// so it bounds the cost of every operation. Defaults to 4.
+	MaxProbe int
+
+	// OnEvict, if set, is called with every entry evicted to make room for
+	// a new one. It must not use the cache.
+	OnEvict func(key FixedBlockKey, value V)
+}
+
+// FixedBlockCacheStats holds the counters of a FixedBlockCache
+type FixedBlockCacheStats struct {
+	Hits      uint64 // lookups that found their key
+	Misses    uint64 // lookups that did not find their key
+	Evictions uint64 // entries evicted to make room for new ones
+}
+
+// cacheBlockState holds the CLOCK state of a block
+type cacheBlockState struct {
+	referenced uint8 // one bit per slot, set when the slot was used since the hand last passed it
+	hand       uint8 // next slot the CLOCK hand looks at
+}
+
+// FixedBlockCache is a bounded cache using the block layout of FixedBlockMap.
+// Instead of failing when the map is full, Put evicts an entry. Every key can
+// only be stored in the MaxProbe blocks following its home block (the probe
+// window), so operations never scan long chains. When the probe window of a
+// new key is full, the victim is picked among the entries of the window with
+// the CLOCK algorithm: every slot has a reference bit that is set when the
+// entry is read or updated, and a hand per block sweeps the slots, clearing
+// set bits and evicting the first entry whose bit is clear. Recently used
+// entries therefore get a second chance before being evicted.
+//
+// Get updates the reference bits and counters, so the cache is not safe for
+// concurrent use, not even by readers.
+type FixedBlockCache[V any] struct {
+	m        *FixedBlockMap[V]
+	state    []cacheBlockState
+	maxProbe uint64
+	onEvict  func(key FixedBlockKey, value V)
+	stats    FixedBlockCacheStats
+}
+
+// NewFixedBlockCache creates a cache holding up to capacity entries, rounded
+// up like the capacity of NewFixedBlockMap
+func NewFixedBlockCache[V any](capacity uint64, options FixedBlockCacheOptions[V]) *FixedBlockCache[V] {
+	m := NewFixedBlockMap[V](capacity)
+
+	maxProbe := uint64(options.MaxProbe)
+	if maxProbe == 0 {
+		maxProbe = 4
+	}
+
+	return &FixedBlockCache[V]{
+		m:        m,
+		state:    make([]cacheBlockState, len(m.blocks)),
+		maxProbe: min(maxProbe, uint64(len(m.blocks))),
+		onEvict:  options.OnEvict,
+	}
+}
+
+// Len returns the number of entries in the cache
+func (c *FixedBlockCache[V]) Len() uint64 {
+	return c.m.Len()
+}
+
+// Capacity returns the maximum number of entries in the cache
+func (c *FixedBlockCache[V]) Capacity() uint64 {
+	return c.m.Capacity()
+}
+
+// Stats returns the hit, miss and eviction counters
+func (c *FixedBlockCache[V]) Stats() FixedBlockCacheStats {
+	return c.stats
+}
+
+// ResetStats sets the counters back to zero
+func (c *FixedBlockCache[V]) ResetStats() {
+	c.stats = FixedBlockCacheStats{}
+}
+
+// find returns the block and slot holding key within its probe window, or -1
+// when it is not cached
+func (c *FixedBlockCache[V]) find(key FixedBlockKey) (uint64, int) {
+	blockIndex := c.m.hashToBlock(key)
+	tag := key[0] | 0x80
+
+	for probe := uint64(0); probe < c.maxProbe; probe++ {
+		block := &c.m.blocks[blockIndex]
+
+		result := matchTag(block.control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if block.keys[index] == key {
+				return blockIndex, index
+			}
+
+			result &= result - 1
+		}
+
+		// Keys are never stored past a block with an empty slot
+		if matchEmpty(block.control) != 0x0 {
+			break
+		}
+
+		blockIndex = (blockIndex + 1) & c.m.mask
+	}
+
+	return 0, -1
+}
+
+// Get returns the value cached for key and marks it as recently used
+func (c *FixedBlockCache[V]) Get(key FixedBlockKey) (*V, bool) {
+	blockIndex, index := c.find(key)
+	if index < 0 {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.state[blockIndex].referenced |= 1 << index
+	return &c.m.blocks[blockIndex].values[index], true
+}
+
+// Contains reports whether key is cached, without counting as a use
+func (c *FixedBlockCache[V]) Contains(key FixedBlockKey) bool {
+	_, index := c.find(key)
+	return index >= 0
+}
+
+// Put inserts or updates a key. When the probe window of the key is full, an
+// entry of the window is evicted to make room and reported to OnEvict; the
+// returned flag tells whether that happened.
+func (c *FixedBlockCache[V]) Put(key FixedBlockKey, value V) bool {
+	m := c.m
+	blockIndex := m.hashToBlock(key)
+	tag := key[0] | 0x80
+
+	freeIndex := -1
+	var freeBlockIndex uint64
+
+	for probe := uint64(0); probe < c.maxProbe; probe++ {
+		block := &m.blocks[blockIndex]
+
+		result := matchTag(block.control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if block.keys[index] == key {
+				m.touch(blockIndex)
+				block.values[index] = value
+				c.state[blockIndex].referenced |= 1 << index
+				return false
+			}
+
+			result &= result - 1
+		}
+
+		// Remember the first empty or deleted slot, but keep looking for
+		// the key until the end of the chain
+		if freeIndex < 0 {
+			for i := 0; i < FixedBlockSize; i++ {
+				if ctrl := block.controlByte(i); ctrl == 0x0 || ctrl == 0x1 {
+					freeBlockIndex, freeIndex = blockIndex, i
+					break
+				}
+			}
+		}
+
+		if matchEmpty(block.control) != 0x0 {
+			break
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+	}
+
+	if freeIndex >= 0 {
+		block := &m.blocks[freeBlockIndex]
+		if block.controlByte(freeIndex) == 0x1 {
+			m.tombstones--
+		}
+		m.count++
+
+		c.store(freeBlockIndex, freeIndex, key, value)
+		return false
+	}
+
+	victimBlockIndex, victimIndex := c.victim(m.hashToBlock(key))
+	victim := &m.blocks[victimBlockIndex]
+	if c.onEvict != nil {
+		c.onEvict(victim.keys[victimIndex], victim.values[victimIndex])
+	}
+	c.stats.Evictions++
+
+	c.store(victimBlockIndex, victimIndex, key, value)
+	return true
+}
+
+// store writes an entry into a slot. New entries start out unreferenced, so
+// they are evicted first unless they are used again.
+func (c *FixedBlockCache[V]) store(blockIndex uint64, index int, key FixedBlockKey, value V) {
+	c.m.touch(blockIndex)
+
+	block := &c.m.blocks[blockIndex]
+	block.setControlByte(index, key[0]|0x80)
+	block.keys[index] = key
+	block.values[index] = value
+	c.state[blockIndex].referenced &^= 1 << index
+}
+
+// victim runs the CLOCK hands over the full probe window starting at home and
+// returns the first slot whose reference bit is clear. The first sweep clears
+// every bit it passes, so the second sweep always finds a victim.
+func (c *FixedBlockCache[V]) victim(home uint64) (uint64, int) {
+	for {
+		blockIndex := home
+
+		for probe := uint64(0); probe < c.maxProbe; probe++ {
+			state := &c.state[blockIndex]
+
+			for i := 0; i < FixedBlockSize; i++ {
+				index := int(state.hand)
+				state.hand = (state.hand + 1) % FixedBlockSize
+
+				if state.referenced&(1<<index) == 0 {
+					return blockIndex, index
+				}
+				state.referenced &^= 1 << index
+			}
+
+			blockIndex = (blockIndex + 1) & c.m.mask
+		}
+	}
+}
+
+// Delete removes a key from the cache, returning whether it was cached.
+// Deleted entries are not reported to OnEvict.
+func (c *FixedBlockCache[V]) Delete(key FixedBlockKey) bool {
+	blockIndex, index := c.find(key)
+	if index < 0 {
+		return false
+	}
+
+	c.m.deleteSlot(blockIndex, index)
+	c.state[blockIndex].referenced &^= 1 << index
+
+	return true
+}
+
+// Iter returns an iterator over the keys and pointers to the values of every
+// cached entry. Iterating does not count as using the entries.
+func (c *FixedBlockCache[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
+	return c.m.Iter()
+}
@@ -0,0 +1,128 @@
+package collections
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sameHomeKeys returns keys that all hash to block 0, with distinct tags
+func sameHomeKeys(count int) []FixedBlockKey {
+	keys := make([]FixedBlockKey, count)
+	for i := range keys {
+		keys[i][8] = byte(i)
+		keys[i][9] = byte(i >> 8)
+	}
+
+	return keys
+}
+
+func TestFixedBlockCache_PutAndGet(t *testing.T) {
+	c := NewFixedBlockCache[testValue](64, FixedBlockCacheOptions[testValue]{})
+
+	var key FixedBlockKey
+	key.FromString("hot")
+
+	_, found := c.Get(key)
+	assert.False(t, found)
+
+	assert.False(t, c.Put(key, testValue{ID: 1}))
+	value, found := c.Get(key)
+	require.True(t, found)
+	assert.Equal(t, uint64(1), value.ID)
+
+	// Updating does not add an entry
+	assert.False(t, c.Put(key, testValue{ID: 2}))
+	assert.Equal(t, uint64(1), c.Len())
+
+	assert.Equal(t, FixedBlockCacheStats{Hits: 1, Misses: 1}, c.Stats())
+	c.ResetStats()
+	assert.Equal(t, FixedBlockCacheStats{}, c.Stats())
+
+	assert.True(t, c.Delete(key))
+	assert.False(t, c.Delete(key))
+	assert.False(t, c.Contains(key))
+	assert.Equal(t, uint64(0), c.Len())
+}
+
+func TestFixedBlockCache_Eviction(t *testing.T) {
+	var evicted []FixedBlockKey
+	c := NewFixedBlockCache[testValue](64, FixedBlockCacheOptions[testValue]{
+		MaxProbe: 2,
+		OnEvict: func(key FixedBlockKey, value testValue) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	// The probe window of these keys holds two blocks
+	keys := sameHomeKeys(2*FixedBlockSize + 4)
+	for i, key := range keys[:2*FixedBlockSize] {
+		assert.False(t, c.Put(key, testValue{ID: uint64(i)}))
+	}
+	assert.Empty(t, evicted)
+
+	// Use every entry except keys[3], which becomes the victim
+	for i, key := range keys[:2*FixedBlockSize] {
+		if i != 3 {
+			_, found := c.Get(key)
+			require.True(t, found)
+		}
+	}
+
+	assert.True(t, c.Put(keys[2*FixedBlockSize], testValue{}))
+	assert.Equal(t, []FixedBlockKey{keys[3]}, evicted)
+	assert.False(t, c.Contains(keys[3]))
+	assert.True(t, c.Contains(keys[2*FixedBlockSize]))
+	assert.Equal(t, uint64(2*FixedBlockSize), c.Len())
+
+	// Once every bit has been cleared, later insertions keep evicting
+	for _, key := range keys[2*FixedBlockSize+1:] {
+		assert.True(t, c.Put(key, testValue{}))
+	}
+	assert.Len(t, evicted, 4)
+	assert.Equal(t, uint64(len(evicted)), c.Stats().Evictions)
+	assert.Equal(t, uint64(2*FixedBlockSize), c.Len())
+
+	// Keys hashing elsewhere are not affected by the full window
+	var other FixedBlockKey
+	other[0] = 5
+	assert.False(t, c.Put(other, testValue{}))
+}
+
+func TestFixedBlockCache_ReusesDeletedSlots(t *testing.T) {
+	c := NewFixedBlockCache[testValue](64, FixedBlockCacheOptions[testValue]{MaxProbe: 1})
+
+	keys := sameHomeKeys(FixedBlockSize + 1)
+	for _, key := range keys[:FixedBlockSize] {
+		c.Put(key, testValue{})
+	}
+
+	c.Delete(keys[0])
+	assert.False(t, c.Put(keys[FixedBlockSize], testValue{}))
+	assert.Equal(t, uint64(0), c.Stats().Evictions)
+	assert.Equal(t, uint64(FixedBlockSize), c.Len())
+}
+
+func TestFixedBlockCache_Workload(t *testing.T) {
+	c := NewFixedBlockCache[testValue](256, FixedBlockCacheOptions[testValue]{})
+
+	// Far more keys than the capacity never fail and never exceed it
+	keys := make([]FixedBlockKey, 2000)
+	for i := range keys {
+		keys[i].FromString(fmt.Sprintf("cache%d", i))
+		c.Put(keys[i], testValue{ID: uint64(i)})
+	}
+	assert.LessOrEqual(t, c.Len(), c.Capacity())
+	assert.Greater(t, c.Stats().Evictions, uint64(0))
+
+	// Every cached entry is still found with its own value
+	var count uint64
+	for key, value := range c.Iter() {
+		assert.Equal(t, keys[value.ID], key)
+		count++
+	}
+	assert.Equal(t, c.Len(), count)
+	require.NoError(t, c.m.Validate())
+}
@@ -0,0 +1,363 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"unsafe"
+)
+
+const (
+	// cuckooMaxKicks is the number of fingerprints relocated before an insert
+	// gives up and parks the last one as the victim
+	cuckooMaxKicks = 500
+
+	// cuckooFullLoad is the load factor at which inserts start to fail
+	cuckooFullLoad = 0.90
+
+	cuckooVersion    = 2
+	cuckooHeaderSize = 44
+
+	// cuckooReadBuckets is the number of buckets ReadFrom reads at a time
+	cuckooReadBuckets = 1 << 13
+)
+
+var cuckooMagic = [4]byte{'F', 'B', 'C', 'F'}
+
+// FixedBlockCuckooFilterInfo holds statistics about a FixedBlockCuckooFilter
+type FixedBlockCuckooFilterInfo struct {
+	// ratio of stored fingerprints to capacity
+	LoadFactor float32
+
+	// expected ratio of lookups of absent keys that report a match
+	FalsePositiveRate float32
+
+	// set to true when the LoadFactor is high enough that inserts are likely
+	// to fail. A filter cannot grow, so a larger one must be built from the keys.
+	NearlyFull bool
+}
+
+// cuckooVictim holds the fingerprint left over when an insert ran out of kicks
+type cuckooVictim struct {
+	index       uint64
+	fingerprint uint8
+	used        bool
+}
+
+// FixedBlockCuckooFilter is a cuckoo filter for FixedBlockKeys. Every bucket
+// packs eight one-byte fingerprints into a uint64, laid out like the control
+// word of a FixedBlock, so a bucket is searched with the same SWAR matching
+// that FixedBlockMap.Get uses. A key's fingerprint is stored in one of two
+// buckets: its home bucket, picked like a FixedBlockMap block, and an
+// alternate bucket derived from the home bucket and the fingerprint alone, so
+// fingerprints can be moved between their buckets without the keys.
+//
+// Unlike a Bloom filter, keys can be deleted. Like any approximate set, a
+// lookup of an absent key reports a match at a small rate, and deleting a key
+// that was never inserted can remove another key's fingerprint.
+type FixedBlockCuckooFilter struct {
+	buckets []uint64
+	mask    uint64
+	count   uint64 // number of stored fingerprints, including the victim
+	victim  cuckooVictim
+	rng     uint64 // xorshift state for picking fingerprints to kick out
+}
+
+// NewFixedBlockCuckooFilter initializes the filter to hold the given number
+// of keys. Inserts start failing at around 90% of the capacity.
+func NewFixedBlockCuckooFilter(capacity uint64) *FixedBlockCuckooFilter {
+	bucketCount := calculateBlockCount(uint64(math.Ceil(float64(capacity) / cuckooFullLoad)))
+
+	return &FixedBlockCuckooFilter{
+		buckets: make([]uint64, bucketCount),
+		mask:    bucketCount - 1,
+		rng:     0x9E3779B97F4A7C15,
+	}
+}
+
+// fingerprint returns the home bucket and the fingerprint of a key. The
+// fingerprint comes from the second half of the key, so it is independent of
+// the bucket, and is never zero, which marks an empty slot.
+func (f *FixedBlockCuckooFilter) fingerprint(key *FixedBlockKey) (uint64, uint8) {
+	fingerprint := key[8]
+	if fingerprint == 0 {
+		fingerprint = 1
+	}
+
+	return key.blockHash() & f.mask, fingerprint
+}
+
+// alternate returns the other bucket of a fingerprint stored in bucket index.
+// Applying it twice returns the original bucket.
+func (f *FixedBlockCuckooFilter) alternate(index uint64, fingerprint uint8) uint64 {
+	return (index ^ (uint64(fingerprint) * 0x5BD1E9955BD1E995)) & f.mask
+}
+
+// store puts a fingerprint into an empty slot of a bucket
+func (f *FixedBlockCuckooFilter) store(index uint64, fingerprint uint8) bool {
+	empty := matchEmpty(f.buckets[index])
+	if empty == 0 {
+		return false
+	}
+
+	// The lowest flagged byte is always a true match, higher ones may not be
+	shift := bits.TrailingZeros64(empty) &^ 7
+	f.buckets[index] |= uint64(fingerprint) << shift
+	return true
+}
+
+// remove clears one slot holding fingerprint in a bucket
+func (f *FixedBlockCuckooFilter) remove(index uint64, fingerprint uint8) bool {
+	match := matchTag(f.buckets[index], fingerprint)
+	if match == 0 {
+		return false
+	}
+
+	shift := bits.TrailingZeros64(match) &^ 7
+	f.buckets[index] &^= 0xFF << shift
+	return true
+}
+
+// random returns the next value of the xorshift generator
+func (f *FixedBlockCuckooFilter) random() uint64 {
+	f.rng ^= f.rng << 13
+	f.rng ^= f.rng >> 7
+	f.rng ^= f.rng << 17
+	return f.rng
+}
+
+// Insert adds a key to the filter. When both buckets of the key are full,
+// fingerprints are moved to their alternate buckets to make room. If that
+// fails the last displaced fingerprint is kept aside, so no key is lost, and
+// further inserts return an error until a key is deleted.
+func (f *FixedBlockCuckooFilter) Insert(key FixedBlockKey) error {
+	if f.victim.used {
+		return errors.New("filter overflow: no empty slots available")
+	}
+
+	index, fingerprint := f.fingerprint(&key)
+	if f.store(index, fingerprint) || f.store(f.alternate(index, fingerprint), fingerprint) {
+		f.count++
+		return nil
+	}
+
+	// Evict a random fingerprint from one of the buckets and move it to its
+	// other bucket, repeating until one of them finds an empty slot
+	if f.random()&1 == 0 {
+		index = f.alternate(index, fingerprint)
+	}
+
+	for kick := 0; kick < cuckooMaxKicks; kick++ {
+		shift := (f.random() % FixedBlockSize) * 8
+		evicted := uint8(f.buckets[index] >> shift)
+		f.buckets[index] = f.buckets[index]&^(0xFF<<shift) | uint64(fingerprint)<<shift
+
+		fingerprint = evicted
+		index = f.alternate(index, fingerprint)
+		if f.store(index, fingerprint) {
+			f.count++
+			return nil
+		}
+	}
+
+	f.victim = cuckooVictim{index: index, fingerprint: fingerprint, used: true}
+	f.count++
+	return nil
+}
+
+// Contains reports whether key may have been inserted. It returns false only
+// for keys that were never inserted or have been deleted.
+func (f *FixedBlockCuckooFilter) Contains(key FixedBlockKey) bool {
+	index, fingerprint := f.fingerprint(&key)
+	alternate := f.alternate(index, fingerprint)
+
+	if matchTag(f.buckets[index], fingerprint) != 0 || matchTag(f.buckets[alternate], fingerprint) != 0 {
+		return true
+	}
+
+	return f.victim.used && f.victim.fingerprint == fingerprint &&
+		(f.victim.index == index || f.victim.index == alternate)
+}
+
+// Delete removes a key that was inserted before and reports whether a
+// matching fingerprint was found. Only delete keys that were inserted, since
+// a false positive removes the fingerprint of another key.
+func (f *FixedBlockCuckooFilter) Delete(key FixedBlockKey) bool {
+	index, fingerprint := f.fingerprint(&key)
+	alternate := f.alternate(index, fingerprint)
+
+	switch {
+	case f.remove(index, fingerprint), f.remove(alternate, fingerprint):
+	case f.victim.used && f.victim.fingerprint == fingerprint &&
+		(f.victim.index == index || f.victim.index == alternate):
+		f.victim = cuckooVictim{}
+	default:
+		return false
+	}
+
+	f.count--
+
+	// A slot was freed, which may make room for the victim
+	if victim := f.victim; victim.used {
+		if f.store(victim.index, victim.fingerprint) || f.store(f.alternate(victim.index, victim.fingerprint), victim.fingerprint) {
+			f.victim = cuckooVictim{}
+		}
+	}
+
+	return true
+}
+
+// Len returns the number of keys in the filter
+func (f *FixedBlockCuckooFilter) Len() uint64 {
+	return f.count
+}
+
+// Capacity returns the number of fingerprint slots
+func (f *FixedBlockCuckooFilter) Capacity() uint64 {
+	return uint64(len(f.buckets)) * FixedBlockSize
+}
+
+// CollectInfo returns statistics about the filter
+func (f *FixedBlockCuckooFilter) CollectInfo() FixedBlockCuckooFilterInfo {
+	var loadFactor float64
+	if capacity := f.Capacity(); capacity > 0 {
+		loadFactor = float64(f.count) / float64(capacity)
+	}
+
+	// A lookup compares the fingerprint with the occupied slots of two
+	// buckets, each matching by chance with probability 1/255
+	occupied := 2 * FixedBlockSize * loadFactor
+	falsePositiveRate := 1 - math.Pow(1-1.0/255, occupied)
+
+	return FixedBlockCuckooFilterInfo{
+		LoadFactor:        float32(loadFactor),
+		FalsePositiveRate: float32(falsePositiveRate),
+		NearlyFull:        loadFactor >= cuckooFullLoad || f.victim.used,
+	}
+}
+
+// bucketBytes maps the memory of the buckets directly to a []byte
+func bucketBytes(buckets []uint64) []byte {
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&buckets[0])), len(buckets)*8)
+}
+
+// WriteTo writes the filter as raw memory: a header followed by the buckets.
+// Since matching ignores the position of a fingerprint in its bucket, the
+// byte order of the buckets does not matter and, unlike FixedBlockMap.WriteTo,
+// the data can be read on any architecture.
+func (f *FixedBlockCuckooFilter) WriteTo(w io.Writer) (int64, error) {
+	data := bucketBytes(f.buckets)
+
+	var header [cuckooHeaderSize]byte
+	copy(header[0:4], cuckooMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], cuckooVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(f.buckets)))
+	binary.LittleEndian.PutUint64(header[16:24], f.count)
+	if f.victim.used {
+		binary.LittleEndian.PutUint64(header[24:32], f.victim.index)
+		header[32] = f.victim.fingerprint
+	}
+	binary.LittleEndian.PutUint32(header[36:40], crc32.Checksum(data, castagnoliTable))
+	binary.LittleEndian.PutUint32(header[40:44], crc32.Checksum(header[0:40], castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	written, err = w.Write(data)
+	return total + int64(written), err
+}
+
+// ReadFrom replaces the filter with data written by WriteTo. The filter can be
+// the zero value, and is left untouched when an error is returned.
+func (f *FixedBlockCuckooFilter) ReadFrom(r io.Reader) (int64, error) {
+	var header [cuckooHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != cuckooMagic {
+		return total, errors.New("invalid filter data: bad magic")
+	}
+	if crc32.Checksum(header[0:40], castagnoliTable) != binary.LittleEndian.Uint32(header[40:44]) {
+		return total, fmt.Errorf("invalid filter data: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != cuckooVersion {
+		return total, fmt.Errorf("invalid filter data: unsupported version %d", version)
+	}
+
+	bucketCount := binary.LittleEndian.Uint64(header[8:16])
+	if bucketCount == 0 || bucketCount&(bucketCount-1) != 0 {
+		return total, fmt.Errorf("invalid filter data: bucket count %d is not a power of two", bucketCount)
+	}
+
+	if bucketCount > math.MaxInt/8 {
+		return total, fmt.Errorf("invalid filter data: %d buckets do not fit in memory", bucketCount)
+	}
+
+	// The buckets grow with the data read, so a corrupt bucket count cannot
+	// request a huge allocation
+	buckets := make([]uint64, 0, min(bucketCount, cuckooReadBuckets))
+	var checksum uint32
+	for uint64(len(buckets)) < bucketCount {
+		n := int(min(bucketCount-uint64(len(buckets)), cuckooReadBuckets))
+		buckets = slices.Grow(buckets, n)[:len(buckets)+n]
+		data := bucketBytes(buckets[len(buckets)-n:])
+
+		read, err = io.ReadFull(r, data)
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+		checksum = crc32.Update(checksum, castagnoliTable, data)
+	}
+	if checksum != binary.LittleEndian.Uint32(header[36:40]) {
+		return total, fmt.Errorf("invalid filter data: buckets %w", ErrChecksumMismatch)
+	}
+
+	var victim cuckooVictim
+	if header[32] != 0 {
+		victim = cuckooVictim{index: binary.LittleEndian.Uint64(header[24:32]), fingerprint: header[32], used: true}
+		if victim.index >= bucketCount {
+			return total, fmt.Errorf("%w: victim bucket %d is out of range", ErrCorruptMap, victim.index)
+		}
+	}
+
+	// The count must match the occupied slots. Unlike matchEmpty, which may
+	// flag bytes above an empty one, this sets the high bit of exactly the
+	// non-zero bytes, since adding 0x7F to the low seven bits never carries.
+	var stored uint64
+	for _, bucket := range buckets {
+		occupied := ((bucket & 0x7F7F7F7F7F7F7F7F) + 0x7F7F7F7F7F7F7F7F | bucket) & 0x8080808080808080
+		stored += uint64(bits.OnesCount64(occupied))
+	}
+	if victim.used {
+		stored++
+	}
+	if count := binary.LittleEndian.Uint64(header[16:24]); count != stored {
+		return total, fmt.Errorf("%w: count %d does not match %d stored fingerprints", ErrCorruptMap, count, stored)
+	}
+
+	*f = FixedBlockCuckooFilter{
+		buckets: buckets,
+		mask:    bucketCount - 1,
+		count:   stored,
+		victim:  victim,
+		rng:     0x9E3779B97F4A7C15,
+	}
+
+	return total, nil
+}
@@ -0,0 +1,121 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockCuckooFilter_InsertContainsDelete(t *testing.T) {
+	const items = 5000
+	f := NewFixedBlockCuckooFilter(items)
+
+	for i := 0; i < items; i++ {
+		require.NoError(t, f.Insert(bloomKey("in", i)))
+	}
+	assert.Equal(t, uint64(items), f.Len())
+
+	for i := 0; i < items; i++ {
+		require.True(t, f.Contains(bloomKey("in", i)))
+	}
+
+	info := f.CollectInfo()
+	var falsePositives int
+	for i := 0; i < items; i++ {
+		if f.Contains(bloomKey("out", i)) {
+			falsePositives++
+		}
+	}
+	assert.InDelta(t, info.FalsePositiveRate, float64(falsePositives)/items, 0.01)
+
+	// Deleting half of the keys keeps the other half
+	for i := 0; i < items; i += 2 {
+		require.True(t, f.Delete(bloomKey("in", i)))
+	}
+	for i := 1; i < items; i += 2 {
+		require.True(t, f.Contains(bloomKey("in", i)))
+	}
+	assert.Equal(t, uint64(items/2), f.Len())
+}
+
+func TestFixedBlockCuckooFilter_Full(t *testing.T) {
+	f := NewFixedBlockCuckooFilter(100)
+	capacity := f.Capacity()
+
+	// Insert until the victim is taken and inserts fail
+	var inserted int
+	for ; inserted < 2*int(capacity); inserted++ {
+		if err := f.Insert(bloomKey("in", inserted)); err != nil {
+			break
+		}
+	}
+	assert.True(t, f.victim.used)
+	assert.Equal(t, uint64(inserted), f.Len())
+	assert.Greater(t, float64(inserted), 0.85*float64(capacity))
+	assert.True(t, f.CollectInfo().NearlyFull)
+
+	// Every inserted key is still found, including the one parked as victim
+	for i := 0; i < inserted; i++ {
+		require.True(t, f.Contains(bloomKey("in", i)), "key %d", i)
+	}
+
+	// Deleting keys eventually frees a slot in one of the victim's buckets
+	for i := 0; f.victim.used; i++ {
+		require.True(t, f.Delete(bloomKey("in", i)))
+	}
+	require.NoError(t, f.Insert(bloomKey("again", 0)))
+}
+
+func TestFixedBlockCuckooFilter_WriteTo(t *testing.T) {
+	f := NewFixedBlockCuckooFilter(1000)
+	for i := 0; i < 900; i++ {
+		require.NoError(t, f.Insert(bloomKey("in", i)))
+	}
+
+	var buf bytes.Buffer
+	written, err := f.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	var loaded FixedBlockCuckooFilter
+	read, err := loaded.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, f.Len(), loaded.Len())
+	assert.Equal(t, f.buckets, loaded.buckets)
+	for i := 0; i < 900; i++ {
+		require.True(t, loaded.Contains(bloomKey("in", i)))
+	}
+
+	corrupted := bytes.Clone(data)
+	corrupted[cuckooHeaderSize+1] ^= 0xFF
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	corrupted = bytes.Clone(data)
+	corrupted[16]++
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// A header with a valid checksum is still checked against the buckets
+	binary.LittleEndian.PutUint32(corrupted[40:44], crc32.Checksum(corrupted[0:40], castagnoliTable))
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrCorruptMap)
+
+	// A corrupt bucket count fails on the missing data instead of allocating
+	// the announced buckets, and one whose size overflows is rejected
+	for buckets, expected := range map[uint64]string{1 << 40: io.ErrUnexpectedEOF.Error(), 1 << 62: "do not fit in memory"} {
+		huge := bytes.Clone(data)
+		binary.LittleEndian.PutUint64(huge[8:16], buckets)
+		binary.LittleEndian.PutUint32(huge[40:44], crc32.Checksum(huge[0:40], castagnoliTable))
+		_, err = loaded.ReadFrom(bytes.NewReader(huge))
+		assert.ErrorContains(t, err, expected)
+	}
+	assert.Equal(t, f.buckets, loaded.buckets)
+}
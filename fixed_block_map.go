@@ -5,10 +5,11 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"iter"
+	"math"
 	"math/bits"
-	"reflect"
 	"unsafe"
 
 	"github.com/cespare/xxhash/v2"
@@ -39,6 +40,14 @@ func (k *FixedBlockKey) FromString(text string) {
 	binary.LittleEndian.PutUint64(k[8:16], h2)
 }
 
+// blockHash returns the part of the key used to pick a block
+func (k *FixedBlockKey) blockHash() uint64 {
+	// Use the first 8 bytes of the hash-key to pick the block. Reading them as
+	// little-endian keeps the block index the same on every architecture, and
+	// compiles down to a single load on little-endian machines.
+	return binary.LittleEndian.Uint64(k[0:8])
+}
+
 type FixedBlockMapInfo struct {
 	// ratio of stored entities to capacity
 	LoadFactor float32
@@ -72,9 +81,39 @@ func (b *FixedBlock[V]) setControlByte(index int, value uint8) {
 	b.control = (b.control &^ (0xFF << shift)) | (uint64(value) << shift)
 }
 
+// matchTag returns a mask with the high bit set in every byte of control
+// that equals tag. Bytes are tested in parallel using SWAR bit tricks.
+func matchTag(control uint64, tag uint8) uint64 {
+	match := control ^ (uint64(tag) * 0x0101010101010101)
+	return (match - 0x0101010101010101) & ^match & 0x8080808080808080
+}
+
+// matchEmpty returns a mask with the high bit set in every empty (0x0) byte of control
+func matchEmpty(control uint64) uint64 {
+	return (control - 0x0101010101010101) & ^control & 0x8080808080808080
+}
+
 type FixedBlockMap[V any] struct {
-	blocks []FixedBlock[V]
-	mask   uint64
+	blocks     []FixedBlock[V]
+	mask       uint64
+	count      uint64 // number of stored entries
+	tombstones uint64 // number of deleted slots
+	snapshots  []*FixedBlockMapSnapshot[V]
+	dirty      []uint64 // bitmap of blocks modified since the last ClearDirty
+	allDirty   bool     // every block counts as modified
+}
+
+// touch must be called right before a block is modified
+func (m *FixedBlockMap[V]) touch(blockIndex uint64) {
+	m.markDirty(blockIndex)
+	m.preserve(blockIndex)
+}
+
+// touchAll must be called right before every block may be modified or the
+// blocks are replaced
+func (m *FixedBlockMap[V]) touchAll() {
+	m.markAllDirty()
+	m.preserveAll()
 }
 
 // calculateBlockCount calculates the number of blocks needed for a given capacity.
@@ -103,6 +142,8 @@ func NewFixedBlockMap[V any](capacity uint64) *FixedBlockMap[V] {
 	}
 }
 
+// Iter returns an iterator over the keys and pointers to the values of every
+// entry in the map
 func (m *FixedBlockMap[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
 	return func(yield func(FixedBlockKey, *V) bool) {
 		for blockIndex := range m.blocks {
@@ -121,6 +162,11 @@ func (m *FixedBlockMap[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
 	}
 }
 
+// Len returns the number of entries stored in the map
+func (m *FixedBlockMap[V]) Len() uint64 {
+	return m.count
+}
+
 // Capacity returns the maximum capacity of the map
 func (m *FixedBlockMap[V]) Capacity() uint64 {
 	var capacity uint64
@@ -130,24 +176,26 @@ func (m *FixedBlockMap[V]) Capacity() uint64 {
 
 // hashToBlock takes the 16-byte key (already a hash) and returns the starting block index.
 func (m *FixedBlockMap[V]) hashToBlock(key FixedBlockKey) uint64 {
-	// Use the first 8 bytes of the hash-key to pick the block
-	// Direct memory read - assumes little-endian architecture
-	return *(*uint64)(unsafe.Pointer(&key[0])) & m.mask
+	return key.blockHash() & m.mask
 }
 
 // Get searches for a 16-byte key
 func (m *FixedBlockMap[V]) Get(key FixedBlockKey) (*V, bool) {
+	if len(m.blocks) == 0 {
+		return nil, false
+	}
+
 	blockIndex := m.hashToBlock(key)
 	tag := key[0] | 0x80 // MSB set + tag
 
-	for {
+	// A full map, or one whose free slots are all tombstones, has no empty
+	// slot to end the search, so stop after visiting every block
+	for probed := uint64(0); probed <= m.mask; probed++ {
 		block := &m.blocks[blockIndex]
 		control := block.control
 
 		// Parallel search for the tag
-		target := uint64(tag) * 0x0101010101010101
-		match := control ^ target
-		result := (match - 0x0101010101010101) & ^match & 0x8080808080808080
+		result := matchTag(control, tag)
 
 		for result != 0 {
 			index := bits.TrailingZeros64(result) / 8
@@ -160,13 +208,15 @@ func (m *FixedBlockMap[V]) Get(key FixedBlockKey) (*V, bool) {
 
 		// Check for an 'Empty' slot in this block to terminate search early
 		// Logic: if any byte in control is 0x00, the search ends.
-		if (control-0x0101010101010101) & ^control & 0x8080808080808080 != 0x0 {
+		if matchEmpty(control) != 0x0 {
 			return nil, false
 		}
 
 		// Block full/no match. Probe to the next block
 		blockIndex = (blockIndex + 1) & m.mask
 	}
+
+	return nil, false
 }
 
 // Put inserts or updates a key
@@ -175,6 +225,7 @@ func (m *FixedBlockMap[V]) Put(key FixedBlockKey, value V) error {
 	tag := key[0] | 0x80
 
 	var firstDeletedBlock *FixedBlock[V]
+	var firstDeletedBlockIndex uint64
 	var firstDeletedIndex int = -1
 
 	for {
@@ -182,13 +233,12 @@ func (m *FixedBlockMap[V]) Put(key FixedBlockKey, value V) error {
 		control := block.control
 
 		// Check if key already exists (Update)
-		target := uint64(tag) * 0x0101010101010101
-		match := control ^ target
-		result := (match - 0x0101010101010101) & ^match & 0x8080808080808080
+		result := matchTag(control, tag)
 
 		for result != 0 {
 			index := bits.TrailingZeros64(result) / 8
 			if block.keys[index] == key {
+				m.touch(blockIndex)
 				block.values[index] = value
 				return nil
 			}
@@ -202,21 +252,27 @@ func (m *FixedBlockMap[V]) Put(key FixedBlockKey, value V) error {
 			if ctrl == 0x0 {
 				// If we found a tombstone earlier, use that instead to keep the chain short
 				if firstDeletedBlock != nil {
+					m.touch(firstDeletedBlockIndex)
 					firstDeletedBlock.setControlByte(firstDeletedIndex, tag)
 					firstDeletedBlock.keys[firstDeletedIndex] = key
 					firstDeletedBlock.values[firstDeletedIndex] = value
+					m.count++
+					m.tombstones--
 
 					return nil
 				}
 
+				m.touch(blockIndex)
 				block.setControlByte(i, tag)
 				block.keys[i] = key
 				block.values[i] = value
+				m.count++
 
 				return nil
 			}
 			if ctrl == 0x1 && firstDeletedBlock == nil {
 				firstDeletedBlock = block
+				firstDeletedBlockIndex = blockIndex
 				firstDeletedIndex = i
 			}
 		}
@@ -233,21 +289,24 @@ func (m *FixedBlockMap[V]) Put(key FixedBlockKey, value V) error {
 
 // Delete marks a slot as deleted
 func (m *FixedBlockMap[V]) Delete(key FixedBlockKey) {
+	if len(m.blocks) == 0 {
+		return
+	}
+
 	blockIndex := m.hashToBlock(key)
 	tag := key[0] | 0x80
 
-	for {
+	// Stop after visiting every block, like Get
+	for probed := uint64(0); probed <= m.mask; probed++ {
 		block := &m.blocks[blockIndex]
 		control := block.control
 
-		target := uint64(tag) * 0x0101010101010101
-		match := control ^ target
-		result := (match - 0x0101010101010101) & ^match & 0x8080808080808080
+		result := matchTag(control, tag)
 
 		for result != 0 {
 			index := bits.TrailingZeros64(result) / 8
 			if block.keys[index] == key {
-				block.setControlByte(index, 0x1)
+				m.deleteSlot(blockIndex, index)
 				return
 			}
 
@@ -255,7 +314,7 @@ func (m *FixedBlockMap[V]) Delete(key FixedBlockKey) {
 		}
 
 		// If we hit an empty slot, the key isn't in the map
-		if (control-0x0101010101010101) & ^control & 0x8080808080808080 != 0x0 {
+		if matchEmpty(control) != 0x0 {
 			return
 		}
 
@@ -263,13 +322,22 @@ func (m *FixedBlockMap[V]) Delete(key FixedBlockKey) {
 	}
 }
 
+// deleteSlot marks an occupied slot as deleted
+func (m *FixedBlockMap[V]) deleteSlot(blockIndex uint64, index int) {
+	m.touch(blockIndex)
+	m.blocks[blockIndex].setControlByte(index, 0x1)
+	m.count--
+	m.tombstones++
+}
+
 func (m *FixedBlockMap[V]) CollectInfo() FixedBlockMapInfo {
-	var storedEntities uint64
-	var tombstones uint64
-	totalSlots := m.Capacity()
+	storedEntities, tombstones := m.countSlots(0, len(m.blocks))
+	return newFixedBlockMapInfo(storedEntities, tombstones, m.Capacity())
+}
 
-	// Count stored entities and tombstones
-	for blockIndex := range m.blocks {
+// countSlots counts the stored entities and tombstones in blocks [lo, hi)
+func (m *FixedBlockMap[V]) countSlots(lo, hi int) (storedEntities, tombstones uint64) {
+	for blockIndex := lo; blockIndex < hi; blockIndex++ {
 		block := &m.blocks[blockIndex]
 		for i := 0; i < FixedBlockSize; i++ {
 			ctrl := block.controlByte(i)
@@ -283,6 +351,11 @@ func (m *FixedBlockMap[V]) CollectInfo() FixedBlockMapInfo {
 		}
 	}
 
+	return storedEntities, tombstones
+}
+
+// newFixedBlockMapInfo calculates the map statistics from the slot counts
+func newFixedBlockMapInfo(storedEntities, tombstones, totalSlots uint64) FixedBlockMapInfo {
 	// Calculate factors
 	var loadFactor float32
 	var tombstoneFactor float32
@@ -304,10 +377,20 @@ func (m *FixedBlockMap[V]) CollectInfo() FixedBlockMapInfo {
 // This function performs in-place rehashing without allocating additional memory for
 // collecting entries, making it efficient for maps with millions of entries.
 func (m *FixedBlockMap[V]) Rehash() error {
+	// Every block may change
+	m.touchAll()
+
 	//--==============================================================================--
 	//--== Convert all deleted slots (0x1) to empty slots (0x0)
 	//--==============================================================================--
-	for blockIndex := range m.blocks {
+	m.clearTombstones(0, len(m.blocks))
+
+	return m.repositionEntries()
+}
+
+// clearTombstones converts the deleted slots in blocks [lo, hi) to empty slots
+func (m *FixedBlockMap[V]) clearTombstones(lo, hi int) {
+	for blockIndex := lo; blockIndex < hi; blockIndex++ {
 		block := &m.blocks[blockIndex]
 		for i := 0; i < FixedBlockSize; i++ {
 			if block.controlByte(i) == 0x1 {
@@ -315,6 +398,18 @@ func (m *FixedBlockMap[V]) Rehash() error {
 			}
 		}
 	}
+}
+
+// repositionEntries moves every entry as close as possible to its optimal
+// block. All tombstones must have been cleared before calling it.
+func (m *FixedBlockMap[V]) repositionEntries() error {
+	// Entries are only moved around, so the entry count is unchanged
+	count := m.count
+	defer func() {
+		m.count = count
+	}()
+
+	m.tombstones = 0
 
 	//--==============================================================================--
 	//--== Attempt to reposition entries not in their optimal blocks
@@ -446,6 +541,9 @@ func (m *FixedBlockMap[V]) Grow(newCapacity uint64) error {
 	// Calculate how many new blocks to add
 	blocksToAdd := int(newBlockCount - currentBlockCount)
 
+	// Every block is about to be reorganized
+	m.touchAll()
+
 	// Extend the existing blocks slice by appending new empty blocks
 	m.blocks = append(m.blocks, make([]FixedBlock[V], blocksToAdd)...)
 	m.mask = newBlockCount - 1
@@ -453,47 +551,247 @@ func (m *FixedBlockMap[V]) Grow(newCapacity uint64) error {
 	return m.Rehash()
 }
 
+const (
+	rawVersion    = 1
+	rawHeaderSize = 32
+	rawChunkSize  = 64 * 1024
+)
+
+var rawMagic = [4]byte{'F', 'B', 'M', 'R'}
+
+// castagnoliTable is used for the CRC32C checksums of the raw format
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksumMismatch is returned when reading data whose checksum does not match
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// rawHeader describes the data written by WriteTo
+type rawHeader struct {
+	blockCount     uint64
+	blockSize      uint32
+	blocksPerChunk uint32
+}
+
+// encode writes the header, including its own checksum, into dst
+func (h *rawHeader) encode(dst []byte) {
+	copy(dst[0:4], rawMagic[:])
+	binary.LittleEndian.PutUint32(dst[4:8], rawVersion)
+	binary.LittleEndian.PutUint64(dst[8:16], h.blockCount)
+	binary.LittleEndian.PutUint32(dst[16:20], h.blockSize)
+	binary.LittleEndian.PutUint32(dst[20:24], h.blocksPerChunk)
+	binary.LittleEndian.PutUint32(dst[24:28], crc32.Checksum(dst[0:24], castagnoliTable))
+}
+
+// decode reads and verifies a header written by encode
+func (h *rawHeader) decode(src []byte) error {
+	if [4]byte(src[0:4]) != rawMagic {
+		return errors.New("invalid map data: bad magic")
+	}
+	if crc32.Checksum(src[0:24], castagnoliTable) != binary.LittleEndian.Uint32(src[24:28]) {
+		return fmt.Errorf("invalid map data: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(src[4:8]); version != rawVersion {
+		return fmt.Errorf("invalid map data: unsupported version %d", version)
+	}
+
+	h.blockCount = binary.LittleEndian.Uint64(src[8:16])
+	h.blockSize = binary.LittleEndian.Uint32(src[16:20])
+	h.blocksPerChunk = binary.LittleEndian.Uint32(src[20:24])
+
+	if h.blockCount == 0 || h.blockCount&(h.blockCount-1) != 0 {
+		return fmt.Errorf("invalid map data: block count %d is not a power of two", h.blockCount)
+	}
+	if h.blocksPerChunk == 0 {
+		return errors.New("invalid map data: empty chunks")
+	}
+	if hi, lo := bits.Mul64(h.blockCount, uint64(h.blockSize)); hi != 0 || lo > math.MaxInt {
+		return fmt.Errorf("invalid map data: %d blocks of %d bytes do not fit in memory", h.blockCount, h.blockSize)
+	}
+
+	return nil
+}
+
+// blockBytes maps the memory of the blocks directly to a []byte
+func blockBytes[V any](blocks []FixedBlock[V]) []byte {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&blocks[0])), int(unsafe.Sizeof(blocks[0]))*len(blocks))
+}
+
 // WriteTo writes the entire raw memory block of the map to an io.Writer.
+// The memory is preceded by a small header describing the map and split into
+// chunks that are each followed by a CRC32C checksum, so ReadFrom can detect
+// truncated or corrupted data.
 func (m *FixedBlockMap[V]) WriteTo(w io.Writer) (int64, error) {
 	if len(m.blocks) == 0 {
 		return 0, nil
 	}
 
-	// Calculate the total size of the memory block
-	blockSize := unsafe.Sizeof(m.blocks[0])
-	totalSize := int(blockSize) * len(m.blocks)
-
 	// Map the slice memory directly to a []byte for writing
-	var blocks []byte
-	header := (*reflect.SliceHeader)(unsafe.Pointer(&blocks))
-	header.Data = uintptr(unsafe.Pointer(&m.blocks[0]))
-	header.Len = totalSize
-	header.Cap = totalSize
+	return writeRaw(w, blockBytes(m.blocks), int(unsafe.Sizeof(m.blocks[0])))
+}
 
-	// Perform the write
-	written, err := w.Write(blocks)
-	return int64(written), err
+// writeRaw writes blocks of blockSize bytes each in the format read by ReadFrom
+func writeRaw(w io.Writer, blocks []byte, blockSize int) (int64, error) {
+	header := rawHeader{
+		blockCount:     uint64(len(blocks) / blockSize),
+		blockSize:      uint32(blockSize),
+		blocksPerChunk: uint32(max(1, rawChunkSize/blockSize)),
+	}
+
+	var headerBytes [rawHeaderSize]byte
+	header.encode(headerBytes[:])
+
+	written, err := w.Write(headerBytes[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	chunkSize := int(header.blocksPerChunk) * blockSize
+
+	var checksum [4]byte
+	for start := 0; start < len(blocks); start += chunkSize {
+		chunk := blocks[start:min(start+chunkSize, len(blocks))]
+
+		written, err = w.Write(chunk)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+
+		binary.LittleEndian.PutUint32(checksum[:], crc32.Checksum(chunk, castagnoliTable))
+		written, err = w.Write(checksum[:])
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
 }
 
-// ReadFrom populates the map from an io.Reader.
-// Note: The map must already be initialized with the correct capacity
-// that matches the data being read.
+// ReadFrom populates the map from data written by WriteTo. The map is resized
+// to the number of blocks in the data, and the checksum of every chunk is
+// verified. The data is read into new memory, so the map is left untouched
+// when an error is returned. Since the blocks are read as raw memory, the data
+// must have been written with the same value type on a machine with the same
+// byte order.
 func (m *FixedBlockMap[V]) ReadFrom(r io.Reader) (int64, error) {
-	if len(m.blocks) == 0 {
-		return 0, fmt.Errorf("map must be initialized with correct capacity before reading")
+	header, total, err := readRawHeader(r)
+	if err != nil {
+		return total, err
+	}
+
+	blockSize := int(unsafe.Sizeof(FixedBlock[V]{}))
+	if int(header.blockSize) != blockSize {
+		return total, fmt.Errorf("invalid map data: block size %d does not match %d", header.blockSize, blockSize)
+	}
+
+	// Read directly into the blocks memory, which grows with the data read
+	var newBlocks []FixedBlock[V]
+	read, err := readRawBlocks(r, &header, rawGrower(&newBlocks))
+	total += read
+	if err != nil {
+		return total, err
 	}
 
-	blockSize := unsafe.Sizeof(m.blocks[0])
-	totalSize := int(blockSize) * len(m.blocks)
+	m.touchAll()
+	m.blocks = newBlocks
+	m.mask = header.blockCount - 1
+	m.recount()
 
-	// Map the slice memory directly to a []byte for reading
-	var blocks []byte
-	header := (*reflect.SliceHeader)(unsafe.Pointer(&blocks))
-	header.Data = uintptr(unsafe.Pointer(&m.blocks[0]))
-	header.Len = totalSize
-	header.Cap = totalSize
+	return total, nil
+}
+
+// readRawHeader reads and verifies the header written by writeRaw
+func readRawHeader(r io.Reader) (rawHeader, int64, error) {
+	var headerBytes [rawHeaderSize]byte
+	read, err := io.ReadFull(r, headerBytes[:])
+	if err != nil {
+		return rawHeader{}, int64(read), err
+	}
+
+	var header rawHeader
+	err = header.decode(headerBytes[:])
+	return header, int64(read), err
+}
+
+// readRawBlocks reads the blocks following a header, verifying the checksum of
+// every chunk. The memory for the data is requested from grow at most
+// rawChunkSize bytes at a time as it is read, so a header claiming more blocks
+// than the data holds fails with io.ErrUnexpectedEOF instead of allocating
+// them all up front.
+func readRawBlocks(r io.Reader, header *rawHeader, grow func(n int) []byte) (int64, error) {
+	// decode checked that the size fits in an int
+	size := header.blockCount * uint64(header.blockSize)
+	chunkSize := uint64(header.blocksPerChunk) * uint64(header.blockSize)
+
+	var total int64
+	var checksum [4]byte
+	for start := uint64(0); start < size; {
+		end := start + min(chunkSize, size-start)
+
+		var crc uint32
+		for offset := start; offset < end; {
+			piece := grow(int(min(end-offset, rawChunkSize)))
+
+			read, err := io.ReadFull(r, piece)
+			total += int64(read)
+			if err != nil {
+				return total, unexpectedEOF(err)
+			}
+
+			crc = crc32.Update(crc, castagnoliTable, piece)
+			offset += uint64(len(piece))
+		}
+
+		read, err := io.ReadFull(r, checksum[:])
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+
+		if crc != binary.LittleEndian.Uint32(checksum[:]) {
+			return total, fmt.Errorf("invalid map data: chunk at offset %d: %w", start, ErrChecksumMismatch)
+		}
+
+		start = end
+	}
+
+	return total, nil
+}
+
+// rawGrower returns a grow function for readRawBlocks that appends zeroed
+// blocks to blocks as their memory is requested
+func rawGrower[B any](blocks *[]B) func(n int) []byte {
+	blockSize := int(unsafe.Sizeof(*new(B)))
+	filled := 0
+
+	return func(n int) []byte {
+		for len(*blocks)*blockSize < filled+n {
+			*blocks = append(*blocks, *new(B))
+		}
+
+		data := unsafe.Slice((*byte)(unsafe.Pointer(&(*blocks)[0])), len(*blocks)*blockSize)
+		piece := data[filled : filled+n]
+		filled += n
+		return piece
+	}
+}
+
+// unexpectedEOF converts io.EOF to io.ErrUnexpectedEOF for data that ended early
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
 
-	// Read directly into the buckets memory
-	read, err := io.ReadFull(r, blocks)
-	return int64(read), err
+// recount rebuilds the entry and tombstone counters by scanning every slot.
+func (m *FixedBlockMap[V]) recount() {
+	m.count, m.tombstones = m.countSlots(0, len(m.blocks))
 }
@@ -0,0 +1,64 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// AppendBinary appends the map to b in the format written by WritePortableTo.
+// The format records the number of blocks, so the map can be decoded by
+// UnmarshalBinary without knowing its capacity in advance.
+func (m *FixedBlockMap[V]) AppendBinary(b []byte) ([]byte, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return b, err
+	}
+
+	buf := bytes.NewBuffer(b)
+	buf.Grow(portableHeaderSize + len(m.blocks)*codec.blockSize())
+
+	if _, err = m.WritePortableTo(buf); err != nil {
+		return b, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the format written
+// by WritePortableTo
+func (m *FixedBlockMap[V]) MarshalBinary() ([]byte, error) {
+	return m.AppendBinary(nil)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The map is resized
+// to match the data, so it can be the zero value.
+func (m *FixedBlockMap[V]) UnmarshalBinary(data []byte) error {
+	// Decode into a separate map, so the map is left untouched on failure
+	var decoded FixedBlockMap[V]
+	r := bytes.NewReader(data)
+	if _, err := decoded.ReadPortableFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("invalid portable map: %d trailing bytes", r.Len())
+	}
+
+	m.touchAll()
+	m.blocks = decoded.blocks
+	m.mask = decoded.mask
+	m.recount()
+
+	return nil
+}
+
+// GobEncode implements gob.GobEncoder, so maps can be encoded with
+// encoding/gob, including as fields of other structs
+func (m *FixedBlockMap[V]) GobEncode() ([]byte, error) {
+	return m.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder
+func (m *FixedBlockMap[V]) GobDecode(data []byte) error {
+	return m.UnmarshalBinary(data)
+}
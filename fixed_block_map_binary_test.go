@@ -0,0 +1,124 @@
+package collections
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*FixedBlockMap[testValue])(nil)
+	_ encoding.BinaryUnmarshaler = (*FixedBlockMap[testValue])(nil)
+	_ gob.GobEncoder             = (*FixedBlockMap[testValue])(nil)
+	_ gob.GobDecoder             = (*FixedBlockMap[testValue])(nil)
+)
+
+func TestFixedBlockMap_MarshalBinary(t *testing.T) {
+	m, keys := newTestMap(t, 128, "binary", 50)
+	m.Delete(keys[3])
+
+	data, err := m.MarshalBinary()
+	require.NoError(t, err)
+
+	// The zero value can be unmarshaled into
+	var decoded FixedBlockMap[testValue]
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, m.Capacity(), decoded.Capacity())
+	assert.Equal(t, m.Len(), decoded.Len())
+	assert.True(t, m.Equal(&decoded, testValueEqual))
+
+	// A pre-sized map is resized to match
+	small := NewFixedBlockMap[testValue](8)
+	require.NoError(t, small.UnmarshalBinary(data))
+	assert.True(t, m.Equal(small, testValueEqual))
+}
+
+func TestFixedBlockMap_MarshalBinaryZeroValue(t *testing.T) {
+	var zero FixedBlockMap[testValue]
+	data, err := zero.MarshalBinary()
+	require.NoError(t, err)
+
+	// Decoding an empty map replaces the contents of the target
+	target, keys := newTestMap(t, 64, "zero", 5)
+	require.NoError(t, target.UnmarshalBinary(data))
+	assert.Equal(t, uint64(0), target.Len())
+	assert.Equal(t, uint64(0), target.Capacity())
+	assert.True(t, zero.Equal(target, testValueEqual))
+
+	_, found := target.Get(keys[0])
+	assert.False(t, found)
+
+	// The decoded map is usable like the zero value
+	require.NoError(t, target.putGrowing(keys[0], testValue{ID: 1}))
+	assert.Equal(t, uint64(1), target.Len())
+
+	// A struct with a zero value map field survives a gob round trip
+	type document struct {
+		Name    string
+		Entries FixedBlockMap[testValue]
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(&document{Name: "empty"}))
+
+	var decoded document
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+	assert.Equal(t, "empty", decoded.Name)
+	assert.Equal(t, uint64(0), decoded.Entries.Len())
+}
+
+func TestFixedBlockMap_AppendBinary(t *testing.T) {
+	m, _ := newTestMap(t, 64, "append", 10)
+
+	prefix := []byte("prefix")
+	data, err := m.AppendBinary(bytes.Clone(prefix))
+	require.NoError(t, err)
+	assert.Equal(t, prefix, data[:len(prefix)])
+
+	marshaled, err := m.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, marshaled, data[len(prefix):])
+}
+
+func TestFixedBlockMap_UnmarshalBinaryErrors(t *testing.T) {
+	m, keys := newTestMap(t, 64, "binary", 10)
+	data, err := m.MarshalBinary()
+	require.NoError(t, err)
+
+	target, _ := newTestMap(t, 64, "target", 5)
+	before := target.Clone()
+
+	// Truncated and padded data leave the map untouched
+	assert.Error(t, target.UnmarshalBinary(data[:len(data)-1]))
+	assert.Error(t, target.UnmarshalBinary(append(bytes.Clone(data), 0)))
+	assert.True(t, before.Equal(target, testValueEqual))
+
+	_, found := target.Get(keys[0])
+	assert.False(t, found)
+}
+
+func TestFixedBlockMap_Gob(t *testing.T) {
+	type document struct {
+		Name    string
+		Entries *FixedBlockMap[testValue]
+	}
+
+	m, keys := newTestMap(t, 64, "gob", 20)
+
+	var buf bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&buf).Encode(document{Name: "users", Entries: m}))
+
+	var decoded document
+	require.NoError(t, gob.NewDecoder(&buf).Decode(&decoded))
+	assert.Equal(t, "users", decoded.Name)
+	require.NotNil(t, decoded.Entries)
+	assert.True(t, m.Equal(decoded.Entries, testValueEqual))
+
+	value, found := decoded.Entries.Get(keys[7])
+	require.True(t, found)
+	assert.Equal(t, uint64(7), value.ID)
+}
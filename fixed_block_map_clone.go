@@ -0,0 +1,51 @@
+package collections
+
+// Clone returns an independent copy of the map with the same capacity and
+// layout. The blocks are duplicated in a single copy, so cloning is as cheap
+// as copying the underlying memory and tombstones are preserved as-is.
+func (m *FixedBlockMap[V]) Clone() *FixedBlockMap[V] {
+	blocks := make([]FixedBlock[V], len(m.blocks))
+	copy(blocks, m.blocks)
+
+	return &FixedBlockMap[V]{
+		blocks:     blocks,
+		mask:       m.mask,
+		count:      m.count,
+		tombstones: m.tombstones,
+	}
+}
+
+// CloneWithCapacity returns a copy of the map sized for the given capacity.
+// Every entry is re-inserted into the new map, which compacts away tombstones
+// and places entries in their optimal blocks. An error is returned if the
+// requested capacity cannot hold all of the entries.
+func (m *FixedBlockMap[V]) CloneWithCapacity(capacity uint64) (*FixedBlockMap[V], error) {
+	clone := NewFixedBlockMap[V](capacity)
+
+	for key, value := range m.Iter() {
+		if err := clone.Put(key, *value); err != nil {
+			return nil, err
+		}
+	}
+
+	return clone, nil
+}
+
+// Equal reports whether both maps hold the same set of keys and whether the
+// values for each key are equal according to eq. The comparison is based on
+// content only, so the capacity, block layout and tombstones of the two maps
+// do not matter.
+func (m *FixedBlockMap[V]) Equal(other *FixedBlockMap[V], eq func(a, b *V) bool) bool {
+	if m.Len() != other.Len() {
+		return false
+	}
+
+	for key, value := range m.Iter() {
+		otherValue, found := other.Get(key)
+		if !found || !eq(value, otherValue) {
+			return false
+		}
+	}
+
+	return true
+}
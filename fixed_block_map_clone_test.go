@@ -0,0 +1,104 @@
+package collections
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testValueEqual(a, b *testValue) bool {
+	return *a == *b
+}
+
+// newTestMap builds a map holding count entries keyed "<prefix><i>"
+func newTestMap(t testing.TB, capacity uint64, prefix string, count int) (*FixedBlockMap[testValue], []FixedBlockKey) {
+	m := NewFixedBlockMap[testValue](capacity)
+	keys := make([]FixedBlockKey, count)
+
+	for i := 0; i < count; i++ {
+		keys[i].FromString(fmt.Sprintf("%s%d", prefix, i))
+		value := testValue{ID: uint64(i), Score: int32(i * 10), Flags: uint16(i), Data: [4]byte{byte(i), byte(i + 1), byte(i + 2), byte(i + 3)}}
+		require.NoError(t, m.Put(keys[i], value))
+	}
+
+	return m, keys
+}
+
+func TestFixedBlockMap_Clone(t *testing.T) {
+	m, keys := newTestMap(t, 64, "clone", 30)
+	m.Delete(keys[4])
+
+	clone := m.Clone()
+	assert.Equal(t, m.Capacity(), clone.Capacity())
+	assert.Equal(t, m.Len(), clone.Len())
+	assert.True(t, m.Equal(clone, testValueEqual))
+
+	// Mutating the clone must not affect the original
+	require.NoError(t, clone.Put(keys[0], testValue{ID: 1000}))
+	clone.Delete(keys[1])
+
+	val, found := m.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(0), val.ID)
+
+	_, found = m.Get(keys[1])
+	assert.True(t, found)
+	assert.False(t, m.Equal(clone, testValueEqual))
+}
+
+func TestFixedBlockMap_CloneWithCapacity(t *testing.T) {
+	m, keys := newTestMap(t, 256, "compact", 40)
+	for i := 0; i < 20; i++ {
+		m.Delete(keys[i])
+	}
+
+	// Compact into a smaller map
+	smaller, err := m.CloneWithCapacity(32)
+	require.NoError(t, err)
+	assert.Less(t, smaller.Capacity(), m.Capacity())
+	assert.Equal(t, uint64(20), smaller.Len())
+	assert.Equal(t, float32(0), smaller.CollectInfo().TombstoneFactor)
+	assert.True(t, m.Equal(smaller, testValueEqual))
+
+	// Expand into a larger map
+	larger, err := m.CloneWithCapacity(1024)
+	require.NoError(t, err)
+	assert.Greater(t, larger.Capacity(), m.Capacity())
+	assert.True(t, larger.Equal(m, testValueEqual))
+
+	// Too small to hold every entry
+	_, err = m.CloneWithCapacity(8)
+	assert.Error(t, err)
+}
+
+func TestFixedBlockMap_Equal(t *testing.T) {
+	// Same entries in maps with differing capacities
+	a, _ := newTestMap(t, 16, "eq", 12)
+	b, keys := newTestMap(t, 512, "eq", 12)
+	assert.True(t, a.Equal(b, testValueEqual))
+	assert.True(t, b.Equal(a, testValueEqual))
+
+	// Tombstones do not affect equality
+	extra := FixedBlockKey{}
+	extra.FromString("extra")
+	require.NoError(t, b.Put(extra, testValue{ID: 99}))
+	assert.False(t, a.Equal(b, testValueEqual))
+	b.Delete(extra)
+	assert.True(t, a.Equal(b, testValueEqual))
+
+	// Differing values
+	require.NoError(t, b.Put(keys[3], testValue{ID: 3000}))
+	assert.False(t, a.Equal(b, testValueEqual))
+
+	// The comparison function decides what counts as equal
+	assert.True(t, a.Equal(b, func(x, y *testValue) bool { return true }))
+
+	// Same size, different keys
+	c, _ := newTestMap(t, 16, "other", 12)
+	assert.False(t, a.Equal(c, testValueEqual))
+
+	// Empty maps
+	assert.True(t, NewFixedBlockMap[testValue](8).Equal(NewFixedBlockMap[testValue](800), testValueEqual))
+}
@@ -0,0 +1,375 @@
+package collections
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// FixedBlockCompression compresses the entries of a compact snapshot. The
+// package provides NoCompression, GzipCompression and FlateCompression, and
+// other algorithms such as zstd or snappy can be plugged in by implementing
+// this interface with an ID of 128 or above.
+type FixedBlockCompression interface {
+	// ID identifies the compression in the snapshot header
+	ID() uint8
+
+	// NewWriter returns a writer that compresses into w. The snapshot is
+	// complete once the writer is closed.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+
+	// NewReader returns a reader that decompresses from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// NoCompression writes the entries of a compact snapshot as-is
+type NoCompression struct{}
+
+func (NoCompression) ID() uint8 {
+	return 0
+}
+
+func (NoCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+func (NoCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// GzipCompression compresses the entries of a compact snapshot with gzip.
+// The zero value uses the default compression level.
+type GzipCompression struct {
+	Level int
+}
+
+func (GzipCompression) ID() uint8 {
+	return 1
+}
+
+func (c GzipCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, compressionLevel(c.Level))
+}
+
+func (GzipCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// FlateCompression compresses the entries of a compact snapshot with raw
+// DEFLATE, which avoids the gzip header and checksum. The zero value uses the
+// default compression level.
+type FlateCompression struct {
+	Level int
+}
+
+func (FlateCompression) ID() uint8 {
+	return 2
+}
+
+func (c FlateCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, compressionLevel(c.Level))
+}
+
+func (FlateCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// compressionLevel maps the zero level to the default compression level
+func compressionLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+
+	return level
+}
+
+const (
+	compactVersion    = 2
+	compactHeaderSize = 32
+)
+
+var compactMagic = [4]byte{'F', 'B', 'M', 'C'}
+
+// WriteCompactTo writes only the occupied entries of the map, so the size of
+// the snapshot depends on the number of entries rather than the capacity. The
+// entries are compressed with compression, or written as-is when it is nil.
+// Keys and values use the same machine-independent encoding as
+// WritePortableTo. The entries are streamed to w one at a time, so the
+// snapshot is never buffered in memory. The header and the entries are
+// checked with CRC32C checksums, whatever the compression.
+func (m *FixedBlockMap[V]) WriteCompactTo(w io.Writer, compression FixedBlockCompression) (int64, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	writer, err := newCompactWriter(w, compression, m.Len(), codec.size)
+	if err != nil {
+		return writer.counter.n, err
+	}
+
+	entry := make([]byte, len(FixedBlockKey{})+codec.size)
+	for key, value := range m.Iter() {
+		copy(entry, key[:])
+		codec.encode(entry[len(key):], value)
+
+		if err = writer.write(entry); err != nil {
+			return writer.counter.n, err
+		}
+	}
+
+	err = writer.close()
+	return writer.counter.n, err
+}
+
+// ReadCompactFrom builds a new map from a snapshot written by WriteCompactTo.
+// The map is created with the given capacity, or sized for the number of
+// entries in the snapshot when capacity is zero, and grows if it turns out to
+// be too small. Snapshots compressed with a custom FixedBlockCompression can
+// only be read when it is passed in compressions; the compressions provided
+// by this package are always available. r is read to its end, and data
+// following the snapshot is an error.
+func ReadCompactFrom[V any](r io.Reader, capacity uint64, compressions ...FixedBlockCompression) (*FixedBlockMap[V], error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := newCompactReader(r, compressions)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+
+	if reader.valueSize != codec.size {
+		return nil, fmt.Errorf("invalid compact map: value size %d does not match %d", reader.valueSize, codec.size)
+	}
+
+	m := NewFixedBlockMap[V](compactCapacity(capacity, reader.count))
+	entry := make([]byte, len(FixedBlockKey{})+codec.size)
+
+	for i := uint64(0); i < reader.count; i++ {
+		if err = reader.read(entry); err != nil {
+			return nil, err
+		}
+
+		var key FixedBlockKey
+		var value V
+		copy(key[:], entry)
+		if err = codec.decode(entry[len(key):], &value); err != nil {
+			return nil, err
+		}
+
+		if err = m.putGrowing(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = reader.finish(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// compactCapacity returns the capacity of a map loaded from a compact
+// snapshot of count entries. The count comes from the unverified header, so
+// the capacity derived from it is capped and larger maps grow as their
+// entries are read.
+func compactCapacity(capacity, count uint64) uint64 {
+	if capacity == 0 {
+		// Leave room so the loaded map is not recommended to grow
+		capacity = min(count, maxPreallocatedBlocks*FixedBlockSize)
+		capacity += capacity/3 + 1
+	}
+
+	return capacity
+}
+
+// compactWriter writes the header of a compact snapshot and streams the
+// entries through the compression, followed by their checksum
+type compactWriter struct {
+	counter    *countingWriter
+	compressor io.WriteCloser
+	buffered   *bufio.Writer
+	checksum   uint32
+}
+
+func newCompactWriter(w io.Writer, compression FixedBlockCompression, count uint64, valueSize int) (*compactWriter, error) {
+	if compression == nil {
+		compression = NoCompression{}
+	}
+
+	var header [compactHeaderSize]byte
+	copy(header[0:4], compactMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], compactVersion)
+	header[8] = compression.ID()
+	binary.LittleEndian.PutUint64(header[12:20], count)
+	binary.LittleEndian.PutUint32(header[20:24], uint32(valueSize))
+	binary.LittleEndian.PutUint32(header[28:32], crc32.Checksum(header[0:28], castagnoliTable))
+
+	writer := &compactWriter{counter: &countingWriter{w: w}}
+	if _, err := writer.counter.Write(header[:]); err != nil {
+		return writer, err
+	}
+
+	compressor, err := compression.NewWriter(writer.counter)
+	if err != nil {
+		return writer, err
+	}
+
+	writer.compressor = compressor
+	writer.buffered = bufio.NewWriter(compressor)
+	return writer, nil
+}
+
+// write writes an encoded key and value
+func (c *compactWriter) write(entry []byte) error {
+	c.checksum = crc32.Update(c.checksum, castagnoliTable, entry)
+	_, err := c.buffered.Write(entry)
+	return err
+}
+
+// close writes the checksum of the entries and completes the snapshot
+func (c *compactWriter) close() error {
+	if _, err := c.buffered.Write(binary.LittleEndian.AppendUint32(nil, c.checksum)); err != nil {
+		return err
+	}
+	if err := c.buffered.Flush(); err != nil {
+		return err
+	}
+
+	return c.compressor.Close()
+}
+
+// compactReader reads the header of a compact snapshot and streams the
+// entries out of the compression
+type compactReader struct {
+	count        uint64
+	valueSize    int
+	source       *bufio.Reader // the compressed data
+	decompressor io.ReadCloser
+	buffered     *bufio.Reader
+	checksum     uint32
+}
+
+func newCompactReader(r io.Reader, compressions []FixedBlockCompression) (*compactReader, error) {
+	var header [compactHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if [4]byte(header[0:4]) != compactMagic {
+		return nil, errors.New("invalid compact map: bad magic")
+	}
+	if crc32.Checksum(header[0:28], castagnoliTable) != binary.LittleEndian.Uint32(header[28:32]) {
+		return nil, fmt.Errorf("invalid compact map: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != compactVersion {
+		return nil, fmt.Errorf("invalid compact map: unsupported version %d", version)
+	}
+
+	compression, err := findCompression(header[8], compressions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decompressors read byte by byte from an io.ByteReader instead of
+	// buffering ahead, so finish can tell where the compressed data ends
+	source := bufio.NewReader(r)
+	decompressor, err := compression.NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compactReader{
+		count:        binary.LittleEndian.Uint64(header[12:20]),
+		valueSize:    int(binary.LittleEndian.Uint32(header[20:24])),
+		source:       source,
+		decompressor: decompressor,
+		buffered:     bufio.NewReader(decompressor),
+	}, nil
+}
+
+// read reads the next encoded key and value into entry
+func (c *compactReader) read(entry []byte) error {
+	if _, err := io.ReadFull(c.buffered, entry); err != nil {
+		return unexpectedEOF(err)
+	}
+
+	c.checksum = crc32.Update(c.checksum, castagnoliTable, entry)
+	return nil
+}
+
+// finish verifies the checksum following the entries and that the snapshot
+// ends there. Reading the decompressor to its end also makes it verify its
+// own checksum, such as the CRC-32 of gzip.
+func (c *compactReader) finish() error {
+	var stored [4]byte
+	if _, err := io.ReadFull(c.buffered, stored[:]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if c.checksum != binary.LittleEndian.Uint32(stored[:]) {
+		return fmt.Errorf("invalid compact map: entries %w", ErrChecksumMismatch)
+	}
+
+	for _, r := range []io.ByteReader{c.buffered, c.source} {
+		if _, err := r.ReadByte(); err != io.EOF {
+			if err != nil {
+				return err
+			}
+			return errors.New("invalid compact map: trailing data after the entries")
+		}
+	}
+
+	return nil
+}
+
+func (c *compactReader) close() error {
+	return c.decompressor.Close()
+}
+
+// findCompression returns the compression with the given ID
+func findCompression(id uint8, compressions []FixedBlockCompression) (FixedBlockCompression, error) {
+	for _, compression := range compressions {
+		if compression.ID() == id {
+			return compression, nil
+		}
+	}
+
+	switch id {
+	case NoCompression{}.ID():
+		return NoCompression{}, nil
+	case GzipCompression{}.ID():
+		return GzipCompression{}, nil
+	case FlateCompression{}.ID():
+		return FlateCompression{}, nil
+	}
+
+	return nil, fmt.Errorf("invalid compact map: unknown compression %d", id)
+}
+
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,197 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// xorCompression is a custom "compression" used to test pluggable codecs
+type xorCompression struct{}
+
+func (xorCompression) ID() uint8 {
+	return 200
+}
+
+func (xorCompression) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{xorWriter{w}}, nil
+}
+
+func (xorCompression) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(xorReader{r}), nil
+}
+
+type xorWriter struct {
+	w io.Writer
+}
+
+func (x xorWriter) Write(p []byte) (int, error) {
+	flipped := make([]byte, len(p))
+	for i, b := range p {
+		flipped[i] = b ^ 0x5A
+	}
+	return x.w.Write(flipped)
+}
+
+type xorReader struct {
+	r io.Reader
+}
+
+func (x xorReader) Read(p []byte) (int, error) {
+	n, err := x.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= 0x5A
+	}
+	return n, err
+}
+
+func TestFixedBlockMap_CompactRoundTrip(t *testing.T) {
+	m, keys := newTestMap(t, 1024, "compact", 300)
+	for i := 0; i < 100; i++ {
+		m.Delete(keys[i])
+	}
+
+	compressions := []FixedBlockCompression{nil, NoCompression{}, GzipCompression{}, FlateCompression{Level: 9}, xorCompression{}}
+	for _, compression := range compressions {
+		var buf bytes.Buffer
+		written, err := m.WriteCompactTo(&buf, compression)
+		require.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), written)
+
+		loaded, err := ReadCompactFrom[testValue](&buf, 0, xorCompression{})
+		require.NoError(t, err, "%T", compression)
+		assert.Equal(t, uint64(200), loaded.Len())
+		assert.False(t, loaded.CollectInfo().RecommendGrow)
+		assert.True(t, m.Equal(loaded, testValueEqual))
+	}
+}
+
+func TestFixedBlockMap_CompactSize(t *testing.T) {
+	// A map that is 10% full
+	m, _ := newTestMap(t, 8192, "size", 819)
+
+	var raw, compact, compressed bytes.Buffer
+	_, err := m.WriteTo(&raw)
+	require.NoError(t, err)
+	_, err = m.WriteCompactTo(&compact, nil)
+	require.NoError(t, err)
+	_, err = m.WriteCompactTo(&compressed, GzipCompression{})
+	require.NoError(t, err)
+
+	assert.Less(t, compact.Len()*5, raw.Len())
+	assert.Equal(t, compactHeaderSize+819*(16+18)+4, compact.Len())
+	assert.Less(t, compressed.Len(), compact.Len())
+}
+
+func TestFixedBlockMap_CompactCapacity(t *testing.T) {
+	m, _ := newTestMap(t, 1024, "capacity", 100)
+
+	var buf bytes.Buffer
+	_, err := m.WriteCompactTo(&buf, nil)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	// Load into a larger map
+	larger, err := ReadCompactFrom[testValue](bytes.NewReader(data), 4096)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, larger.Capacity(), uint64(4096))
+	assert.True(t, m.Equal(larger, testValueEqual))
+
+	// A capacity that is too small grows as needed
+	smaller, err := ReadCompactFrom[testValue](bytes.NewReader(data), 8)
+	require.NoError(t, err)
+	assert.True(t, m.Equal(smaller, testValueEqual))
+}
+
+func TestFixedBlockMap_CompactStreaming(t *testing.T) {
+	m, _ := newTestMap(t, 4096, "stream", 3000)
+
+	// Neither side holds the whole snapshot
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := m.WriteCompactTo(writer, GzipCompression{})
+		writer.CloseWithError(err)
+	}()
+
+	loaded, err := ReadCompactFrom[testValue](reader, 0)
+	require.NoError(t, err)
+	assert.True(t, m.Equal(loaded, testValueEqual))
+}
+
+func TestFixedBlockMap_CompactErrors(t *testing.T) {
+	m, _ := newTestMap(t, 64, "errors", 20)
+
+	var buf bytes.Buffer
+	_, err := m.WriteCompactTo(&buf, xorCompression{})
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	// Unknown compression
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(data), 0)
+	assert.ErrorContains(t, err, "unknown compression")
+
+	// Truncated entries
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(data[:len(data)-5]), 0, xorCompression{})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Mismatched value type
+	_, err = ReadCompactFrom[packedValue](bytes.NewReader(data), 0, xorCompression{})
+	assert.Error(t, err)
+
+	// Bad magic
+	bad := bytes.Clone(data)
+	bad[0] = 'X'
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(bad), 0, xorCompression{})
+	assert.Error(t, err)
+
+	// A corrupt entry count fails on the missing entries instead of sizing
+	// the map for them
+	huge := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(huge[12:20], 1<<40)
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(huge), 0, xorCompression{})
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	binary.LittleEndian.PutUint32(huge[28:32], crc32.Checksum(huge[0:28], castagnoliTable))
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(huge), 0, xorCompression{})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = ReadRawCompactFrom(bytes.NewReader(huge), xorCompression{})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestFixedBlockMap_CompactIntegrity(t *testing.T) {
+	m, _ := newTestMap(t, 256, "integrity", 100)
+
+	for _, compression := range []FixedBlockCompression{NoCompression{}, GzipCompression{}, FlateCompression{}} {
+		var buf bytes.Buffer
+		_, err := m.WriteCompactTo(&buf, compression)
+		require.NoError(t, err)
+		data := buf.Bytes()
+
+		// Data following the snapshot is rejected rather than ignored
+		_, err = ReadCompactFrom[testValue](bytes.NewReader(append(bytes.Clone(data), "garbage"...)), 0)
+		assert.Error(t, err, "%T", compression)
+		_, err = ReadRawCompactFrom(bytes.NewReader(append(bytes.Clone(data), 0)))
+		assert.Error(t, err, "%T", compression)
+
+		// So is a corrupt entry, or for gzip its own checksum
+		corrupted := bytes.Clone(data)
+		corrupted[len(corrupted)-10] ^= 0xFF
+		_, err = ReadCompactFrom[testValue](bytes.NewReader(corrupted), 0)
+		assert.Error(t, err, "%T", compression)
+	}
+
+	// Without compression every entry is covered by the checksum
+	var buf bytes.Buffer
+	_, err := m.WriteCompactTo(&buf, nil)
+	require.NoError(t, err)
+	corrupted := buf.Bytes()
+	corrupted[compactHeaderSize+20] ^= 0xFF
+	_, err = ReadCompactFrom[testValue](bytes.NewReader(corrupted), 0)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
@@ -0,0 +1,258 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+)
+
+const (
+	deltaVersion    = 2
+	deltaHeaderSize = 32
+)
+
+var deltaMagic = [4]byte{'F', 'B', 'M', 'D'}
+
+// markDirty records that a block was modified
+func (m *FixedBlockMap[V]) markDirty(blockIndex uint64) {
+	if m.allDirty {
+		return
+	}
+
+	word := blockIndex / 64
+	if word >= uint64(len(m.dirty)) {
+		m.dirty = append(m.dirty, make([]uint64, (len(m.blocks)+63)/64-len(m.dirty))...)
+	}
+
+	m.dirty[word] |= 1 << (blockIndex % 64)
+}
+
+// markAllDirty records that every block was modified
+func (m *FixedBlockMap[V]) markAllDirty() {
+	m.allDirty = true
+	clear(m.dirty)
+}
+
+// isDirty reports whether a block was modified since the last ClearDirty
+func (m *FixedBlockMap[V]) isDirty(blockIndex uint64) bool {
+	if m.allDirty {
+		return true
+	}
+
+	word := blockIndex / 64
+	return word < uint64(len(m.dirty)) && m.dirty[word]&(1<<(blockIndex%64)) != 0
+}
+
+// DirtyBlocks returns the number of blocks modified since the last call to
+// ClearDirty or WriteDelta. Blocks count as modified when Put or Delete
+// changed them; Rehash, Grow and reading a snapshot modify every block.
+func (m *FixedBlockMap[V]) DirtyBlocks() uint64 {
+	if m.allDirty {
+		return uint64(len(m.blocks))
+	}
+
+	var count int
+	for _, word := range m.dirty {
+		count += bits.OnesCount64(word)
+	}
+
+	return uint64(count)
+}
+
+// MarkDirty records that the block holding key was modified and reports
+// whether the key is in the map. Put and Delete track their changes, but
+// values modified in place through the pointers returned by Get, Iter, Values
+// or ParallelRange are not seen, so call MarkDirty for their keys to include
+// them in the next delta.
+func (m *FixedBlockMap[V]) MarkDirty(key FixedBlockKey) bool {
+	if len(m.blocks) == 0 {
+		return false
+	}
+
+	blockIndex := m.hashToBlock(key)
+	tag := key[0] | 0x80
+
+	for probed := uint64(0); probed <= m.mask; probed++ {
+		control := m.blocks[blockIndex].control
+
+		result := matchTag(control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if m.blocks[blockIndex].keys[index] == key {
+				m.markDirty(blockIndex)
+				return true
+			}
+
+			result &= result - 1
+		}
+
+		if matchEmpty(control) != 0x0 {
+			return false
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+	}
+
+	return false
+}
+
+// ClearDirty marks every block as unmodified. Call it after sending a full
+// snapshot (for example written by WriteTo) to a replica, so that following
+// deltas only contain the changes since that snapshot.
+func (m *FixedBlockMap[V]) ClearDirty() {
+	m.allDirty = false
+	clear(m.dirty)
+}
+
+// WriteDelta writes the blocks modified since the last call to ClearDirty or
+// WriteDelta, together with their indices, and then clears the modified
+// blocks. Applying the delta with ApplyDelta to a replica that matched this
+// map when the blocks were last cleared brings the replica up to date. Maps
+// start out with no modified blocks, and their untouched blocks are empty, so
+// a new replica can be kept up to date with deltas alone.
+//
+// Values modified in place through pointers into the map are only written if
+// their keys were passed to MarkDirty; otherwise replicas miss those updates.
+//
+// Blocks are written in the same machine-independent encoding as
+// WritePortableTo, each followed by a CRC32C checksum.
+func (m *FixedBlockMap[V]) WriteDelta(w io.Writer) (int64, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	var header [deltaHeaderSize]byte
+	copy(header[0:4], deltaMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], deltaVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(m.blocks)))
+	binary.LittleEndian.PutUint64(header[16:24], m.DirtyBlocks())
+	binary.LittleEndian.PutUint32(header[24:28], uint32(codec.size))
+	binary.LittleEndian.PutUint32(header[28:32], crc32.Checksum(header[0:28], castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	record := make([]byte, 8+codec.blockSize()+4)
+	for blockIndex := range m.blocks {
+		if !m.isDirty(uint64(blockIndex)) {
+			continue
+		}
+
+		binary.LittleEndian.PutUint64(record[0:8], uint64(blockIndex))
+		codec.encodeBlock(record[8:len(record)-4], &m.blocks[blockIndex])
+		binary.LittleEndian.PutUint32(record[len(record)-4:], crc32.Checksum(record[:len(record)-4], castagnoliTable))
+
+		written, err = w.Write(record)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	m.ClearDirty()
+
+	return total, nil
+}
+
+// ApplyDelta updates the map with a delta written by WriteDelta. The whole
+// delta is read and verified before the map is changed, so the map is left
+// untouched when an error is returned. When the source map has grown, every
+// block is part of the delta and the map is resized to match. The applied
+// blocks count as modified, so replicas can pass deltas on to other replicas.
+func (m *FixedBlockMap[V]) ApplyDelta(r io.Reader) (int64, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	var header [deltaHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != deltaMagic {
+		return total, errors.New("invalid delta: bad magic")
+	}
+	if crc32.Checksum(header[0:28], castagnoliTable) != binary.LittleEndian.Uint32(header[28:32]) {
+		return total, fmt.Errorf("invalid delta: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != deltaVersion {
+		return total, fmt.Errorf("invalid delta: unsupported version %d", version)
+	}
+
+	blockCount := binary.LittleEndian.Uint64(header[8:16])
+	if blockCount == 0 || blockCount&(blockCount-1) != 0 {
+		return total, fmt.Errorf("invalid delta: block count %d is not a power of two", blockCount)
+	}
+
+	recordCount := binary.LittleEndian.Uint64(header[16:24])
+	resize := blockCount != uint64(len(m.blocks))
+	if recordCount > blockCount || (resize && recordCount != blockCount) {
+		return total, fmt.Errorf("invalid delta: %d blocks for a map of %d blocks, expected %d", recordCount, len(m.blocks), blockCount)
+	}
+
+	valueSize := binary.LittleEndian.Uint32(header[24:28])
+	if int(valueSize) != codec.size {
+		return total, fmt.Errorf("invalid delta: value size %d does not match %d", valueSize, codec.size)
+	}
+
+	// Decode every block before changing the map. The slices grow with the
+	// records actually read, so a header announcing a huge map cannot force
+	// a huge allocation up front.
+	initial := min(recordCount, uint64(len(m.blocks)))
+	indices := make([]uint64, 0, initial)
+	blocks := make([]FixedBlock[V], 0, initial)
+	record := make([]byte, 8+codec.blockSize()+4)
+
+	for i := uint64(0); i < recordCount; i++ {
+		read, err = io.ReadFull(r, record)
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+
+		if crc32.Checksum(record[:len(record)-4], castagnoliTable) != binary.LittleEndian.Uint32(record[len(record)-4:]) {
+			return total, fmt.Errorf("invalid delta: block record %d: %w", i, ErrChecksumMismatch)
+		}
+
+		// WriteDelta writes the blocks in ascending order, which also makes
+		// the indices of a resizing delta exactly 0 to blockCount-1
+		blockIndex := binary.LittleEndian.Uint64(record[0:8])
+		if blockIndex >= blockCount {
+			return total, fmt.Errorf("invalid delta: block index %d out of range", blockIndex)
+		}
+		if i > 0 && blockIndex <= indices[i-1] {
+			return total, fmt.Errorf("invalid delta: block index %d follows %d", blockIndex, indices[i-1])
+		}
+
+		blocks = append(blocks, FixedBlock[V]{})
+		if err = codec.decodeBlock(record[8:len(record)-4], &blocks[i]); err != nil {
+			return total, err
+		}
+		indices = append(indices, blockIndex)
+	}
+
+	if resize {
+		m.touchAll()
+		m.blocks = blocks
+		m.mask = blockCount - 1
+	} else {
+		for i, blockIndex := range indices {
+			m.touch(blockIndex)
+			m.blocks[blockIndex] = blocks[i]
+		}
+	}
+
+	m.recount()
+
+	return total, nil
+}
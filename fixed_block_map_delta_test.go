@@ -0,0 +1,253 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockMap_DirtyBlocks(t *testing.T) {
+	m := NewFixedBlockMap[testValue](1024)
+	assert.Equal(t, uint64(0), m.DirtyBlocks())
+
+	var key FixedBlockKey
+	key.FromString("dirty")
+	require.NoError(t, m.Put(key, testValue{ID: 1}))
+	assert.Equal(t, uint64(1), m.DirtyBlocks())
+
+	// Updating the same block does not add to the count
+	require.NoError(t, m.Put(key, testValue{ID: 2}))
+	assert.Equal(t, uint64(1), m.DirtyBlocks())
+
+	m.ClearDirty()
+	assert.Equal(t, uint64(0), m.DirtyBlocks())
+
+	m.Delete(key)
+	assert.Equal(t, uint64(1), m.DirtyBlocks())
+
+	// Deleting a missing key changes nothing
+	m.ClearDirty()
+	m.Delete(key)
+	assert.Equal(t, uint64(0), m.DirtyBlocks())
+
+	require.NoError(t, m.Rehash())
+	assert.Equal(t, uint64(len(m.blocks)), m.DirtyBlocks())
+}
+
+func TestFixedBlockMap_WriteDelta(t *testing.T) {
+	m, keys := newTestMap(t, 256, "delta", 100)
+
+	// A new replica is kept up to date by deltas alone
+	replica := NewFixedBlockMap[testValue](256)
+	applyDelta := func() int64 {
+		var buf bytes.Buffer
+		written, err := m.WriteDelta(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), written)
+		assert.Equal(t, uint64(0), m.DirtyBlocks())
+
+		read, err := replica.ApplyDelta(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, written, read)
+		assert.True(t, m.Equal(replica, testValueEqual))
+		assert.Equal(t, m.Len(), replica.Len())
+		return written
+	}
+
+	full := applyDelta()
+
+	// Only the modified blocks are sent
+	require.NoError(t, m.Put(keys[0], testValue{ID: 1000}))
+	m.Delete(keys[1])
+	assert.LessOrEqual(t, m.DirtyBlocks(), uint64(2))
+	assert.Less(t, applyDelta(), full)
+
+	// An empty delta changes nothing
+	assert.Equal(t, int64(deltaHeaderSize), applyDelta())
+
+	// Growing sends every block and resizes the replica
+	require.NoError(t, m.Grow(1024))
+	applyDelta()
+	assert.Equal(t, m.Capacity(), replica.Capacity())
+	require.NoError(t, replica.Validate())
+
+	value, found := replica.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(1000), value.ID)
+}
+
+func TestFixedBlockMap_MarkDirty(t *testing.T) {
+	m, keys := newTestMap(t, 256, "delta", 100)
+	replica := m.Clone()
+	m.ClearDirty()
+
+	ship := func() {
+		var buf bytes.Buffer
+		_, err := m.WriteDelta(&buf)
+		require.NoError(t, err)
+		_, err = replica.ApplyDelta(&buf)
+		require.NoError(t, err)
+	}
+
+	// Values modified in place are not tracked by themselves
+	value, found := m.Get(keys[0])
+	require.True(t, found)
+	value.ID = 1000
+	assert.Equal(t, uint64(0), m.DirtyBlocks())
+
+	ship()
+	assert.False(t, m.Equal(replica, testValueEqual))
+
+	// Marking the key includes its block in the next delta
+	assert.True(t, m.MarkDirty(keys[0]))
+	assert.Equal(t, uint64(1), m.DirtyBlocks())
+	ship()
+	assert.True(t, m.Equal(replica, testValueEqual))
+
+	var missing FixedBlockKey
+	missing.FromString("missing")
+	assert.False(t, m.MarkDirty(missing))
+	assert.False(t, NewFixedBlockMap[testValue](0).MarkDirty(missing))
+	assert.False(t, (&FixedBlockMap[testValue]{}).MarkDirty(missing))
+}
+
+func TestFixedBlockMap_ApplyDeltaErrors(t *testing.T) {
+	m, keys := newTestMap(t, 64, "delta", 20)
+
+	var buf bytes.Buffer
+	_, err := m.WriteDelta(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	t.Run("checksum", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[deltaHeaderSize+20] ^= 0xFF
+
+		replica := NewFixedBlockMap[testValue](64)
+		_, err := replica.ApplyDelta(bytes.NewReader(corrupted))
+		assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		assert.Equal(t, uint64(0), replica.Len())
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		replica := NewFixedBlockMap[testValue](64)
+		_, err := replica.ApplyDelta(bytes.NewReader(data[:len(data)-10]))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		assert.Equal(t, uint64(0), replica.Len())
+	})
+
+	t.Run("block count", func(t *testing.T) {
+		// A partial delta cannot be applied to a map of a different size
+		require.NoError(t, m.Put(keys[0], testValue{ID: 1}))
+		buf.Reset()
+		_, err := m.WriteDelta(&buf)
+		require.NoError(t, err)
+
+		replica := NewFixedBlockMap[testValue](1024)
+		_, err = replica.ApplyDelta(&buf)
+		assert.Error(t, err)
+	})
+
+	t.Run("header checksum", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[16] ^= 0x01
+
+		replica := NewFixedBlockMap[testValue](64)
+		_, err := replica.ApplyDelta(bytes.NewReader(corrupted))
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("huge header", func(t *testing.T) {
+		// A valid header announcing an enormous map fails on the missing
+		// records instead of allocating the whole map
+		header := bytes.Clone(data[:deltaHeaderSize])
+		binary.LittleEndian.PutUint64(header[8:16], 1<<40)
+		binary.LittleEndian.PutUint64(header[16:24], 1<<40)
+		binary.LittleEndian.PutUint32(header[28:32], crc32.Checksum(header[0:28], castagnoliTable))
+
+		replica := NewFixedBlockMap[testValue](64)
+		_, err := replica.ApplyDelta(bytes.NewReader(header))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("magic", func(t *testing.T) {
+		replica := NewFixedBlockMap[testValue](64)
+		_, err := replica.ApplyDelta(bytes.NewReader(make([]byte, deltaHeaderSize)))
+		assert.Error(t, err)
+	})
+}
+
+func TestFixedBlockMap_ApplyDeltaOrder(t *testing.T) {
+	m, _ := newTestMap(t, 64, "delta", 40)
+	require.NoError(t, m.Grow(128))
+
+	var buf bytes.Buffer
+	_, err := m.WriteDelta(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	recordSize := (len(data) - deltaHeaderSize) / len(m.blocks)
+	record := func(i int) []byte {
+		offset := deltaHeaderSize + i*recordSize
+		return data[offset : offset+recordSize]
+	}
+
+	// Swapped records keep valid checksums but would place the blocks of a
+	// resizing delta in the wrong slots
+	swapped := bytes.Clone(data[:deltaHeaderSize])
+	swapped = append(swapped, record(1)...)
+	swapped = append(swapped, record(0)...)
+	for i := 2; i < len(m.blocks); i++ {
+		swapped = append(swapped, record(i)...)
+	}
+
+	replica := NewFixedBlockMap[testValue](64)
+	_, err = replica.ApplyDelta(bytes.NewReader(swapped))
+	assert.ErrorContains(t, err, "block index 0 follows 1")
+	assert.Equal(t, uint64(64), replica.Capacity())
+
+	// So are duplicated records
+	duplicated := bytes.Clone(data[:deltaHeaderSize])
+	for i := 0; i < len(m.blocks); i++ {
+		duplicated = append(duplicated, record(max(i-1, 0))...)
+	}
+
+	_, err = replica.ApplyDelta(bytes.NewReader(duplicated))
+	assert.ErrorContains(t, err, "block index 0 follows 0")
+
+	_, err = replica.ApplyDelta(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.True(t, m.Equal(replica, testValueEqual))
+}
+
+func TestFixedBlockMap_DeltaSnapshot(t *testing.T) {
+	m, keys := newTestMap(t, 64, "delta", 20)
+	replica := m.Clone()
+	m.ClearDirty()
+
+	require.NoError(t, m.Put(keys[0], testValue{ID: 1000}))
+	var buf bytes.Buffer
+	_, err := m.WriteDelta(&buf)
+	require.NoError(t, err)
+
+	// Snapshots of the replica keep the state from before the delta
+	snapshot := replica.Snapshot()
+	defer snapshot.Release()
+
+	_, err = replica.ApplyDelta(&buf)
+	require.NoError(t, err)
+
+	value, found := replica.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(1000), value.ID)
+
+	old, found := snapshot.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(0), old.ID)
+}
@@ -0,0 +1,168 @@
+package collections
+
+import "iter"
+
+// sameBlock reports whether two blocks hold the same keys in the same slots
+// with the same deleted slots. Values are not compared.
+func sameBlock[V any](a, b *FixedBlock[V]) bool {
+	if a.control != b.control {
+		return false
+	}
+
+	for i := 0; i < FixedBlockSize; i++ {
+		ctrl := a.controlByte(i)
+		if ctrl != 0x0 && ctrl != 0x1 && a.keys[i] != b.keys[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// changedBlocks returns a bitmap of the blocks that differ between two maps
+// with the same block count, or nil when the block counts differ and every
+// block has to be treated as changed
+func (m *FixedBlockMap[V]) changedBlocks(other *FixedBlockMap[V]) []uint64 {
+	if len(m.blocks) != len(other.blocks) {
+		return nil
+	}
+
+	changed := make([]uint64, (len(m.blocks)+63)/64)
+	for blockIndex := range m.blocks {
+		if !sameBlock(&m.blocks[blockIndex], &other.blocks[blockIndex]) {
+			changed[blockIndex/64] |= 1 << (blockIndex % 64)
+		}
+	}
+
+	return changed
+}
+
+// Diff returns an iterator over the changes that turn m into other: keys only
+// in other are added, keys only in m are removed, and keys in both whose
+// values are not equal according to eq are modified.
+//
+// When both maps have the same block count, as a snapshot read with ReadFrom
+// and the map it was written from usually do, the blocks are compared first.
+// Blocks holding the same keys in the same slots only need their values
+// compared, so only the blocks that differ are searched entry by entry.
+//
+// other must not be modified while iterating. m may be modified by applying
+// the changes as they are yielded, as in m.Patch(m.Diff(other, eq)).
+func (m *FixedBlockMap[V]) Diff(other *FixedBlockMap[V], eq func(a, b *V) bool) iter.Seq[Change[V]] {
+	return func(yield func(Change[V]) bool) {
+		changed := m.changedBlocks(other)
+		isChanged := func(blockIndex int) bool {
+			return changed == nil || changed[blockIndex/64]&(1<<(blockIndex%64)) != 0
+		}
+
+		// Removed and modified keys. Applying these changes to m never moves
+		// its entries, so its blocks can be walked while patching.
+		for blockIndex := range m.blocks {
+			block := &m.blocks[blockIndex]
+			same := !isChanged(blockIndex)
+
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := block.controlByte(i)
+				if ctrl == 0x0 || ctrl == 0x1 {
+					continue
+				}
+
+				key := block.keys[i]
+
+				var otherValue *V
+				if same {
+					otherValue = &other.blocks[blockIndex].values[i]
+				} else if value, found := other.Get(key); found {
+					otherValue = value
+				} else {
+					if !yield(Change[V]{Kind: ChangeRemoved, Key: key, Old: block.values[i]}) {
+						return
+					}
+					continue
+				}
+
+				if !eq(&block.values[i], otherValue) {
+					if !yield(Change[V]{Kind: ChangeModified, Key: key, Old: block.values[i], New: *otherValue}) {
+						return
+					}
+				}
+			}
+		}
+
+		// Added keys can only be in the blocks that differ
+		for blockIndex := range other.blocks {
+			if !isChanged(blockIndex) {
+				continue
+			}
+
+			block := &other.blocks[blockIndex]
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := block.controlByte(i)
+				if ctrl == 0x0 || ctrl == 0x1 {
+					continue
+				}
+
+				if _, found := m.Get(block.keys[i]); !found {
+					if !yield(Change[V]{Kind: ChangeAdded, Key: block.keys[i], New: block.values[i]}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Patch applies changes, such as those yielded by Diff, to the map. Added and
+// modified keys are stored with their new value and removed keys are deleted.
+// The map grows whenever it becomes too full to add a key.
+func (m *FixedBlockMap[V]) Patch(changes iter.Seq[Change[V]]) error {
+	for change := range changes {
+		if err := m.apply(change.Kind, change.Key, change.New); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply stores or deletes a single key. Keys that are already in the map are
+// updated in place, so applying a change never grows the map unless it adds a
+// key.
+func (m *FixedBlockMap[V]) apply(kind ChangeKind, key FixedBlockKey, value V) error {
+	if kind == ChangeRemoved {
+		m.Delete(key)
+		return nil
+	}
+
+	if _, found := m.Get(key); found {
+		return m.Put(key, value)
+	}
+
+	return m.putGrowing(key, value)
+}
+
+// Merge copies every entry of src into the map, growing it whenever it
+// becomes too full. For keys in both maps the stored value is the one resolve
+// returns for the current value and the value in src; a nil resolve keeps the
+// value in src.
+func (m *FixedBlockMap[V]) Merge(src *FixedBlockMap[V], resolve func(key FixedBlockKey, dst, src *V) V) error {
+	for key, value := range src.Iter() {
+		if current, found := m.Get(key); found {
+			merged := *value
+			if resolve != nil {
+				merged = resolve(key, current, value)
+			}
+
+			if err := m.Put(key, merged); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := m.putGrowing(key, *value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package collections
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectMapChanges collects the changes from m to other by key
+func collectMapChanges(t *testing.T, m, other *FixedBlockMap[testValue]) map[FixedBlockKey]Change[testValue] {
+	changes := make(map[FixedBlockKey]Change[testValue])
+	for change := range m.Diff(other, testValueEqual) {
+		_, duplicate := changes[change.Key]
+		require.False(t, duplicate, "key reported twice")
+		changes[change.Key] = change
+	}
+
+	return changes
+}
+
+// mustGet returns the value of a key that must be in the map
+func mustGet(t *testing.T, m *FixedBlockMap[testValue], key FixedBlockKey) *testValue {
+	value, found := m.Get(key)
+	require.True(t, found)
+	return value
+}
+
+func TestFixedBlockMap_Diff(t *testing.T) {
+	live, keys := newTestMap(t, 128, "diff", 60)
+	snapshot := live.Clone()
+
+	var added FixedBlockKey
+	added.FromString("diff-added")
+	require.NoError(t, live.Put(added, testValue{ID: 1000}))
+	require.NoError(t, live.Put(keys[3], testValue{ID: 3000}))
+	require.NoError(t, live.Put(keys[4], *mustGet(t, snapshot, keys[4])))
+	live.Delete(keys[5])
+
+	changes := collectMapChanges(t, snapshot, live)
+	require.Len(t, changes, 3)
+
+	assert.Equal(t, Change[testValue]{Kind: ChangeAdded, Key: added, New: testValue{ID: 1000}}, changes[added])
+	assert.Equal(t, ChangeModified, changes[keys[3]].Kind)
+	assert.Equal(t, uint64(3), changes[keys[3]].Old.ID)
+	assert.Equal(t, uint64(3000), changes[keys[3]].New.ID)
+	assert.Equal(t, ChangeRemoved, changes[keys[5]].Kind)
+	assert.Equal(t, uint64(5), changes[keys[5]].Old.ID)
+
+	// The reverse diff undoes the changes
+	reverse := collectMapChanges(t, live, snapshot)
+	assert.Equal(t, ChangeRemoved, reverse[added].Kind)
+	assert.Equal(t, ChangeAdded, reverse[keys[5]].Kind)
+	assert.Equal(t, uint64(3), reverse[keys[3]].New.ID)
+
+	assert.Empty(t, collectMapChanges(t, live, live))
+}
+
+func TestFixedBlockMap_DiffDifferentLayout(t *testing.T) {
+	m, keys := newTestMap(t, 64, "layout", 40)
+	other, err := m.CloneWithCapacity(512)
+	require.NoError(t, err)
+	require.NotEqual(t, len(m.blocks), len(other.blocks))
+
+	assert.Empty(t, collectMapChanges(t, m, other))
+
+	other.Delete(keys[0])
+	require.NoError(t, other.Put(keys[1], testValue{ID: 100}))
+
+	changes := collectMapChanges(t, m, other)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, ChangeRemoved, changes[keys[0]].Kind)
+	assert.Equal(t, ChangeModified, changes[keys[1]].Kind)
+}
+
+func TestFixedBlockMap_DiffStop(t *testing.T) {
+	m, _ := newTestMap(t, 64, "stop", 20)
+	other := NewFixedBlockMap[testValue](64)
+
+	count := 0
+	for range m.Diff(other, testValueEqual) {
+		count++
+		if count == 5 {
+			break
+		}
+	}
+	assert.Equal(t, 5, count)
+}
+
+func TestFixedBlockMap_Patch(t *testing.T) {
+	rng := rand.New(rand.NewPCG(9, 10))
+
+	for _, sameLayout := range []bool{true, false} {
+		live, keys := newTestMap(t, 256, "patch", 150)
+		snapshot := live.Clone()
+		if !sameLayout {
+			var err error
+			snapshot, err = live.CloneWithCapacity(4096)
+			require.NoError(t, err)
+		}
+
+		for i := 0; i < 300; i++ {
+			key := keys[rng.IntN(len(keys))]
+			switch rng.IntN(3) {
+			case 0:
+				live.Delete(key)
+			default:
+				require.NoError(t, live.Put(key, testValue{ID: uint64(rng.IntN(1000))}))
+			}
+		}
+		for i := 0; i < 100; i++ {
+			var key FixedBlockKey
+			key.FromString(fmt.Sprintf("patch-added%d", i))
+			require.NoError(t, live.Put(key, testValue{ID: uint64(i)}))
+		}
+
+		// Patching a map with its own diff while iterating brings it up to date
+		require.NoError(t, snapshot.Patch(snapshot.Diff(live, testValueEqual)))
+		assert.True(t, snapshot.Equal(live, testValueEqual))
+		assert.Empty(t, collectMapChanges(t, snapshot, live))
+	}
+}
+
+func TestFixedBlockMap_PatchGrows(t *testing.T) {
+	m := NewFixedBlockMap[testValue](8)
+	source, keys := newTestMap(t, 256, "grow", 100)
+
+	require.NoError(t, m.Patch(m.Diff(source, testValueEqual)))
+	assert.Equal(t, uint64(100), m.Len())
+	assert.True(t, m.Equal(source, testValueEqual))
+
+	require.NoError(t, m.Patch(func(yield func(Change[testValue]) bool) {
+		yield(Change[testValue]{Kind: ChangeRemoved, Key: keys[0]})
+	}))
+	_, found := m.Get(keys[0])
+	assert.False(t, found)
+}
+
+func TestFixedBlockMap_Merge(t *testing.T) {
+	dst, keys := newTestMap(t, 16, "merge", 10)
+	src := NewFixedBlockMap[testValue](64)
+
+	for i := 5; i < 40; i++ {
+		var key FixedBlockKey
+		if i < len(keys) {
+			key = keys[i]
+		} else {
+			key.FromString(fmt.Sprintf("merge-src%d", i))
+		}
+		require.NoError(t, src.Put(key, testValue{ID: uint64(i) * 100}))
+	}
+
+	var conflicts int
+	require.NoError(t, dst.Merge(src, func(key FixedBlockKey, a, b *testValue) testValue {
+		conflicts++
+		return testValue{ID: a.ID + b.ID}
+	}))
+
+	assert.Equal(t, 5, conflicts)
+	assert.Equal(t, uint64(40), dst.Len())
+	assert.Equal(t, uint64(0), mustGet(t, dst, keys[0]).ID)
+	assert.Equal(t, uint64(707), mustGet(t, dst, keys[7]).ID)
+
+	// Without a resolve function src wins
+	require.NoError(t, dst.Merge(src, nil))
+	assert.Equal(t, uint64(700), mustGet(t, dst, keys[7]).ID)
+
+	// Merging a map into itself keeps every entry
+	require.NoError(t, dst.Merge(dst, nil))
+	assert.Equal(t, uint64(40), dst.Len())
+}
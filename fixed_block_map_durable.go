@@ -0,0 +1,482 @@
+package collections
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SyncPolicy controls when a DurableFixedBlockMap forces its log to disk.
+// Every record is written to the log file before Put or Delete return, so a
+// crash of the process loses nothing under any policy; the policy only
+// decides how much a crash of the machine can lose.
+type SyncPolicy int
+
+const (
+	// SyncEveryWrite syncs the log before Put or Delete return. Concurrent
+	// writers share a single sync (group commit), so the cost is amortized
+	// when there are many writers.
+	SyncEveryWrite SyncPolicy = iota
+
+	// SyncBatch syncs the log once BatchSize mutations are waiting, so a
+	// crash of the machine loses at most the last batch
+	SyncBatch
+
+	// SyncNever leaves syncing to the operating system. The log is only
+	// synced by Sync, Checkpoint and Close.
+	SyncNever
+)
+
+// DurableOptions configures a DurableFixedBlockMap
+type DurableOptions struct {
+	// Capacity of the map when there is no snapshot to start from
+	Capacity uint64
+
+	// SyncPolicy controls when the log is forced to disk
+	SyncPolicy SyncPolicy
+
+	// BatchSize is the number of mutations per sync for SyncBatch.
+	// Defaults to 64.
+	BatchSize int
+}
+
+const (
+	durableSnapshotFile = "snapshot"
+	durableLogFile      = "wal"
+
+	walRecordHeaderSize = 8
+	walOpPut            = 1
+	walOpDelete         = 2
+)
+
+// errTornRecord marks the end of the usable part of a log: either the end of
+// the file, or a record that was not completely written before a crash
+var errTornRecord = errors.New("torn log record")
+
+// DurableFixedBlockMap wraps a FixedBlockMap with a write-ahead log, so that
+// mutations survive a crash. Every Put and Delete is appended to a log file in
+// a directory, and Checkpoint writes a snapshot of the map (using
+// WritePortableTo) after which the log starts over. Opening the directory
+// again loads the latest snapshot and replays the log on top of it.
+//
+// Records in the log are checksummed, so a record that was only partially
+// written when the process crashed is detected and discarded on replay. The
+// map grows automatically, so Put never fails with a map overflow.
+//
+// A mutation is only applied to the map after its record was appended to the
+// log, so a mutation that could not be logged is never visible. A failure to
+// sync the log is reported by the Put or Delete that triggered it, after the
+// mutations it covers were applied; every later mutation then fails with the
+// same error.
+//
+// All methods are safe for concurrent use.
+type DurableFixedBlockMap[V any] struct {
+	mu      sync.Mutex // guards the map and writes to the log
+	syncMu  sync.Mutex // serializes syncs of the log file
+	m       *FixedBlockMap[V]
+	dir     string
+	options DurableOptions
+	codec   portableCodec[V]
+	file    *os.File
+	log     io.Writer // the log file, written one record at a time
+	record  []byte
+	written uint64 // number of records appended
+	synced  uint64 // number of records known to be on disk
+	pending int    // records appended since the last sync, for SyncBatch
+	err     error  // sticky error after a failed write
+}
+
+// OpenDurableFixedBlockMap opens the durable map stored in dir, creating the
+// directory if needed. The snapshot, if any, is loaded and the log replayed
+// on top of it.
+func OpenDurableFixedBlockMap[V any](dir string, options DurableOptions) (*DurableFixedBlockMap[V], error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.BatchSize <= 0 {
+		options.BatchSize = 64
+	}
+
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	d := &DurableFixedBlockMap[V]{
+		dir:     dir,
+		options: options,
+		codec:   codec,
+		record:  make([]byte, walRecordHeaderSize+1+len(FixedBlockKey{})+codec.size),
+	}
+
+	if err = d.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, durableLogFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.replay(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	d.file = file
+	d.log = file
+
+	return d, nil
+}
+
+// loadSnapshot reads the snapshot file, or creates an empty map without one
+func (d *DurableFixedBlockMap[V]) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(d.dir, durableSnapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		d.m = NewFixedBlockMap[V](d.options.Capacity)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	d.m = &FixedBlockMap[V]{}
+	if _, err = d.m.ReadPortableFrom(bufio.NewReader(file)); err != nil {
+		return fmt.Errorf("loading snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// replay applies the records in the log to the map. The log is truncated
+// after the last complete record, dropping a torn write at the end.
+func (d *DurableFixedBlockMap[V]) replay(file *os.File) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		n, err := d.readRecord(reader)
+		if errors.Is(err, errTornRecord) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("replaying log at offset %d: %w", offset, err)
+		}
+
+		offset += int64(n)
+	}
+
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+
+	_, err := file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord reads a record from the log and applies it to the map. It
+// returns errTornRecord at the end of the log, including when the last
+// record is incomplete or its checksum does not match.
+func (d *DurableFixedBlockMap[V]) readRecord(r io.Reader) (int, error) {
+	header := d.record[:walRecordHeaderSize]
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, errTornRecord
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size < 1 || int(size) > len(d.record)-walRecordHeaderSize {
+		return 0, errTornRecord
+	}
+
+	payload := d.record[walRecordHeaderSize : walRecordHeaderSize+size]
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, errTornRecord
+	}
+
+	if crc32.Checksum(payload, castagnoliTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, errTornRecord
+	}
+
+	var key FixedBlockKey
+	copy(key[:], payload[1:])
+
+	switch {
+	case payload[0] == walOpPut && int(size) == 1+len(key)+d.codec.size:
+		var value V
+		if err := d.codec.decode(payload[1+len(key):], &value); err != nil {
+			return 0, err
+		}
+		if err := d.m.putGrowing(key, value); err != nil {
+			return 0, err
+		}
+	case payload[0] == walOpDelete && int(size) == 1+len(key):
+		d.m.Delete(key)
+	default:
+		return 0, errors.New("invalid log record")
+	}
+
+	return walRecordHeaderSize + int(size), nil
+}
+
+// appendRecord writes a record to the log file. The record is not buffered,
+// so once it was written only a crash of the machine can lose it. The caller
+// must hold mu.
+func (d *DurableFixedBlockMap[V]) appendRecord(op byte, key FixedBlockKey, value *V) error {
+	payload := d.record[walRecordHeaderSize:]
+	payload[0] = op
+	copy(payload[1:], key[:])
+	size := 1 + len(key)
+
+	if value != nil {
+		d.codec.encode(payload[size:], value)
+		size += d.codec.size
+	}
+
+	binary.LittleEndian.PutUint32(d.record[0:4], uint32(size))
+	binary.LittleEndian.PutUint32(d.record[4:8], crc32.Checksum(payload[:size], castagnoliTable))
+
+	if _, err := d.log.Write(d.record[:walRecordHeaderSize+size]); err != nil {
+		d.err = err
+		return err
+	}
+
+	d.written++
+	d.pending++
+
+	return nil
+}
+
+// Get returns a copy of the value stored for key
+func (d *DurableFixedBlockMap[V]) Get(key FixedBlockKey) (V, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if value, found := d.m.Get(key); found {
+		return *value, true
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Len returns the number of entries in the map
+func (d *DurableFixedBlockMap[V]) Len() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.m.Len()
+}
+
+// Put logs the mutation and then inserts or updates a key. Depending on the
+// sync policy, the log has been synced to disk when Put returns.
+func (d *DurableFixedBlockMap[V]) Put(key FixedBlockKey, value V) error {
+	d.mu.Lock()
+
+	if d.err != nil {
+		d.mu.Unlock()
+		return d.err
+	}
+
+	// Make room before logging, so the logged Put cannot fail afterwards
+	if _, found := d.m.Get(key); !found {
+		if err := d.m.reserve(); err != nil {
+			d.mu.Unlock()
+			return err
+		}
+	}
+
+	err := d.appendRecord(walOpPut, key, &value)
+	if err == nil {
+		err = d.m.Put(key, value)
+	}
+
+	return d.commit(err)
+}
+
+// Delete logs the mutation and then removes a key. Depending on the sync
+// policy, the log has been synced to disk when Delete returns.
+func (d *DurableFixedBlockMap[V]) Delete(key FixedBlockKey) error {
+	d.mu.Lock()
+
+	if d.err != nil {
+		d.mu.Unlock()
+		return d.err
+	}
+
+	if _, found := d.m.Get(key); !found {
+		d.mu.Unlock()
+		return nil
+	}
+
+	err := d.appendRecord(walOpDelete, key, nil)
+	if err == nil {
+		d.m.Delete(key)
+	}
+
+	return d.commit(err)
+}
+
+// commit releases mu after a record was appended and syncs the log as
+// required by the sync policy
+func (d *DurableFixedBlockMap[V]) commit(err error) error {
+	written := d.written
+	syncNow := d.options.SyncPolicy == SyncEveryWrite ||
+		(d.options.SyncPolicy == SyncBatch && d.pending >= d.options.BatchSize)
+
+	d.mu.Unlock()
+
+	if err != nil || !syncNow {
+		return err
+	}
+
+	return d.syncUpTo(written)
+}
+
+// syncUpTo makes sure the first written records are on disk. While one
+// goroutine syncs, others keep appending records, and the next sync covers
+// all of them at once.
+func (d *DurableFixedBlockMap[V]) syncUpTo(written uint64) error {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+
+	if d.synced >= written {
+		return nil
+	}
+
+	d.mu.Lock()
+	if d.err != nil {
+		d.mu.Unlock()
+		return d.err
+	}
+
+	target := d.written
+	d.pending = 0
+	d.mu.Unlock()
+
+	// The slow part runs without holding mu, so writers are not blocked
+	if err := d.file.Sync(); err != nil {
+		d.mu.Lock()
+		d.err = err
+		d.mu.Unlock()
+		return err
+	}
+
+	d.synced = target
+	return nil
+}
+
+// Sync forces every logged mutation to disk
+func (d *DurableFixedBlockMap[V]) Sync() error {
+	d.mu.Lock()
+	written := d.written
+	d.mu.Unlock()
+
+	return d.syncUpTo(written)
+}
+
+// Checkpoint writes a snapshot of the map and truncates the log. The snapshot
+// is written to a temporary file and renamed into place, so a crash during
+// the checkpoint leaves the previous snapshot intact. Replaying a log on top
+// of a newer snapshot is harmless, since applying the same mutations again in
+// order results in the same map.
+func (d *DurableFixedBlockMap[V]) Checkpoint() error {
+	d.syncMu.Lock()
+	defer d.syncMu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.err != nil {
+		return d.err
+	}
+
+	if err := d.writeSnapshot(); err != nil {
+		return err
+	}
+
+	// Start the log over
+	if err := d.file.Truncate(0); err != nil {
+		d.err = err
+		return err
+	}
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		d.err = err
+		return err
+	}
+	if err := d.file.Sync(); err != nil {
+		d.err = err
+		return err
+	}
+
+	d.synced = d.written
+	d.pending = 0
+
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot file
+func (d *DurableFixedBlockMap[V]) writeSnapshot() error {
+	path := filepath.Join(d.dir, durableSnapshotFile)
+	temp := path + ".tmp"
+
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+
+	// The portable format, like the log, does not depend on the machine
+	buffered := bufio.NewWriter(file)
+	_, err = d.m.WritePortableTo(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, path)
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return syncDir(d.dir)
+}
+
+// syncDir makes a rename in the directory durable
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// Close syncs the log and closes it. The map must not be used afterwards.
+func (d *DurableFixedBlockMap[V]) Close() error {
+	err := d.Sync()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if closeErr := d.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	d.err = os.ErrClosed
+	return err
+}
@@ -0,0 +1,296 @@
+package collections
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func durableKey(i int) FixedBlockKey {
+	var key FixedBlockKey
+	key.FromString(fmt.Sprintf("durable%d", i))
+	return key
+}
+
+func TestDurableFixedBlockMap_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 16})
+	require.NoError(t, err)
+
+	// More entries than the initial capacity
+	for i := 0; i < 100; i++ {
+		require.NoError(t, d.Put(durableKey(i), testValue{ID: uint64(i)}))
+	}
+	for i := 0; i < 100; i += 4 {
+		require.NoError(t, d.Delete(durableKey(i)))
+	}
+	require.NoError(t, d.Put(durableKey(1), testValue{ID: 1000}))
+	require.NoError(t, d.Delete(durableKey(5000)))
+	require.NoError(t, d.Close())
+
+	// Operations fail once closed
+	assert.Error(t, d.Put(durableKey(0), testValue{}))
+
+	d, err = OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 16})
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, uint64(75), d.Len())
+	for i := 0; i < 100; i++ {
+		value, found := d.Get(durableKey(i))
+		switch {
+		case i%4 == 0:
+			assert.False(t, found)
+		case i == 1:
+			assert.Equal(t, uint64(1000), value.ID)
+		default:
+			require.True(t, found)
+			assert.Equal(t, uint64(i), value.ID)
+		}
+	}
+}
+
+// failingWriter fails every write, like a log on a full disk
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestDurableFixedBlockMap_FailedLogWrite(t *testing.T) {
+	for _, op := range []string{"put", "delete"} {
+		t.Run(op, func(t *testing.T) {
+			dir := t.TempDir()
+
+			d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 16})
+			require.NoError(t, err)
+			require.NoError(t, d.Put(durableKey(0), testValue{ID: 1}))
+
+			// Records no longer reach the log
+			d.log = failingWriter{}
+
+			if op == "put" {
+				assert.ErrorContains(t, d.Put(durableKey(1), testValue{ID: 2}), "disk full")
+				assert.ErrorContains(t, d.Put(durableKey(0), testValue{ID: 3}), "disk full")
+			} else {
+				assert.ErrorContains(t, d.Delete(durableKey(0)), "disk full")
+			}
+
+			// Mutations that were not logged are not visible
+			value, found := d.Get(durableKey(0))
+			require.True(t, found)
+			assert.Equal(t, uint64(1), value.ID)
+			_, found = d.Get(durableKey(1))
+			assert.False(t, found)
+			assert.Equal(t, uint64(1), d.Len())
+
+			require.NoError(t, d.file.Close())
+		})
+	}
+}
+
+func TestDurableFixedBlockMap_Checkpoint(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 64})
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, d.Put(durableKey(i), testValue{ID: uint64(i)}))
+	}
+	require.NoError(t, d.Checkpoint())
+
+	info, err := os.Stat(filepath.Join(dir, durableLogFile))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size(), "log is truncated after a checkpoint")
+
+	// The snapshot uses the portable format, like the log
+	snapshot, err := os.Open(filepath.Join(dir, durableSnapshotFile))
+	require.NoError(t, err)
+	var portable FixedBlockMap[testValue]
+	_, err = portable.ReadPortableFrom(snapshot)
+	require.NoError(t, err)
+	require.NoError(t, snapshot.Close())
+	assert.Equal(t, uint64(50), portable.Len())
+
+	// Mutations after the checkpoint go to the new log
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Delete(durableKey(i)))
+	}
+	require.NoError(t, d.Close())
+
+	d, err = OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, uint64(40), d.Len())
+	_, found := d.Get(durableKey(0))
+	assert.False(t, found)
+	value, found := d.Get(durableKey(20))
+	require.True(t, found)
+	assert.Equal(t, uint64(20), value.ID)
+}
+
+func TestDurableFixedBlockMap_CrashDuringCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, durableLogFile)
+
+	d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 64})
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, d.Put(durableKey(i), testValue{ID: uint64(i)}))
+	}
+	for i := 0; i < 5; i++ {
+		require.NoError(t, d.Delete(durableKey(i)))
+	}
+	require.NoError(t, d.Put(durableKey(5), testValue{ID: 500}))
+	require.NoError(t, d.Sync())
+
+	oldLog, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+
+	require.NoError(t, d.Checkpoint())
+	require.NoError(t, d.Close())
+
+	// Simulate a crash after the snapshot was renamed but before the log was
+	// truncated: the whole old log is replayed on top of the new snapshot
+	require.NoError(t, os.WriteFile(logPath, oldLog, 0o644))
+
+	d, err = OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+	require.NoError(t, err)
+	defer d.Close()
+
+	assert.Equal(t, uint64(15), d.Len())
+	value, found := d.Get(durableKey(5))
+	require.True(t, found)
+	assert.Equal(t, uint64(500), value.ID)
+	_, found = d.Get(durableKey(0))
+	assert.False(t, found)
+}
+
+func TestDurableFixedBlockMap_TornWrite(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, durableLogFile)
+
+	d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 64})
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, d.Put(durableKey(i), testValue{ID: uint64(i)}))
+	}
+	require.NoError(t, d.Close())
+
+	complete, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	recordSize := len(complete) / 10
+
+	tests := []struct {
+		name    string
+		log     []byte
+		entries uint64
+	}{
+		{"partial header", complete[:len(complete)-recordSize+3], 9},
+		{"partial payload", complete[:len(complete)-5], 9},
+		{"bad checksum", append(complete[:len(complete)-1:len(complete)-1], complete[len(complete)-1]^0xFF), 9},
+		{"garbage after records", append(append([]byte(nil), complete...), 0xDE, 0xAD, 0xBE, 0xEF, 0x01), 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(logPath, test.log, 0o644))
+
+			d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, test.entries, d.Len())
+
+			// The torn record is cut off, so new records follow complete ones
+			require.NoError(t, d.Put(durableKey(100), testValue{ID: 100}))
+			require.NoError(t, d.Close())
+
+			d, err = OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, test.entries+1, d.Len())
+			value, found := d.Get(durableKey(100))
+			require.True(t, found)
+			assert.Equal(t, uint64(100), value.ID)
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestDurableFixedBlockMap_SyncPolicies(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncEveryWrite, SyncBatch, SyncNever} {
+		t.Run(fmt.Sprint(policy), func(t *testing.T) {
+			dir := t.TempDir()
+			d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{
+				Capacity:   64,
+				SyncPolicy: policy,
+				BatchSize:  8,
+			})
+			require.NoError(t, err)
+
+			for i := 0; i < 20; i++ {
+				require.NoError(t, d.Put(durableKey(i), testValue{ID: uint64(i)}))
+			}
+
+			// Every record reaches the log file before Put returns, whatever
+			// the policy, so a crash of the process that abandons the map
+			// without Close loses nothing
+			log, err := os.ReadFile(filepath.Join(dir, durableLogFile))
+			require.NoError(t, err)
+			recordSize := walRecordHeaderSize + 1 + 16 + 18
+			assert.Equal(t, 20*recordSize, len(log))
+
+			// The policy only decides how many records wait for a sync
+			switch policy {
+			case SyncEveryWrite:
+				assert.Equal(t, d.written, d.synced)
+			case SyncBatch:
+				assert.Equal(t, uint64(16), d.synced)
+			case SyncNever:
+				assert.Equal(t, uint64(0), d.synced)
+			}
+
+			crashed, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, uint64(20), crashed.Len())
+			require.NoError(t, crashed.Close())
+
+			require.NoError(t, d.Sync())
+			assert.Equal(t, d.written, d.synced)
+			require.NoError(t, d.Close())
+		})
+	}
+}
+
+func TestDurableFixedBlockMap_ConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+
+	d, err := OpenDurableFixedBlockMap[testValue](dir, DurableOptions{Capacity: 64})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for writer := 0; writer < 8; writer++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.NoError(t, d.Put(durableKey(writer*1000+i), testValue{ID: uint64(i)}))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, d.Close())
+
+	d, err = OpenDurableFixedBlockMap[testValue](dir, DurableOptions{})
+	require.NoError(t, err)
+	defer d.Close()
+	assert.Equal(t, uint64(400), d.Len())
+}
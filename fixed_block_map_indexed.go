@@ -0,0 +1,127 @@
+package collections
+
+import (
+	"bytes"
+	"iter"
+)
+
+// indexEntry orders entries by sort key, and by key among equal sort keys, so
+// that several entries can share a sort key
+type indexEntry[K any] struct {
+	sortKey K
+	key     FixedBlockKey
+}
+
+// IndexedFixedBlockMap is a FixedBlockMap with an OrderedMap as a secondary
+// index, so entries can be looked up by key in constant time and scanned in
+// the order of a sort key derived from their value, such as a timestamp.
+// Several entries may share a sort key.
+//
+// The index is kept in sync by Put and Delete, so values must not be modified
+// through the pointers returned by Get or the iterators, and the map returned
+// by Map must not be modified directly.
+type IndexedFixedBlockMap[K, V any] struct {
+	m       *FixedBlockMap[V]
+	index   *OrderedMap[indexEntry[K], struct{}]
+	sortKey func(value *V) K
+}
+
+// NewIndexedFixedBlockMap creates an empty map of the given capacity, indexed
+// by the sort key that sortKey returns for a value and ordered by cmp
+func NewIndexedFixedBlockMap[K, V any](capacity uint64, sortKey func(value *V) K, cmp func(a, b K) int) *IndexedFixedBlockMap[K, V] {
+	return IndexFixedBlockMap(NewFixedBlockMap[V](capacity), sortKey, cmp)
+}
+
+// IndexFixedBlockMap builds an index over the entries of an existing map, for
+// example one read with ReadFrom, and takes ownership of the map
+func IndexFixedBlockMap[K, V any](m *FixedBlockMap[V], sortKey func(value *V) K, cmp func(a, b K) int) *IndexedFixedBlockMap[K, V] {
+	index := NewOrderedMapFunc[indexEntry[K], struct{}](func(a, b indexEntry[K]) int {
+		if c := cmp(a.sortKey, b.sortKey); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.key[:], b.key[:])
+	})
+
+	for key, value := range m.Iter() {
+		index.Put(indexEntry[K]{sortKey: sortKey(value), key: key}, struct{}{})
+	}
+
+	return &IndexedFixedBlockMap[K, V]{
+		m:       m,
+		index:   index,
+		sortKey: sortKey,
+	}
+}
+
+// Map returns the underlying map, for read-only operations such as
+// CollectInfo and WriteTo
+func (im *IndexedFixedBlockMap[K, V]) Map() *FixedBlockMap[V] {
+	return im.m
+}
+
+// Len returns the number of entries in the map
+func (im *IndexedFixedBlockMap[K, V]) Len() uint64 {
+	return im.m.Len()
+}
+
+// Get retrieves the value for a key. The value must not be modified.
+func (im *IndexedFixedBlockMap[K, V]) Get(key FixedBlockKey) (*V, bool) {
+	return im.m.Get(key)
+}
+
+// Put inserts or replaces the value for a key and updates the index
+func (im *IndexedFixedBlockMap[K, V]) Put(key FixedBlockKey, value V) error {
+	var old *indexEntry[K]
+	if previous, found := im.m.Get(key); found {
+		old = &indexEntry[K]{sortKey: im.sortKey(previous), key: key}
+	}
+
+	if err := im.m.Put(key, value); err != nil {
+		return err
+	}
+
+	if old != nil {
+		im.index.Delete(*old)
+	}
+	im.index.Put(indexEntry[K]{sortKey: im.sortKey(&value), key: key}, struct{}{})
+
+	return nil
+}
+
+// Delete removes a key from the map and the index
+func (im *IndexedFixedBlockMap[K, V]) Delete(key FixedBlockKey) {
+	if value, found := im.m.Get(key); found {
+		im.index.Delete(indexEntry[K]{sortKey: im.sortKey(value), key: key})
+		im.m.Delete(key)
+	}
+}
+
+// entries converts an iterator over the index into one over the map
+func (im *IndexedFixedBlockMap[K, V]) entries(seq iter.Seq2[indexEntry[K], *struct{}]) iter.Seq2[FixedBlockKey, *V] {
+	return func(yield func(FixedBlockKey, *V) bool) {
+		for entry := range seq {
+			value, _ := im.m.Get(entry.key)
+			if !yield(entry.key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Iter returns an iterator over every entry in ascending sort key order
+func (im *IndexedFixedBlockMap[K, V]) Iter() iter.Seq2[FixedBlockKey, *V] {
+	return im.entries(im.index.Iter())
+}
+
+// Seek returns an iterator over the entries with a sort key greater than or
+// equal to sortKey, in ascending order
+func (im *IndexedFixedBlockMap[K, V]) Seek(sortKey K) iter.Seq2[FixedBlockKey, *V] {
+	return im.entries(im.index.Seek(indexEntry[K]{sortKey: sortKey}))
+}
+
+// Range returns an iterator over the entries with a sort key in [lo, hi), in
+// ascending order
+func (im *IndexedFixedBlockMap[K, V]) Range(lo, hi K) iter.Seq2[FixedBlockKey, *V] {
+	// The zero key sorts before every other key with the same sort key
+	return im.entries(im.index.Range(indexEntry[K]{sortKey: lo}, indexEntry[K]{sortKey: hi}))
+}
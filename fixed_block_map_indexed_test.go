@@ -0,0 +1,74 @@
+package collections
+
+import (
+	"bytes"
+	"cmp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sessionCreated(value *session) int64 {
+	return value.Created
+}
+
+// sessionIDs returns the IDs of the sessions of an iterator in order
+func sessionIDs(seq func(yield func(FixedBlockKey, *session) bool)) []uint64 {
+	var ids []uint64
+	for _, value := range seq {
+		ids = append(ids, value.ID)
+	}
+	return ids
+}
+
+func TestIndexedFixedBlockMap_Range(t *testing.T) {
+	im := NewIndexedFixedBlockMap[int64, session](64, sessionCreated, cmp.Compare[int64])
+
+	// Sessions 0-19 created at 100, 110, ..., with 20-24 sharing timestamps
+	for i := uint64(0); i < 25; i++ {
+		require.NoError(t, im.Put(bloomKey("session", int(i)), session{ID: i, Created: int64(100 + 10*(i%20))}))
+	}
+	assert.Equal(t, uint64(25), im.Len())
+
+	ids := sessionIDs(im.Range(120, 150))
+	assert.ElementsMatch(t, []uint64{2, 3, 4, 22, 23, 24}, ids)
+	assert.ElementsMatch(t, []uint64{2, 22}, ids[:2])
+
+	assert.ElementsMatch(t, []uint64{19}, sessionIDs(im.Seek(281)))
+
+	// Updating a value moves it in the index
+	require.NoError(t, im.Put(bloomKey("session", 3), session{ID: 3, Created: 1000}))
+	assert.ElementsMatch(t, []uint64{2, 4, 22, 23, 24}, sessionIDs(im.Range(120, 150)))
+	assert.Equal(t, []uint64{3}, sessionIDs(im.Seek(500)))
+
+	im.Delete(bloomKey("session", 3))
+	im.Delete(bloomKey("session", 3))
+	assert.Empty(t, sessionIDs(im.Seek(500)))
+	assert.Equal(t, uint64(24), im.Len())
+	assert.Equal(t, uint64(24), im.index.Len())
+
+	value, found := im.Get(bloomKey("session", 4))
+	require.True(t, found)
+	assert.Equal(t, int64(140), value.Created)
+}
+
+func TestIndexedFixedBlockMap_Reindex(t *testing.T) {
+	im := NewIndexedFixedBlockMap[int64, session](64, sessionCreated, cmp.Compare[int64])
+	for i := uint64(0); i < 30; i++ {
+		require.NoError(t, im.Put(bloomKey("session", int(i)), session{ID: i, Created: int64(30 - i)}))
+	}
+
+	// The index is rebuilt from a map read back from its raw format
+	var buf bytes.Buffer
+	_, err := im.Map().WriteTo(&buf)
+	require.NoError(t, err)
+
+	var m FixedBlockMap[session]
+	_, err = m.ReadFrom(&buf)
+	require.NoError(t, err)
+
+	loaded := IndexFixedBlockMap(&m, sessionCreated, cmp.Compare[int64])
+	assert.Equal(t, sessionIDs(im.Iter()), sessionIDs(loaded.Iter()))
+	assert.Equal(t, []uint64{29, 28, 27}, sessionIDs(loaded.Range(0, 4)))
+}
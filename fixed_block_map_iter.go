@@ -0,0 +1,116 @@
+package collections
+
+import "iter"
+
+// Keys returns an iterator over the keys of every entry in the map
+func (m *FixedBlockMap[V]) Keys() iter.Seq[FixedBlockKey] {
+	return func(yield func(FixedBlockKey) bool) {
+		for key := range m.Iter() {
+			if !yield(key) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over pointers to every value in the map
+func (m *FixedBlockMap[V]) Values() iter.Seq[*V] {
+	return func(yield func(*V) bool) {
+		for _, value := range m.Iter() {
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over every entry in the map. Unlike Iter, it yields
+// copies of the values, so the results can be passed to functions such as
+// maps.Collect without aliasing the map's memory.
+func (m *FixedBlockMap[V]) All() iter.Seq2[FixedBlockKey, V] {
+	return func(yield func(FixedBlockKey, V) bool) {
+		for key, value := range m.Iter() {
+			if !yield(key, *value) {
+				return
+			}
+		}
+	}
+}
+
+// Filter returns an iterator over the entries for which pred returns true
+func (m *FixedBlockMap[V]) Filter(pred func(key FixedBlockKey, value *V) bool) iter.Seq2[FixedBlockKey, *V] {
+	return func(yield func(FixedBlockKey, *V) bool) {
+		for key, value := range m.Iter() {
+			if pred(key, value) && !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Collect returns a copy of every entry in the map
+func (m *FixedBlockMap[V]) Collect() []FixedBlockEntry[V] {
+	entries := make([]FixedBlockEntry[V], 0, m.Len())
+	for key, value := range m.Iter() {
+		entries = append(entries, FixedBlockEntry[V]{Key: key, Value: *value})
+	}
+
+	return entries
+}
+
+// CollectEntries copies the entries yielded by seq, such as the iterators
+// returned by Iter and Filter, into a slice
+func CollectEntries[V any](seq iter.Seq2[FixedBlockKey, *V]) []FixedBlockEntry[V] {
+	var entries []FixedBlockEntry[V]
+	for key, value := range seq {
+		entries = append(entries, FixedBlockEntry[V]{Key: key, Value: *value})
+	}
+
+	return entries
+}
+
+// FromSeq2 builds a map from the entries yielded by seq, such as the iterator
+// returned by maps.All or another map's All. The map starts out sized for
+// capacity entries and grows whenever it becomes too full, so capacity only
+// needs to be an estimate. Later entries overwrite earlier ones with the same
+// key.
+func FromSeq2[V any](seq iter.Seq2[FixedBlockKey, V], capacity uint64) (*FixedBlockMap[V], error) {
+	m := NewFixedBlockMap[V](capacity)
+
+	for key, value := range seq {
+		if err := m.putGrowing(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// putGrowing inserts or updates a key. When entries and tombstones would fill
+// 75% of the slots, the load at which CollectInfo recommends growing, the map
+// is made room for first: doubled if the entries alone reach that load, and
+// rehashed otherwise, so churn with a steady number of entries does not keep
+// growing it.
+func (m *FixedBlockMap[V]) putGrowing(key FixedBlockKey, value V) error {
+	if _, found := m.Get(key); !found {
+		if err := m.reserve(); err != nil {
+			return err
+		}
+	}
+
+	return m.Put(key, value)
+}
+
+// reserve makes room for one more entry like putGrowing, so that the next Put
+// cannot fail with a map overflow
+func (m *FixedBlockMap[V]) reserve() error {
+	if (m.count+m.tombstones+1)*4 < m.Capacity()*3 {
+		return nil
+	}
+
+	if (m.count+1)*4 >= m.Capacity()*3 {
+		return m.Grow(m.Capacity() * 2)
+	}
+
+	return m.Rehash()
+}
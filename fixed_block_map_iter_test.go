@@ -0,0 +1,149 @@
+package collections
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockMap_Keys(t *testing.T) {
+	m, keys := newTestMap(t, 64, "keys", 20)
+	m.Delete(keys[0])
+
+	collected := slices.Collect(m.Keys())
+	assert.ElementsMatch(t, keys[1:], collected)
+
+	// Stopping early
+	for range m.Keys() {
+		break
+	}
+}
+
+func TestFixedBlockMap_Values(t *testing.T) {
+	m, _ := newTestMap(t, 64, "values", 20)
+
+	var ids []uint64
+	for value := range m.Values() {
+		ids = append(ids, value.ID)
+	}
+	slices.Sort(ids)
+
+	expected := make([]uint64, 20)
+	for i := range expected {
+		expected[i] = uint64(i)
+	}
+	assert.Equal(t, expected, ids)
+
+	// The pointers refer to the stored values
+	for value := range m.Values() {
+		value.Score = 7
+	}
+	for value := range m.Values() {
+		assert.Equal(t, int32(7), value.Score)
+	}
+}
+
+func TestFixedBlockMap_All(t *testing.T) {
+	m, keys := newTestMap(t, 64, "all", 20)
+
+	collected := maps.Collect(m.All())
+	require.Len(t, collected, 20)
+	for i, key := range keys {
+		assert.Equal(t, uint64(i), collected[key].ID)
+	}
+
+	// Modifying the copies does not change the map
+	for _, value := range m.All() {
+		value.ID = 1000
+	}
+	val, _ := m.Get(keys[0])
+	assert.Equal(t, uint64(0), val.ID)
+}
+
+func TestFixedBlockMap_FilterAndCollect(t *testing.T) {
+	m, keys := newTestMap(t, 64, "filter", 20)
+
+	even := CollectEntries(m.Filter(func(key FixedBlockKey, value *testValue) bool {
+		return value.ID%2 == 0
+	}))
+	require.Len(t, even, 10)
+	for _, entry := range even {
+		assert.Equal(t, uint64(0), entry.Value.ID%2)
+		assert.Contains(t, keys, entry.Key)
+	}
+
+	// Stopping early
+	count := 0
+	for range m.Filter(func(FixedBlockKey, *testValue) bool { return true }) {
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, count)
+
+	all := m.Collect()
+	require.Len(t, all, 20)
+	for _, entry := range all {
+		val, found := m.Get(entry.Key)
+		require.True(t, found)
+		assert.Equal(t, *val, entry.Value)
+	}
+}
+
+func TestFromSeq2(t *testing.T) {
+	// From a Go map, starting far too small
+	source := make(map[FixedBlockKey]testValue)
+	for i := 0; i < 500; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("seq%d", i))
+		source[key] = testValue{ID: uint64(i)}
+	}
+
+	m, err := FromSeq2(maps.All(source), 8)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(500), m.Len())
+	assert.False(t, m.CollectInfo().RecommendGrow)
+	assert.Equal(t, source, maps.Collect(m.All()))
+
+	// From another map
+	copied, err := FromSeq2(m.All(), m.Len())
+	require.NoError(t, err)
+	assert.True(t, m.Equal(copied, testValueEqual))
+}
+
+func TestFixedBlockMap_PutGrowingChurn(t *testing.T) {
+	m := NewFixedBlockMap[testValue](1024)
+	capacity := m.Capacity()
+
+	// A steady 500 entries with constant churn leaves tombstones behind, which
+	// must be cleared by rehashing rather than by growing the map
+	for i := 0; i < 20000; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("churn%d", i))
+		require.NoError(t, m.putGrowing(key, testValue{ID: uint64(i)}))
+
+		if i >= 500 {
+			var old FixedBlockKey
+			old.FromString(fmt.Sprintf("churn%d", i-500))
+			m.Delete(old)
+		}
+	}
+
+	assert.Equal(t, uint64(500), m.Len())
+	assert.Equal(t, capacity, m.Capacity())
+	require.NoError(t, m.Validate())
+
+	// Once the entries alone pass 75% the map still grows
+	for i := 0; i < 400; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("fill%d", i))
+		require.NoError(t, m.putGrowing(key, testValue{ID: uint64(i)}))
+	}
+	assert.Equal(t, uint64(900), m.Len())
+	assert.Equal(t, capacity*2, m.Capacity())
+}
@@ -0,0 +1,301 @@
+package collections
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// String returns the key as 32 hexadecimal digits
+func (k FixedBlockKey) String() string {
+	return hex.EncodeToString(k[:])
+}
+
+// MarshalText implements encoding.TextMarshaler, encoding the key as 32
+// hexadecimal digits. This also lets keys be used as JSON object keys.
+func (k FixedBlockKey) MarshalText() ([]byte, error) {
+	text := make([]byte, hex.EncodedLen(len(k)))
+	hex.Encode(text, k[:])
+	return text, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, decoding a key written
+// by MarshalText
+func (k *FixedBlockKey) UnmarshalText(text []byte) error {
+	if len(text) != hex.EncodedLen(len(k)) {
+		return fmt.Errorf("invalid key %q: expected %d hexadecimal digits", text, hex.EncodedLen(len(k)))
+	}
+
+	var key FixedBlockKey
+	if _, err := hex.Decode(key[:], text); err != nil {
+		return fmt.Errorf("invalid key %q: %w", text, err)
+	}
+
+	*k = key
+	return nil
+}
+
+// MarshalJSON encodes the map as a JSON object mapping hex-encoded keys to the
+// JSON form of the values. The whole object is built in memory, so use
+// WriteJSONLines for large maps.
+func (m *FixedBlockMap[V]) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	first := true
+	for key, value := range m.Iter() {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+
+		buf.WriteByte('"')
+		buf.WriteString(key.String())
+		buf.WriteString(`":`)
+		buf.Write(encoded)
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON decodes an object written by MarshalJSON. The map is sized for
+// the entries in the object, so it can be the zero value, and is left
+// untouched on failure.
+func (m *FixedBlockMap[V]) UnmarshalJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token == nil {
+		// Like the built-in map, null leaves the map unchanged
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("invalid JSON map: expected an object, got %v", token)
+	}
+
+	decoded := NewFixedBlockMap[V](0)
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		var key FixedBlockKey
+		if err = key.UnmarshalText([]byte(token.(string))); err != nil {
+			return err
+		}
+
+		var value V
+		if err = decoder.Decode(&value); err != nil {
+			return err
+		}
+
+		if err = decoded.putGrowing(key, value); err != nil {
+			return err
+		}
+	}
+
+	m.touchAll()
+	m.blocks = decoded.blocks
+	m.mask = decoded.mask
+	m.recount()
+
+	return nil
+}
+
+// jsonLine is a single entry written by WriteJSONLines
+type jsonLine[V any] struct {
+	Key   FixedBlockKey `json:"key"`
+	Name  string        `json:"name,omitempty"`
+	Value *V            `json:"value"`
+}
+
+// jsonLineInput is a single entry read by ReadJSONLines, where either the key
+// or the name may be missing
+type jsonLineInput[V any] struct {
+	Key   *FixedBlockKey `json:"key"`
+	Name  *string        `json:"name"`
+	Value V              `json:"value"`
+}
+
+// WriteJSONLines writes every entry as a JSON object on its own line, with the
+// hex-encoded key under "key" and the JSON form of the value under "value".
+// When names is not nil, it is called for every key and the original string
+// it returns, if found, is written under "name". Entries are streamed to w one
+// at a time, so the map is never encoded in memory as a whole.
+func (m *FixedBlockMap[V]) WriteJSONLines(w io.Writer, names func(key FixedBlockKey) (string, bool)) (int64, error) {
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	encoder := json.NewEncoder(buffered)
+
+	for key, value := range m.Iter() {
+		line := jsonLine[V]{Key: key, Value: value}
+		if names != nil {
+			line.Name, _ = names(key)
+		}
+
+		if err := encoder.Encode(&line); err != nil {
+			return counter.n, err
+		}
+	}
+
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+// ReadJSONLines builds a new map from JSON objects such as those written by
+// WriteJSONLines. Each object needs a "value" and either a hex-encoded "key"
+// or a "name", which is turned into a key by FixedBlockKey.FromString; when
+// both are present the key is used. Lines are read one at a time, and the map
+// starts out sized for capacity entries and grows as needed.
+func ReadJSONLines[V any](r io.Reader, capacity uint64) (*FixedBlockMap[V], error) {
+	decoder := json.NewDecoder(r)
+	m := NewFixedBlockMap[V](capacity)
+
+	for line := 1; ; line++ {
+		var input jsonLineInput[V]
+		if err := decoder.Decode(&input); err != nil {
+			if errors.Is(err, io.EOF) {
+				return m, nil
+			}
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: %w", line, err)
+		}
+
+		var key FixedBlockKey
+		switch {
+		case input.Key != nil:
+			key = *input.Key
+		case input.Name != nil:
+			key.FromString(*input.Name)
+		default:
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: missing key and name", line)
+		}
+
+		if err := m.putGrowing(key, input.Value); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// csvHeader is the header row written by WriteCSV
+var csvHeader = []string{"key", "name", "value"}
+
+// WriteCSV writes every entry as a CSV row with the columns key, name and
+// value, preceded by a header row. The key is hex-encoded, the name is the
+// original string returned by names, if any, and the value is the JSON form
+// of the value. Rows are streamed to w one at a time.
+func (m *FixedBlockMap[V]) WriteCSV(w io.Writer, names func(key FixedBlockKey) (string, bool)) (int64, error) {
+	counter := &countingWriter{w: w}
+	writer := csv.NewWriter(counter)
+
+	if err := writer.Write(csvHeader); err != nil {
+		return counter.n, err
+	}
+
+	record := make([]string, len(csvHeader))
+	for key, value := range m.Iter() {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return counter.n, err
+		}
+
+		record[0] = key.String()
+		record[1] = ""
+		if names != nil {
+			record[1], _ = names(key)
+		}
+		record[2] = string(encoded)
+
+		if err = writer.Write(record); err != nil {
+			return counter.n, err
+		}
+	}
+
+	writer.Flush()
+	return counter.n, writer.Error()
+}
+
+// ReadCSV builds a new map from CSV rows such as those written by WriteCSV.
+// The first row names the columns: "value" is required, together with "key",
+// "name" or both, and other columns are ignored. Rows with an empty key use
+// the name, as in ReadJSONLines. Rows are read one at a time, and the map
+// starts out sized for capacity entries and grows as needed.
+func ReadCSV[V any](r io.Reader, capacity uint64) (*FixedBlockMap[V], error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: reading header: %w", err)
+	}
+
+	keyColumn, nameColumn, valueColumn := -1, -1, -1
+	for i, column := range header {
+		switch column {
+		case "key":
+			keyColumn = i
+		case "name":
+			nameColumn = i
+		case "value":
+			valueColumn = i
+		}
+	}
+
+	if valueColumn < 0 || (keyColumn < 0 && nameColumn < 0) {
+		return nil, errors.New("invalid CSV: header needs a value column and a key or name column")
+	}
+
+	m := NewFixedBlockMap[V](capacity)
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return m, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		field := func(column int) string {
+			if column < 0 || column >= len(record) {
+				return ""
+			}
+			return record[column]
+		}
+
+		var key FixedBlockKey
+		if text := field(keyColumn); text != "" {
+			if err = key.UnmarshalText([]byte(text)); err != nil {
+				return nil, fmt.Errorf("invalid CSV: line %d: %w", line, err)
+			}
+		} else if name := field(nameColumn); name != "" {
+			key.FromString(name)
+		} else {
+			return nil, fmt.Errorf("invalid CSV: line %d: missing key and name", line)
+		}
+
+		var value V
+		if err = json.Unmarshal([]byte(field(valueColumn)), &value); err != nil {
+			return nil, fmt.Errorf("invalid CSV: line %d: %w", line, err)
+		}
+
+		if err = m.putGrowing(key, value); err != nil {
+			return nil, err
+		}
+	}
+}
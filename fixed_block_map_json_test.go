@@ -0,0 +1,165 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testNames maps the keys created by newTestMap back to their strings
+func testNames(prefix string, count int) func(FixedBlockKey) (string, bool) {
+	names := make(map[FixedBlockKey]string, count)
+	for i := 0; i < count; i++ {
+		var key FixedBlockKey
+		name := fmt.Sprintf("%s%d", prefix, i)
+		key.FromString(name)
+		names[key] = name
+	}
+
+	return func(key FixedBlockKey) (string, bool) {
+		name, found := names[key]
+		return name, found
+	}
+}
+
+func TestFixedBlockKey_Text(t *testing.T) {
+	var key FixedBlockKey
+	key.FromString("hello")
+
+	text, err := key.MarshalText()
+	require.NoError(t, err)
+	assert.Len(t, text, 32)
+	assert.Equal(t, string(text), key.String())
+
+	var decoded FixedBlockKey
+	require.NoError(t, decoded.UnmarshalText(text))
+	assert.Equal(t, key, decoded)
+
+	assert.Error(t, decoded.UnmarshalText([]byte("abc")))
+	assert.Error(t, decoded.UnmarshalText(bytes.Repeat([]byte("z"), 32)))
+	assert.Equal(t, key, decoded)
+}
+
+func TestFixedBlockMap_MarshalJSON(t *testing.T) {
+	m, keys := newTestMap(t, 64, "json", 20)
+
+	data, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	// The output is a plain object keyed by hex keys
+	var generic map[string]testValue
+	require.NoError(t, json.Unmarshal(data, &generic))
+	assert.Len(t, generic, 20)
+	assert.Equal(t, uint64(3), generic[keys[3].String()].ID)
+
+	var decoded FixedBlockMap[testValue]
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, m.Len(), decoded.Len())
+	for key, value := range m.Iter() {
+		got, found := decoded.Get(key)
+		require.True(t, found)
+		assert.Equal(t, *value, *got)
+	}
+
+	empty, err := json.Marshal(NewFixedBlockMap[testValue](8))
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(empty))
+
+	// Invalid input leaves the map untouched
+	assert.Error(t, json.Unmarshal([]byte(`{"nothex": {}}`), &decoded))
+	assert.Error(t, json.Unmarshal([]byte(`[]`), &decoded))
+	assert.Equal(t, m.Len(), decoded.Len())
+}
+
+func TestFixedBlockMap_JSONLines(t *testing.T) {
+	m, keys := newTestMap(t, 64, "lines", 30)
+	names := testNames("lines", 30)
+
+	var buf bytes.Buffer
+	written, err := m.WriteJSONLines(&buf, names)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 30)
+	assert.Contains(t, lines[0], `"name":"lines`)
+
+	// A small initial capacity grows while reading
+	decoded, err := ReadJSONLines[testValue](&buf, 8)
+	require.NoError(t, err)
+	assert.True(t, m.Equal(decoded, testValueEqual))
+
+	value, found := decoded.Get(keys[12])
+	require.True(t, found)
+	assert.Equal(t, uint64(12), value.ID)
+}
+
+func TestReadJSONLines(t *testing.T) {
+	var alice, bob FixedBlockKey
+	alice.FromString("alice")
+	bob.FromString("bob")
+
+	// Hand-written lines may use names instead of keys
+	input := `{"name": "alice", "value": {"ID": 1}}
+{"key": "` + bob.String() + `", "value": {"ID": 2}}
+`
+	m, err := ReadJSONLines[testValue](strings.NewReader(input), 0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), m.Len())
+
+	value, found := m.Get(alice)
+	require.True(t, found)
+	assert.Equal(t, uint64(1), value.ID)
+
+	value, found = m.Get(bob)
+	require.True(t, found)
+	assert.Equal(t, uint64(2), value.ID)
+
+	_, err = ReadJSONLines[testValue](strings.NewReader(`{"value": {"ID": 1}}`), 0)
+	assert.ErrorContains(t, err, "entry 1")
+
+	_, err = ReadJSONLines[testValue](strings.NewReader(`{"name": "a", "value": {}} {"key": "x"}`), 0)
+	assert.ErrorContains(t, err, "entry 2")
+}
+
+func TestFixedBlockMap_CSV(t *testing.T) {
+	m, _ := newTestMap(t, 64, "csv", 25)
+
+	var buf bytes.Buffer
+	written, err := m.WriteCSV(&buf, testNames("csv", 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	assert.True(t, strings.HasPrefix(buf.String(), "key,name,value\n"))
+
+	decoded, err := ReadCSV[testValue](&buf, 8)
+	require.NoError(t, err)
+	assert.True(t, m.Equal(decoded, testValueEqual))
+}
+
+func TestReadCSV(t *testing.T) {
+	var alice FixedBlockKey
+	alice.FromString("alice")
+
+	// Columns can come in any order, and unknown columns are ignored
+	input := "comment,value,name\nfirst,\"{\"\"ID\"\": 7}\",alice\n"
+	m, err := ReadCSV[testValue](strings.NewReader(input), 0)
+	require.NoError(t, err)
+
+	value, found := m.Get(alice)
+	require.True(t, found)
+	assert.Equal(t, uint64(7), value.ID)
+
+	_, err = ReadCSV[testValue](strings.NewReader("key,name\n"), 0)
+	assert.Error(t, err)
+
+	_, err = ReadCSV[testValue](strings.NewReader("name,value\n,{}\n"), 0)
+	assert.ErrorContains(t, err, "line 2")
+
+	_, err = ReadCSV[testValue](strings.NewReader("name,value\nalice,notjson\n"), 0)
+	assert.ErrorContains(t, err, "line 2")
+}
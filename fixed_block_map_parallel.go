@@ -0,0 +1,120 @@
+package collections
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// parallelBlocks splits the blocks into contiguous ranges and runs fn on each
+// range concurrently. A worker count of zero or less uses GOMAXPROCS workers.
+// It returns once every range has been processed.
+func (m *FixedBlockMap[V]) parallelBlocks(workers int, fn func(lo, hi int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	blockCount := len(m.blocks)
+	workers = max(min(workers, blockCount), 1)
+
+	// Run a single range inline to avoid the goroutine overhead
+	if workers == 1 {
+		fn(0, blockCount)
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for worker := 0; worker < workers; worker++ {
+		lo := blockCount * worker / workers
+		hi := blockCount * (worker + 1) / workers
+
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// ParallelRange calls fn for every entry in the map, splitting the blocks into
+// contiguous ranges that are processed by workers goroutines at the same time.
+// A worker count of zero or less uses GOMAXPROCS workers. When fn returns
+// false, the workers stop as soon as possible and ParallelRange returns.
+// Entries are visited in no particular order.
+//
+// Since fn is called concurrently, it must be safe for concurrent use. Each
+// value pointer refers to a distinct slot, so fn may modify the value it is
+// given in place. Such edits are not tracked for WriteDelta, so pass the
+// modified keys to MarkDirty after ParallelRange returns. fn may call Get and
+// other read-only methods, but the map must not be modified (Put, Delete,
+// Rehash, Grow, ReadFrom, MarkDirty) until ParallelRange returns.
+func (m *FixedBlockMap[V]) ParallelRange(workers int, fn func(key FixedBlockKey, value *V) bool) {
+	var stop atomic.Bool
+
+	m.parallelBlocks(workers, func(lo, hi int) {
+		for blockIndex := lo; blockIndex < hi; blockIndex++ {
+			if stop.Load() {
+				return
+			}
+
+			block := &m.blocks[blockIndex]
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := block.controlByte(i)
+				if ctrl != 0x0 && ctrl != 0x1 {
+					if !fn(block.keys[i], &block.values[i]) {
+						stop.Store(true)
+						return
+					}
+				}
+			}
+		}
+	})
+}
+
+// ParallelCollectInfo is the same as CollectInfo, but counts the slots using
+// multiple goroutines. The map must not be modified while it runs.
+func (m *FixedBlockMap[V]) ParallelCollectInfo(workers int) FixedBlockMapInfo {
+	var storedEntities, tombstones atomic.Uint64
+
+	m.parallelBlocks(workers, func(lo, hi int) {
+		stored, deleted := m.countSlots(lo, hi)
+		storedEntities.Add(stored)
+		tombstones.Add(deleted)
+	})
+
+	return newFixedBlockMapInfo(storedEntities.Load(), tombstones.Load(), m.Capacity())
+}
+
+// ParallelClone is the same as Clone, but copies the blocks using multiple
+// goroutines. The map must not be modified while it runs.
+func (m *FixedBlockMap[V]) ParallelClone(workers int) *FixedBlockMap[V] {
+	blocks := make([]FixedBlock[V], len(m.blocks))
+
+	m.parallelBlocks(workers, func(lo, hi int) {
+		copy(blocks[lo:hi], m.blocks[lo:hi])
+	})
+
+	return &FixedBlockMap[V]{
+		blocks:     blocks,
+		mask:       m.mask,
+		count:      m.count,
+		tombstones: m.tombstones,
+	}
+}
+
+// ParallelRehash is the same as Rehash, but clears the tombstones using
+// multiple goroutines. Repositioning the entries afterwards is sequential,
+// since entries move between blocks. No other method may be called on the
+// map while it runs.
+func (m *FixedBlockMap[V]) ParallelRehash(workers int) error {
+	m.touchAll()
+
+	m.parallelBlocks(workers, func(lo, hi int) {
+		m.clearTombstones(lo, hi)
+	})
+
+	return m.repositionEntries()
+}
@@ -0,0 +1,95 @@
+package collections
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockMap_ParallelRange(t *testing.T) {
+	m, keys := newTestMap(t, 4096, "parallel", 3000)
+
+	for _, workers := range []int{0, 1, 3, 8, 10000} {
+		var mu sync.Mutex
+		seen := make(map[FixedBlockKey]int)
+
+		m.ParallelRange(workers, func(key FixedBlockKey, value *testValue) bool {
+			mu.Lock()
+			seen[key]++
+			mu.Unlock()
+			return true
+		})
+
+		require.Len(t, seen, 3000, "workers=%d", workers)
+		for _, key := range keys {
+			assert.Equal(t, 1, seen[key])
+		}
+	}
+}
+
+func TestFixedBlockMap_ParallelRangeModifyValues(t *testing.T) {
+	m, keys := newTestMap(t, 1024, "modify", 500)
+
+	m.ParallelRange(4, func(key FixedBlockKey, value *testValue) bool {
+		value.Score = -1
+		return true
+	})
+
+	for _, key := range keys {
+		val, found := m.Get(key)
+		require.True(t, found)
+		assert.Equal(t, int32(-1), val.Score)
+	}
+}
+
+func TestFixedBlockMap_ParallelRangeStop(t *testing.T) {
+	m, _ := newTestMap(t, 4096, "stop", 3000)
+
+	var calls atomic.Int64
+	m.ParallelRange(4, func(key FixedBlockKey, value *testValue) bool {
+		return calls.Add(1) < 10
+	})
+
+	// Each worker stops at the latest after its current block
+	assert.Less(t, calls.Load(), int64(3000))
+}
+
+func TestFixedBlockMap_ParallelCollectInfo(t *testing.T) {
+	m, keys := newTestMap(t, 2048, "info", 1500)
+	for i := 0; i < 500; i++ {
+		m.Delete(keys[i])
+	}
+
+	assert.Equal(t, m.CollectInfo(), m.ParallelCollectInfo(4))
+	assert.Equal(t, m.CollectInfo(), m.ParallelCollectInfo(0))
+}
+
+func TestFixedBlockMap_ParallelClone(t *testing.T) {
+	m, keys := newTestMap(t, 2048, "clone", 1500)
+	m.Delete(keys[0])
+
+	clone := m.ParallelClone(4)
+	assert.Equal(t, m.blocks, clone.blocks)
+	assert.Equal(t, m.Len(), clone.Len())
+	assert.True(t, m.Equal(clone, testValueEqual))
+
+	require.NoError(t, clone.Put(keys[1], testValue{ID: 1000}))
+	val, _ := m.Get(keys[1])
+	assert.Equal(t, uint64(1), val.ID)
+}
+
+func TestFixedBlockMap_ParallelRehash(t *testing.T) {
+	m, keys := newTestMap(t, 2048, "rehash", 1500)
+	for i := 0; i < 1500; i += 3 {
+		m.Delete(keys[i])
+	}
+	expected := m.Clone()
+
+	require.NoError(t, m.ParallelRehash(4))
+	assert.Equal(t, float32(0), m.CollectInfo().TombstoneFactor)
+	assert.Equal(t, uint64(1000), m.Len())
+	assert.True(t, m.Equal(expected, testValueEqual))
+}
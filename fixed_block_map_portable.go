@@ -0,0 +1,261 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// FixedBlockPortableValue can be implemented by a value type, on its pointer
+// receiver, to control how it is encoded by WritePortableTo and decoded by
+// ReadPortableFrom. Encodings should be independent of the machine they run
+// on, for example by writing every field in little-endian byte order.
+type FixedBlockPortableValue interface {
+	// PortableSize returns the number of bytes of the encoded value,
+	// which must be the same for every value of the type
+	PortableSize() int
+
+	// EncodePortable writes the value into dst, which is PortableSize bytes long
+	EncodePortable(dst []byte)
+
+	// DecodePortable reads the value from src, which is PortableSize bytes long
+	DecodePortable(src []byte) error
+}
+
+const (
+	portableVersion    = 1
+	portableHeaderSize = 24
+)
+
+var portableMagic = [4]byte{'F', 'B', 'M', 'P'}
+
+// maxPreallocatedBlocks caps the number of blocks allocated on the word of a
+// header, before the data backing them was read. Larger maps grow as their
+// blocks are read, so a corrupt header cannot request a huge allocation.
+const maxPreallocatedBlocks = 1 << 12
+
+// nativeLittleEndian is true when the machine stores integers in little-endian byte order
+var nativeLittleEndian = binary.NativeEndian.Uint16([]byte{0x01, 0x00}) == 0x0001
+
+// portableCodec encodes values of type V in a machine-independent format.
+// Types implementing FixedBlockPortableValue use their own encoding, other
+// fixed-size types are encoded field by field in little-endian byte order by
+// encoding/binary.
+type portableCodec[V any] struct {
+	size     int
+	custom   bool
+	copyable bool // the in-memory layout matches the encoding
+}
+
+func newPortableCodec[V any]() (portableCodec[V], error) {
+	var value V
+
+	if custom, ok := any(&value).(FixedBlockPortableValue); ok {
+		return portableCodec[V]{
+			size:   custom.PortableSize(),
+			custom: true,
+		}, nil
+	}
+
+	size := binary.Size(value)
+	if size < 0 {
+		return portableCodec[V]{}, fmt.Errorf("value type %T is not fixed-size and does not implement FixedBlockPortableValue", value)
+	}
+
+	// When the value has no padding and the machine is little-endian, the
+	// encoding is identical to the memory layout and can be copied directly
+	return portableCodec[V]{
+		size:     size,
+		copyable: nativeLittleEndian && uintptr(size) == unsafe.Sizeof(value),
+	}, nil
+}
+
+func (c *portableCodec[V]) encode(dst []byte, value *V) {
+	switch {
+	case c.custom:
+		any(value).(FixedBlockPortableValue).EncodePortable(dst)
+	case c.copyable:
+		copy(dst, unsafe.Slice((*byte)(unsafe.Pointer(value)), c.size))
+	default:
+		// The size was validated by newPortableCodec, so this cannot fail
+		binary.Encode(dst, binary.LittleEndian, value)
+	}
+}
+
+func (c *portableCodec[V]) decode(src []byte, value *V) error {
+	switch {
+	case c.custom:
+		return any(value).(FixedBlockPortableValue).DecodePortable(src)
+	case c.copyable:
+		copy(unsafe.Slice((*byte)(unsafe.Pointer(value)), c.size), src)
+		return nil
+	default:
+		_, err := binary.Decode(src, binary.LittleEndian, value)
+		return err
+	}
+}
+
+// blockSize returns the number of bytes of an encoded block
+func (c *portableCodec[V]) blockSize() int {
+	return 8 + FixedBlockSize*len(FixedBlockKey{}) + FixedBlockSize*c.size
+}
+
+// encodeBlock writes the control word, keys and values of a block into dst.
+// The values of empty and deleted slots are written as zero values, so the
+// encoding only depends on the entries in the block.
+func (c *portableCodec[V]) encodeBlock(dst []byte, block *FixedBlock[V]) {
+	var zero V
+
+	binary.LittleEndian.PutUint64(dst, block.control)
+	dst = dst[8:]
+
+	for i := 0; i < FixedBlockSize; i++ {
+		copy(dst, block.keys[i][:])
+		dst = dst[len(FixedBlockKey{}):]
+	}
+
+	for i := 0; i < FixedBlockSize; i++ {
+		ctrl := block.controlByte(i)
+		if ctrl != 0x0 && ctrl != 0x1 {
+			c.encode(dst, &block.values[i])
+		} else {
+			c.encode(dst, &zero)
+		}
+		dst = dst[c.size:]
+	}
+}
+
+// decodeBlock reads a block written by encodeBlock
+func (c *portableCodec[V]) decodeBlock(src []byte, block *FixedBlock[V]) error {
+	block.control = binary.LittleEndian.Uint64(src)
+	src = src[8:]
+
+	for i := 0; i < FixedBlockSize; i++ {
+		copy(block.keys[i][:], src)
+		src = src[len(FixedBlockKey{}):]
+	}
+
+	for i := 0; i < FixedBlockSize; i++ {
+		if err := c.decode(src[:c.size], &block.values[i]); err != nil {
+			return err
+		}
+		src = src[c.size:]
+	}
+
+	return nil
+}
+
+// WritePortableTo writes the map to an io.Writer in a format that does not
+// depend on the machine's byte order or on Go's memory layout of V, so it can
+// be read by ReadPortableFrom on any architecture. Every integer is written in
+// little-endian byte order and values are written field by field, either by
+// their FixedBlockPortableValue implementation or by encoding/binary, which
+// supports fixed-size types such as structs of numbers, bools and arrays.
+func (m *FixedBlockMap[V]) WritePortableTo(w io.Writer) (int64, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	var header [portableHeaderSize]byte
+	copy(header[0:4], portableMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], portableVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(m.blocks)))
+	binary.LittleEndian.PutUint32(header[16:20], uint32(codec.size))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	// Encode several blocks at a time to keep the number of writes low
+	blockSize := codec.blockSize()
+	blocksPerWrite := max(1, (64*1024)/blockSize)
+	buf := make([]byte, blocksPerWrite*blockSize)
+
+	for start := 0; start < len(m.blocks); start += blocksPerWrite {
+		end := min(start+blocksPerWrite, len(m.blocks))
+		chunk := buf[:(end-start)*blockSize]
+
+		for blockIndex := start; blockIndex < end; blockIndex++ {
+			offset := (blockIndex - start) * blockSize
+			codec.encodeBlock(chunk[offset:offset+blockSize], &m.blocks[blockIndex])
+		}
+
+		written, err = w.Write(chunk)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// ReadPortableFrom replaces the contents of the map with a map written by
+// WritePortableTo. The number of blocks is read from the data, so the map
+// does not need to be initialized with a matching capacity and can even be
+// the zero value.
+func (m *FixedBlockMap[V]) ReadPortableFrom(r io.Reader) (int64, error) {
+	codec, err := newPortableCodec[V]()
+	if err != nil {
+		return 0, err
+	}
+
+	var header [portableHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != portableMagic {
+		return total, errors.New("invalid portable map: bad magic")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != portableVersion {
+		return total, fmt.Errorf("invalid portable map: unsupported version %d", version)
+	}
+
+	// The zero value map has no blocks and is written with a block count of zero
+	blockCount := binary.LittleEndian.Uint64(header[8:16])
+	if blockCount&(blockCount-1) != 0 {
+		return total, fmt.Errorf("invalid portable map: block count %d is not a power of two", blockCount)
+	}
+
+	valueSize := binary.LittleEndian.Uint32(header[16:20])
+	if int(valueSize) != codec.size {
+		return total, fmt.Errorf("invalid portable map: value size %d does not match %d", valueSize, codec.size)
+	}
+
+	// Decode into new blocks, so the map is left untouched on failure
+	blocks := make([]FixedBlock[V], 0, min(blockCount, maxPreallocatedBlocks))
+	blockSize := codec.blockSize()
+	buf := make([]byte, blockSize)
+
+	for blockIndex := uint64(0); blockIndex < blockCount; blockIndex++ {
+		read, err = io.ReadFull(r, buf)
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+
+		blocks = append(blocks, FixedBlock[V]{})
+		if err = codec.decodeBlock(buf, &blocks[blockIndex]); err != nil {
+			return total, err
+		}
+	}
+
+	if blockCount == 0 {
+		blocks = nil
+	}
+
+	m.touchAll()
+	m.blocks = blocks
+	m.mask = max(blockCount, 1) - 1
+	m.recount()
+
+	return total, nil
+}
@@ -0,0 +1,301 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/bits"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// packedValue has no padding, so on little-endian machines its memory layout
+// matches the portable encoding
+type packedValue struct {
+	A uint32
+	B uint32
+}
+
+// customValue encodes itself in a compact form
+type customValue struct {
+	Small uint8
+	Large uint64
+}
+
+func (v *customValue) PortableSize() int {
+	return 9
+}
+
+func (v *customValue) EncodePortable(dst []byte) {
+	dst[0] = v.Small
+	binary.LittleEndian.PutUint64(dst[1:], v.Large)
+}
+
+func (v *customValue) DecodePortable(src []byte) error {
+	if src[0] == 0xFF {
+		return errors.New("invalid value")
+	}
+
+	v.Small = src[0]
+	v.Large = binary.LittleEndian.Uint64(src[1:])
+	return nil
+}
+
+// portableFixture is the portable encoding of a single-block map holding
+// portableFixtureKeys with portableFixtureValues. The bytes are spelled out
+// so the test does not depend on the byte order of the machine running it:
+// the same bytes must be produced and accepted on every architecture.
+var portableFixture = "" +
+	// header: magic, version, block count, value size, reserved
+	"46424d50" + "01000000" + "0100000000000000" + "12000000" + "00000000" +
+	// control word: slot 0 holds tag 0x81, slot 1 holds tag 0x82
+	"8182000000000000" +
+	// keys
+	"0100000000000000" + "1111111111111111" +
+	"0200000000000000" + "2222222222222222" +
+	"00000000000000000000000000000000" +
+	"00000000000000000000000000000000" +
+	"00000000000000000000000000000000" +
+	"00000000000000000000000000000000" +
+	"00000000000000000000000000000000" +
+	"00000000000000000000000000000000" +
+	// values: ID (uint64), Score (int32), Flags (uint16), Data ([4]byte)
+	"0807060504030201" + "feffffff" + "3412" + "aabbccdd" +
+	"0200000000000000" + "c8000000" + "0200" + "05060708" +
+	"000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000" +
+	"000000000000000000000000000000000000"
+
+var portableFixtureKeys = []FixedBlockKey{
+	{0x01, 0, 0, 0, 0, 0, 0, 0, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11, 0x11},
+	{0x02, 0, 0, 0, 0, 0, 0, 0, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22, 0x22},
+}
+
+var portableFixtureValues = []testValue{
+	{ID: 0x0102030405060708, Score: -2, Flags: 0x1234, Data: [4]byte{0xAA, 0xBB, 0xCC, 0xDD}},
+	{ID: 2, Score: 200, Flags: 2, Data: [4]byte{5, 6, 7, 8}},
+}
+
+func TestFixedBlockMap_WritePortableFixture(t *testing.T) {
+	m := NewFixedBlockMap[testValue](8)
+	for i, key := range portableFixtureKeys {
+		require.NoError(t, m.Put(key, portableFixtureValues[i]))
+	}
+
+	var buf bytes.Buffer
+	written, err := m.WritePortableTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	assert.Equal(t, portableFixture, hex.EncodeToString(buf.Bytes()))
+}
+
+func TestFixedBlockMap_ReadPortableFixture(t *testing.T) {
+	data, err := hex.DecodeString(portableFixture)
+	require.NoError(t, err)
+
+	// The zero value map is sized from the data
+	var m FixedBlockMap[testValue]
+	read, err := m.ReadPortableFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), read)
+	assert.Equal(t, uint64(2), m.Len())
+	assert.Equal(t, uint64(FixedBlockSize), m.Capacity())
+
+	for i, key := range portableFixtureKeys {
+		val, found := m.Get(key)
+		require.True(t, found)
+		assert.Equal(t, portableFixtureValues[i], *val)
+	}
+}
+
+func TestFixedBlockMap_ReadPortableByteSwapped(t *testing.T) {
+	// A writer on a big-endian machine dumping its memory would produce the
+	// fixture with every integer byte-swapped. Such data must be rejected
+	// rather than silently loaded.
+	data, err := hex.DecodeString(portableFixture)
+	require.NoError(t, err)
+
+	swapped := bytes.Clone(data)
+	binary.BigEndian.PutUint32(swapped[4:8], portableVersion)
+	binary.BigEndian.PutUint64(swapped[8:16], 1)
+
+	var m FixedBlockMap[testValue]
+	_, err = m.ReadPortableFrom(bytes.NewReader(swapped))
+	assert.Error(t, err)
+}
+
+func TestFixedBlockMap_ReadPortableBigEndianValues(t *testing.T) {
+	// The fixture with the integers of every value stored big-endian, as a
+	// big-endian machine would hold them in memory
+	data, err := hex.DecodeString(portableFixture)
+	require.NoError(t, err)
+
+	valuesOffset := portableHeaderSize + 8 + FixedBlockSize*len(FixedBlockKey{})
+	for i, value := range portableFixtureValues {
+		encoded := data[valuesOffset+i*18:]
+		binary.BigEndian.PutUint64(encoded[0:8], value.ID)
+		binary.BigEndian.PutUint32(encoded[8:12], uint32(value.Score))
+		binary.BigEndian.PutUint16(encoded[12:14], value.Flags)
+	}
+
+	// The values are decoded as little-endian on every machine, so they come
+	// out byte-swapped rather than in the host's interpretation
+	var m FixedBlockMap[testValue]
+	_, err = m.ReadPortableFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	for i, key := range portableFixtureKeys {
+		expected := portableFixtureValues[i]
+		expected.ID = bits.ReverseBytes64(expected.ID)
+		expected.Score = int32(bits.ReverseBytes32(uint32(expected.Score)))
+		expected.Flags = bits.ReverseBytes16(expected.Flags)
+
+		val, found := m.Get(key)
+		require.True(t, found)
+		assert.Equal(t, expected, *val)
+	}
+
+	first, _ := m.Get(portableFixtureKeys[0])
+	assert.Equal(t, uint64(0x0807060504030201), first.ID)
+	assert.Equal(t, int32(-16777217), first.Score)
+	assert.Equal(t, uint16(0x3412), first.Flags)
+}
+
+func TestFixedBlockMap_PortableRoundTrip(t *testing.T) {
+	m, keys := newTestMap(t, 256, "portable", 150)
+	for i := 0; i < 50; i++ {
+		m.Delete(keys[i])
+	}
+
+	var buf bytes.Buffer
+	written, err := m.WritePortableTo(&buf)
+	require.NoError(t, err)
+
+	// Reading replaces the contents of an existing map of a different size
+	other, _ := newTestMap(t, 16, "other", 5)
+	read, err := other.ReadPortableFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, m.Capacity(), other.Capacity())
+	assert.Equal(t, m.CollectInfo(), other.CollectInfo())
+	assert.True(t, m.Equal(other, testValueEqual))
+}
+
+func TestFixedBlockMap_PortablePackedValue(t *testing.T) {
+	m := NewFixedBlockMap[packedValue](8)
+	require.NoError(t, m.Put(portableFixtureKeys[0], packedValue{A: 0x01020304, B: 5}))
+
+	var buf bytes.Buffer
+	_, err := m.WritePortableTo(&buf)
+	require.NoError(t, err)
+
+	// The first value follows the header, control word and keys
+	offset := portableHeaderSize + 8 + FixedBlockSize*16
+	assert.Equal(t, "0403020105000000", hex.EncodeToString(buf.Bytes()[offset:offset+8]))
+
+	var other FixedBlockMap[packedValue]
+	_, err = other.ReadPortableFrom(&buf)
+	require.NoError(t, err)
+
+	val, found := other.Get(portableFixtureKeys[0])
+	require.True(t, found)
+	assert.Equal(t, packedValue{A: 0x01020304, B: 5}, *val)
+}
+
+func TestFixedBlockMap_PortableCustomValue(t *testing.T) {
+	m := NewFixedBlockMap[customValue](8)
+	require.NoError(t, m.Put(portableFixtureKeys[0], customValue{Small: 7, Large: 1 << 40}))
+
+	var buf bytes.Buffer
+	_, err := m.WritePortableTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, portableHeaderSize+8+FixedBlockSize*16+FixedBlockSize*9, buf.Len())
+
+	var other FixedBlockMap[customValue]
+	_, err = other.ReadPortableFrom(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	val, found := other.Get(portableFixtureKeys[0])
+	require.True(t, found)
+	assert.Equal(t, customValue{Small: 7, Large: 1 << 40}, *val)
+
+	// Decoding errors are returned
+	data := buf.Bytes()
+	data[portableHeaderSize+8+FixedBlockSize*16] = 0xFF
+	_, err = other.ReadPortableFrom(bytes.NewReader(data))
+	assert.Error(t, err)
+}
+
+func TestFixedBlockMap_PortableErrors(t *testing.T) {
+	data, err := hex.DecodeString(portableFixture)
+	require.NoError(t, err)
+
+	// Unsupported value types
+	var strings FixedBlockMap[string]
+	_, err = NewFixedBlockMap[string](8).WritePortableTo(&bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = strings.ReadPortableFrom(bytes.NewReader(data))
+	assert.Error(t, err)
+
+	// Mismatched value type
+	var packed FixedBlockMap[packedValue]
+	_, err = packed.ReadPortableFrom(bytes.NewReader(data))
+	assert.Error(t, err)
+
+	// Bad magic
+	bad := bytes.Clone(data)
+	bad[0] = 'X'
+	var m FixedBlockMap[testValue]
+	_, err = m.ReadPortableFrom(bytes.NewReader(bad))
+	assert.Error(t, err)
+
+	// Truncated data leaves the map untouched
+	existing, _ := newTestMap(t, 16, "existing", 5)
+	_, err = existing.ReadPortableFrom(bytes.NewReader(data[:len(data)-1]))
+	assert.Error(t, err)
+	assert.Equal(t, uint64(5), existing.Len())
+
+	// A corrupt block count fails on the missing data instead of allocating
+	// the announced blocks
+	huge := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(huge[8:16], 1<<40)
+	_, err = existing.ReadPortableFrom(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, uint64(5), existing.Len())
+
+	// The same holds for the raw format, whose header checksum can be
+	// computed over any block count
+	var raw bytes.Buffer
+	_, err = existing.WriteTo(&raw)
+	require.NoError(t, err)
+
+	var header rawHeader
+	require.NoError(t, header.decode(raw.Bytes()))
+	header.blockCount = 1 << 50
+	header.encode(raw.Bytes())
+	_, err = existing.ReadFrom(bytes.NewReader(raw.Bytes()))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, uint64(5), existing.Len())
+
+	// A block count whose size overflows is rejected with the header
+	header.blockCount = 1 << 62
+	header.encode(raw.Bytes())
+	_, err = existing.ReadFrom(bytes.NewReader(raw.Bytes()))
+	assert.ErrorContains(t, err, "do not fit in memory")
+	assert.Equal(t, uint64(5), existing.Len())
+}
+
+func TestFixedBlockMap_HashToBlockByteOrder(t *testing.T) {
+	m := NewFixedBlockMap[testValue](1024)
+
+	// The first key byte is the least significant byte of the block hash
+	key := FixedBlockKey{0x05, 0x01}
+	assert.Equal(t, uint64(0x0105)&m.mask, m.hashToBlock(key))
+}
@@ -0,0 +1,516 @@
+package collections
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math/bits"
+	"slices"
+)
+
+// rawBlockOffset is the offset of the values in the memory of a FixedBlock:
+// the control word followed by the keys
+const rawBlockOffset = 8 + FixedBlockSize*len(FixedBlockKey{})
+
+// RawFixedBlockMap is an untyped FixedBlockMap whose values are opaque byte
+// slices of a fixed size. It shares the memory layout of FixedBlockMap, so it
+// can read and write the data of WriteTo without knowing the value type, which
+// makes it useful for tools that inspect or convert maps of any type. The
+// values are the in-memory bytes of V, including any padding, in the byte
+// order of the machine that wrote them.
+type RawFixedBlockMap struct {
+	data       []byte
+	valueSize  int
+	blockSize  int
+	mask       uint64
+	count      uint64
+	tombstones uint64
+}
+
+// rawBlockSize returns the in-memory size of a FixedBlock holding values of valueSize bytes
+func rawBlockSize(valueSize int) int {
+	if valueSize == 0 {
+		// Go pads a zero-size final field, so that a pointer to it does not
+		// point past the struct, and rounds up to the alignment of the control word
+		return rawBlockOffset + 8
+	}
+
+	return rawBlockOffset + FixedBlockSize*valueSize
+}
+
+// NewRawFixedBlockMap creates an empty map for the given capacity, holding
+// values of valueSize bytes
+func NewRawFixedBlockMap(capacity uint64, valueSize int) *RawFixedBlockMap {
+	blockCount := calculateBlockCount(capacity)
+	blockSize := rawBlockSize(valueSize)
+
+	return &RawFixedBlockMap{
+		data:      make([]byte, int(blockCount)*blockSize),
+		valueSize: valueSize,
+		blockSize: blockSize,
+		mask:      blockCount - 1,
+	}
+}
+
+// ReadRawFixedBlockMap reads a map written by FixedBlockMap.WriteTo. The value
+// size is derived from the block size stored in the data when valueSize is
+// zero, and otherwise must match it. The value size is the in-memory size of
+// the value type, unsafe.Sizeof(V), which includes any padding. Maps of
+// zero-size values are derived to hold values of one byte, since Go pads
+// their blocks to the same size.
+func ReadRawFixedBlockMap(r io.Reader, valueSize int) (*RawFixedBlockMap, error) {
+	header, _, err := readRawHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	blockSize := int(header.blockSize)
+	if blockSize < rawBlockOffset || (blockSize-rawBlockOffset)%FixedBlockSize != 0 {
+		return nil, fmt.Errorf("invalid map data: block size %d does not hold %d keys and values", blockSize, FixedBlockSize)
+	}
+
+	if valueSize == 0 {
+		valueSize = (blockSize - rawBlockOffset) / FixedBlockSize
+	} else if rawBlockSize(valueSize) != blockSize {
+		return nil, fmt.Errorf("invalid map data: value size %d does not match block size %d, which holds values of %d bytes", valueSize, blockSize, (blockSize-rawBlockOffset)/FixedBlockSize)
+	}
+
+	m := &RawFixedBlockMap{
+		valueSize: valueSize,
+		blockSize: blockSize,
+		mask:      header.blockCount - 1,
+	}
+
+	// The data grows as it is read, like FixedBlockMap.ReadFrom
+	grow := func(n int) []byte {
+		m.data = slices.Grow(m.data, n)[:len(m.data)+n]
+		return m.data[len(m.data)-n:]
+	}
+	if _, err = readRawBlocks(r, &header, grow); err != nil {
+		return nil, err
+	}
+
+	m.count, m.tombstones = m.countSlots()
+
+	return m, nil
+}
+
+// ValueSize returns the size of the values in bytes
+func (m *RawFixedBlockMap) ValueSize() int {
+	return m.valueSize
+}
+
+// BlockSize returns the size of a block in bytes
+func (m *RawFixedBlockMap) BlockSize() int {
+	return m.blockSize
+}
+
+// BlockCount returns the number of blocks
+func (m *RawFixedBlockMap) BlockCount() uint64 {
+	return m.mask + 1
+}
+
+// Capacity returns the maximum capacity of the map
+func (m *RawFixedBlockMap) Capacity() uint64 {
+	return m.BlockCount() * FixedBlockSize
+}
+
+// Len returns the number of entries in the map
+func (m *RawFixedBlockMap) Len() uint64 {
+	return m.count
+}
+
+// control returns the control word of a block. Like the rest of the block,
+// it is stored in the machine's byte order.
+func (m *RawFixedBlockMap) control(blockIndex uint64) uint64 {
+	return binary.NativeEndian.Uint64(m.data[int(blockIndex)*m.blockSize:])
+}
+
+// setControlByte sets a single control byte of a block
+func (m *RawFixedBlockMap) setControlByte(blockIndex uint64, index int, value uint8) {
+	offset := int(blockIndex) * m.blockSize
+	shift := index * 8
+	control := (m.control(blockIndex) &^ (0xFF << shift)) | (uint64(value) << shift)
+	binary.NativeEndian.PutUint64(m.data[offset:], control)
+}
+
+// key returns the memory of the key in a slot
+func (m *RawFixedBlockMap) key(blockIndex uint64, index int) []byte {
+	offset := int(blockIndex)*m.blockSize + 8 + index*len(FixedBlockKey{})
+	return m.data[offset : offset+len(FixedBlockKey{})]
+}
+
+// value returns the memory of the value in a slot
+func (m *RawFixedBlockMap) value(blockIndex uint64, index int) []byte {
+	offset := int(blockIndex)*m.blockSize + rawBlockOffset + index*m.valueSize
+	return m.data[offset : offset+m.valueSize : offset+m.valueSize]
+}
+
+// find returns the block and slot holding key, or -1 when it is not stored.
+// Probing stops after visiting every block, since a full map, or one whose
+// free slots are all tombstones, has no empty slot to end the search.
+func (m *RawFixedBlockMap) find(key FixedBlockKey) (uint64, int) {
+	blockIndex := key.blockHash() & m.mask
+	tag := key[0] | 0x80
+
+	for probed := uint64(0); probed <= m.mask; probed++ {
+		control := m.control(blockIndex)
+
+		result := matchTag(control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if FixedBlockKey(m.key(blockIndex, index)) == key {
+				return blockIndex, index
+			}
+
+			result &= result - 1
+		}
+
+		if matchEmpty(control) != 0x0 {
+			return blockIndex, -1
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+	}
+
+	return blockIndex, -1
+}
+
+// Get returns the value stored for key. The returned slice aliases the map's
+// memory, so changes to it are visible in the map.
+func (m *RawFixedBlockMap) Get(key FixedBlockKey) ([]byte, bool) {
+	blockIndex, index := m.find(key)
+	if index < 0 {
+		return nil, false
+	}
+
+	return m.value(blockIndex, index), true
+}
+
+// Put inserts or updates a key. The value must be ValueSize bytes long.
+func (m *RawFixedBlockMap) Put(key FixedBlockKey, value []byte) error {
+	if len(value) != m.valueSize {
+		return fmt.Errorf("value of %d bytes does not match value size %d", len(value), m.valueSize)
+	}
+
+	home := key.blockHash() & m.mask
+	tag := key[0] | 0x80
+
+	deletedBlockIndex := uint64(0)
+	deletedIndex := -1
+
+	for blockIndex := home; ; {
+		control := m.control(blockIndex)
+
+		result := matchTag(control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if FixedBlockKey(m.key(blockIndex, index)) == key {
+				copy(m.value(blockIndex, index), value)
+				return nil
+			}
+
+			result &= result - 1
+		}
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := uint8(control >> (i * 8))
+			if ctrl == 0x0 {
+				// Prefer a tombstone found earlier to keep the chain short
+				if deletedIndex >= 0 {
+					blockIndex, i = deletedBlockIndex, deletedIndex
+					m.tombstones--
+				}
+
+				m.setControlByte(blockIndex, i, tag)
+				copy(m.key(blockIndex, i), key[:])
+				copy(m.value(blockIndex, i), value)
+				m.count++
+
+				return nil
+			}
+			if ctrl == 0x1 && deletedIndex < 0 {
+				deletedBlockIndex = blockIndex
+				deletedIndex = i
+			}
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+		if blockIndex == home {
+			return errors.New("map overflow: no empty slots available")
+		}
+	}
+}
+
+// Grow rebuilds the map with room for newCapacity entries, dropping tombstones
+func (m *RawFixedBlockMap) Grow(newCapacity uint64) error {
+	if newCapacity < m.count {
+		return fmt.Errorf("new capacity %d is smaller than the %d stored entries", newCapacity, m.count)
+	}
+
+	grown := NewRawFixedBlockMap(newCapacity, m.valueSize)
+	for key, value := range m.Iter() {
+		if err := grown.Put(key, value); err != nil {
+			return err
+		}
+	}
+
+	*m = *grown
+	return nil
+}
+
+// putGrowing inserts or updates a key, making room like
+// FixedBlockMap.putGrowing: the map is doubled when its entries reach 75% of
+// the slots, and rebuilt at the same capacity when tombstones make up the rest
+func (m *RawFixedBlockMap) putGrowing(key FixedBlockKey, value []byte) error {
+	if _, index := m.find(key); index < 0 && (m.count+m.tombstones+1)*4 >= m.Capacity()*3 {
+		capacity := m.Capacity()
+		if (m.count+1)*4 >= capacity*3 {
+			capacity *= 2
+		}
+
+		if err := m.Grow(capacity); err != nil {
+			return err
+		}
+	}
+
+	return m.Put(key, value)
+}
+
+// Iter returns an iterator over the keys and values of every entry. The
+// values alias the map's memory.
+func (m *RawFixedBlockMap) Iter() iter.Seq2[FixedBlockKey, []byte] {
+	return func(yield func(FixedBlockKey, []byte) bool) {
+		for blockIndex := uint64(0); blockIndex <= m.mask; blockIndex++ {
+			control := m.control(blockIndex)
+
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := uint8(control >> (i * 8))
+				if ctrl != 0x0 && ctrl != 0x1 {
+					if !yield(FixedBlockKey(m.key(blockIndex, i)), m.value(blockIndex, i)) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// countSlots counts the stored entities and tombstones in every block
+func (m *RawFixedBlockMap) countSlots() (storedEntities, tombstones uint64) {
+	for blockIndex := uint64(0); blockIndex <= m.mask; blockIndex++ {
+		control := m.control(blockIndex)
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := uint8(control >> (i * 8))
+			if ctrl == 0x1 {
+				tombstones++
+			} else if ctrl != 0x0 {
+				storedEntities++
+			}
+		}
+	}
+
+	return storedEntities, tombstones
+}
+
+// CollectInfo returns the same statistics as FixedBlockMap.CollectInfo
+func (m *RawFixedBlockMap) CollectInfo() FixedBlockMapInfo {
+	return newFixedBlockMapInfo(m.count, m.tombstones, m.Capacity())
+}
+
+// ProbeLengths returns a histogram of how far entries are stored from their
+// home block: element i is the number of entries found i blocks after the
+// block their key hashes to. Long tails mean slow lookups and are a sign
+// that the map should be grown or rehashed.
+func (m *RawFixedBlockMap) ProbeLengths() []uint64 {
+	var histogram []uint64
+
+	for blockIndex := uint64(0); blockIndex <= m.mask; blockIndex++ {
+		control := m.control(blockIndex)
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := uint8(control >> (i * 8))
+			if ctrl == 0x0 || ctrl == 0x1 {
+				continue
+			}
+
+			key := FixedBlockKey(m.key(blockIndex, i))
+			distance := (blockIndex - key.blockHash()) & m.mask
+			for uint64(len(histogram)) <= distance {
+				histogram = append(histogram, 0)
+			}
+			histogram[distance]++
+		}
+	}
+
+	return histogram
+}
+
+// Validate checks the same invariants as FixedBlockMap.Validate, except for
+// the counters, which are computed when the map is read
+func (m *RawFixedBlockMap) Validate() error {
+	for blockIndex := uint64(0); blockIndex <= m.mask; blockIndex++ {
+		control := m.control(blockIndex)
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := uint8(control >> (i * 8))
+			if ctrl == 0x0 || ctrl == 0x1 {
+				continue
+			}
+
+			key := FixedBlockKey(m.key(blockIndex, i))
+			if ctrl != key[0]|0x80 {
+				return fmt.Errorf("%w: block %d slot %d has tag %#x for key %x", ErrCorruptMap, blockIndex, i, ctrl, key)
+			}
+
+			foundBlockIndex, foundIndex := m.find(key)
+			if foundIndex < 0 {
+				return fmt.Errorf("%w: key %x in block %d slot %d is not reachable from block %d", ErrCorruptMap, key, blockIndex, i, key.blockHash()&m.mask)
+			}
+			if foundBlockIndex != blockIndex || foundIndex != i {
+				return fmt.Errorf("%w: key %x in block %d slot %d is stored more than once", ErrCorruptMap, key, blockIndex, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteTo writes the map in the format of FixedBlockMap.WriteTo, so it can be
+// read by FixedBlockMap.ReadFrom for a value type of ValueSize bytes
+func (m *RawFixedBlockMap) WriteTo(w io.Writer) (int64, error) {
+	return writeRaw(w, m.data, m.blockSize)
+}
+
+// WriteCompactTo writes the entries in the format of
+// FixedBlockMap.WriteCompactTo. The values are written as they are stored, so
+// the snapshot can only be read by ReadCompactFrom when the portable encoding
+// of the value type matches its memory layout, such as for types without
+// padding on little-endian machines.
+func (m *RawFixedBlockMap) WriteCompactTo(w io.Writer, compression FixedBlockCompression) (int64, error) {
+	writer, err := newCompactWriter(w, compression, m.count, m.valueSize)
+	if err != nil {
+		return writer.counter.n, err
+	}
+
+	entry := make([]byte, len(FixedBlockKey{})+m.valueSize)
+	for key, value := range m.Iter() {
+		copy(entry, key[:])
+		copy(entry[len(key):], value)
+
+		if err = writer.write(entry); err != nil {
+			return writer.counter.n, err
+		}
+	}
+
+	err = writer.close()
+	return writer.counter.n, err
+}
+
+// ReadRawCompactFrom builds a map from a snapshot written by WriteCompactTo,
+// taking the value size from the snapshot
+func ReadRawCompactFrom(r io.Reader, compressions ...FixedBlockCompression) (*RawFixedBlockMap, error) {
+	reader, err := newCompactReader(r, compressions)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.close()
+
+	m := NewRawFixedBlockMap(compactCapacity(0, reader.count), reader.valueSize)
+	entry := make([]byte, len(FixedBlockKey{})+reader.valueSize)
+
+	for i := uint64(0); i < reader.count; i++ {
+		if err = reader.read(entry); err != nil {
+			return nil, err
+		}
+
+		if err = m.putGrowing(FixedBlockKey(entry), entry[len(FixedBlockKey{}):]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = reader.finish(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// WriteJSONLines writes the entries like FixedBlockMap.WriteJSONLines, with
+// the values as hexadecimal strings
+func (m *RawFixedBlockMap) WriteJSONLines(w io.Writer, names func(key FixedBlockKey) (string, bool)) (int64, error) {
+	counter := &countingWriter{w: w}
+	buffered := bufio.NewWriter(counter)
+	encoder := json.NewEncoder(buffered)
+
+	for key, value := range m.Iter() {
+		text := hex.EncodeToString(value)
+		line := jsonLine[string]{Key: key, Value: &text}
+		if names != nil {
+			line.Name, _ = names(key)
+		}
+
+		if err := encoder.Encode(&line); err != nil {
+			return counter.n, err
+		}
+	}
+
+	err := buffered.Flush()
+	return counter.n, err
+}
+
+// ReadRawJSONLines builds a map from JSON lines written by
+// RawFixedBlockMap.WriteJSONLines, whose values are hexadecimal strings. The
+// value size is taken from the first entry when valueSize is zero.
+func ReadRawJSONLines(r io.Reader, valueSize int) (*RawFixedBlockMap, error) {
+	decoder := json.NewDecoder(r)
+	var m *RawFixedBlockMap
+
+	for line := 1; ; line++ {
+		var input jsonLineInput[string]
+		if err := decoder.Decode(&input); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: %w", line, err)
+		}
+
+		var key FixedBlockKey
+		switch {
+		case input.Key != nil:
+			key = *input.Key
+		case input.Name != nil:
+			key.FromString(*input.Name)
+		default:
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: missing key and name", line)
+		}
+
+		value, err := hex.DecodeString(input.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: %w", line, err)
+		}
+
+		if m == nil {
+			if valueSize == 0 {
+				valueSize = len(value)
+			}
+			m = NewRawFixedBlockMap(0, valueSize)
+		}
+
+		if err = m.putGrowing(key, value); err != nil {
+			return nil, fmt.Errorf("invalid JSON lines: entry %d: %w", line, err)
+		}
+	}
+
+	if m == nil {
+		m = NewRawFixedBlockMap(0, valueSize)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,232 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawFixedBlockMap_Layout(t *testing.T) {
+	assert.Equal(t, int(unsafe.Sizeof(FixedBlock[testValue]{})), rawBlockSize(int(unsafe.Sizeof(testValue{}))))
+	assert.Equal(t, int(unsafe.Sizeof(FixedBlock[uint8]{})), rawBlockSize(1))
+	assert.Equal(t, int(unsafe.Sizeof(FixedBlock[struct{}]{})), rawBlockSize(0))
+}
+
+func TestReadRawFixedBlockMap(t *testing.T) {
+	m, keys := newTestMap(t, 128, "raw", 60)
+	m.Delete(keys[5])
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	raw, err := ReadRawFixedBlockMap(bytes.NewReader(data), 0)
+	require.NoError(t, err)
+	assert.Equal(t, int(unsafe.Sizeof(testValue{})), raw.ValueSize())
+	assert.Equal(t, m.Capacity(), raw.Capacity())
+	assert.Equal(t, m.Len(), raw.Len())
+	assert.Equal(t, m.CollectInfo(), raw.CollectInfo())
+	require.NoError(t, raw.Validate())
+
+	// Values are the in-memory bytes of the typed values
+	value, found := raw.Get(keys[7])
+	require.True(t, found)
+	assert.Equal(t, uint64(7), binary.NativeEndian.Uint64(value[0:8]))
+
+	_, found = raw.Get(keys[5])
+	assert.False(t, found)
+
+	// Writing it back produces the same data
+	var rewritten bytes.Buffer
+	_, err = raw.WriteTo(&rewritten)
+	require.NoError(t, err)
+	assert.Equal(t, data, rewritten.Bytes())
+
+	// A value size that does not match the data is rejected
+	_, err = ReadRawFixedBlockMap(bytes.NewReader(data), 18)
+	assert.ErrorContains(t, err, "value size 18")
+
+	// A corrupt block count fails on the missing data instead of allocating
+	// the announced blocks, and one whose size overflows is rejected
+	var header rawHeader
+	require.NoError(t, header.decode(data))
+	header.blockCount = 1 << 50
+	header.encode(data)
+	_, err = ReadRawFixedBlockMap(bytes.NewReader(data), 0)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	header.blockCount = 1 << 62
+	header.encode(data)
+	_, err = ReadRawFixedBlockMap(bytes.NewReader(data), 0)
+	assert.ErrorContains(t, err, "do not fit in memory")
+}
+
+func TestRawFixedBlockMap_Put(t *testing.T) {
+	raw := NewRawFixedBlockMap(8, 4)
+	assert.Error(t, raw.Put(FixedBlockKey{}, []byte{1}))
+
+	var keys [100]FixedBlockKey
+	for i := range keys {
+		keys[i].FromString(string(rune('a' + i)))
+		require.NoError(t, raw.putGrowing(keys[i], binary.LittleEndian.AppendUint32(nil, uint32(i))))
+	}
+	assert.Equal(t, uint64(100), raw.Len())
+	require.NoError(t, raw.Validate())
+
+	// Updates replace the value in place
+	require.NoError(t, raw.Put(keys[3], []byte{9, 9, 9, 9}))
+	assert.Equal(t, uint64(100), raw.Len())
+
+	value, found := raw.Get(keys[3])
+	require.True(t, found)
+	assert.Equal(t, []byte{9, 9, 9, 9}, value)
+
+	value, found = raw.Get(keys[42])
+	require.True(t, found)
+	assert.Equal(t, uint32(42), binary.LittleEndian.Uint32(value))
+
+	// A raw map of uint32 values can be read as a typed map
+	var buf bytes.Buffer
+	_, err := raw.WriteTo(&buf)
+	require.NoError(t, err)
+
+	typed := NewFixedBlockMap[uint32](8)
+	_, err = typed.ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, raw.Len(), typed.Len())
+}
+
+func TestRawFixedBlockMap_PutGrowingTombstones(t *testing.T) {
+	// A map whose deleted slots push it past 75% is rebuilt at the same
+	// capacity, since its entries alone leave enough room
+	m, keys := newTestMap(t, 1024, "tombstones", 800)
+	for _, key := range keys[:500] {
+		m.Delete(key)
+	}
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	raw, err := ReadRawFixedBlockMap(&buf, 0)
+	require.NoError(t, err)
+	capacity := raw.Capacity()
+
+	var key FixedBlockKey
+	key.FromString("tombstones-new")
+	require.NoError(t, raw.putGrowing(key, make([]byte, raw.ValueSize())))
+	assert.Equal(t, capacity, raw.Capacity())
+	assert.Equal(t, uint64(301), raw.Len())
+	assert.Zero(t, raw.CollectInfo().TombstoneFactor)
+	require.NoError(t, raw.Validate())
+}
+
+func TestRawFixedBlockMap_GetWithoutEmptySlots(t *testing.T) {
+	var missing FixedBlockKey
+	missing.FromString("missing")
+
+	// Every slot is occupied
+	full := NewRawFixedBlockMap(16, 1)
+	for i := 0; i < 16; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("full%d", i))
+		require.NoError(t, full.Put(key, []byte{byte(i)}))
+	}
+	_, found := full.Get(missing)
+	assert.False(t, found)
+
+	// Every free slot is a tombstone
+	m, keys := newTestMap(t, 16, "churn", 16)
+	for _, key := range keys {
+		m.Delete(key)
+	}
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	raw, err := ReadRawFixedBlockMap(&buf, 0)
+	require.NoError(t, err)
+	_, found = raw.Get(keys[0])
+	assert.False(t, found)
+	require.NoError(t, raw.Validate())
+}
+
+func TestRawFixedBlockMap_ProbeLengths(t *testing.T) {
+	raw := NewRawFixedBlockMap(64, 1)
+
+	// Fill the home block of one key, so the next key with the same home
+	// block is stored one block further
+	var keys [FixedBlockSize + 1]FixedBlockKey
+	for i := range keys {
+		keys[i][15] = byte(i)
+		require.NoError(t, raw.Put(keys[i], []byte{byte(i)}))
+	}
+
+	assert.Equal(t, []uint64{FixedBlockSize, 1}, raw.ProbeLengths())
+	require.NoError(t, raw.Validate())
+}
+
+func TestRawFixedBlockMap_Compact(t *testing.T) {
+	m := NewFixedBlockMap[packedValue](64)
+	for i := 0; i < 30; i++ {
+		var key FixedBlockKey
+		key.FromString(string(rune('A' + i)))
+		require.NoError(t, m.Put(key, packedValue{A: uint32(i), B: uint32(i * 2)}))
+	}
+
+	var buf bytes.Buffer
+	_, err := m.WriteCompactTo(&buf, GzipCompression{})
+	require.NoError(t, err)
+
+	raw, err := ReadRawCompactFrom(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 8, raw.ValueSize())
+	assert.Equal(t, m.Len(), raw.Len())
+
+	// Types without padding round trip through the raw map
+	buf.Reset()
+	_, err = raw.WriteCompactTo(&buf, nil)
+	require.NoError(t, err)
+
+	loaded, err := ReadCompactFrom[packedValue](&buf, 0)
+	require.NoError(t, err)
+	assert.True(t, m.Equal(loaded, func(a, b *packedValue) bool { return *a == *b }))
+}
+
+func TestRawFixedBlockMap_JSONLines(t *testing.T) {
+	m, keys := newTestMap(t, 64, "rawjson", 20)
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	raw, err := ReadRawFixedBlockMap(&buf, 0)
+	require.NoError(t, err)
+
+	buf.Reset()
+	_, err = raw.WriteJSONLines(&buf, nil)
+	require.NoError(t, err)
+
+	loaded, err := ReadRawJSONLines(&buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, raw.ValueSize(), loaded.ValueSize())
+	assert.Equal(t, raw.Len(), loaded.Len())
+
+	want, _ := raw.Get(keys[11])
+	got, found := loaded.Get(keys[11])
+	require.True(t, found)
+	assert.Equal(t, want, got)
+
+	empty, err := ReadRawJSONLines(bytes.NewReader(nil), 4)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), empty.Len())
+	assert.Equal(t, 4, empty.ValueSize())
+}
@@ -0,0 +1,78 @@
+package collections
+
+import "math/bits"
+
+// FixedBlockEntry is a key together with a copy of its value
+type FixedBlockEntry[V any] struct {
+	Key   FixedBlockKey
+	Value V
+}
+
+// FixedBlockCursor marks the position of a scan started with Scan. The zero
+// cursor starts a new scan, and Scan returns the zero cursor once the scan is
+// complete. A cursor is a plain integer, so it can be persisted and used to
+// resume the scan later, even from another process holding the same map.
+type FixedBlockCursor uint64
+
+// Scan returns a batch of entries starting at cursor and the cursor to pass
+// to the next call. Scanning is complete when the returned cursor is zero.
+// The count is a hint for the batch size: whole home blocks are returned at a
+// time, so a batch may hold more or fewer entries than requested.
+//
+// The cursor encodes the home block (the block a key hashes to) that the scan
+// continues from, with its bits reversed. Incrementing the reversed cursor
+// means that when Grow doubles the number of blocks, every home block already
+// visited maps onto home blocks that are skipped, and every unvisited one onto
+// home blocks that are still ahead. As a result every entry that is present
+// for the whole scan is returned at least once, even when the map is modified
+// or grown between calls. Entries may be returned more than once when the map
+// grows during the scan, and entries added or removed during the scan may or
+// may not be returned.
+func (m *FixedBlockMap[V]) Scan(cursor FixedBlockCursor, count int) ([]FixedBlockEntry[V], FixedBlockCursor) {
+	entries := make([]FixedBlockEntry[V], 0, max(count, 0))
+	v := uint64(cursor)
+
+	for {
+		entries = m.appendHomeEntries(entries, v&m.mask)
+
+		// Increment the reversed cursor, ignoring the bits outside the mask
+		v |= ^m.mask
+		v = bits.Reverse64(bits.Reverse64(v) + 1)
+
+		if v == 0 || len(entries) >= count {
+			return entries, FixedBlockCursor(v)
+		}
+	}
+}
+
+// appendHomeEntries appends every entry whose home block is home. Those
+// entries can only be stored between the home block and the first block
+// holding an empty slot, which is the same probe chain Get follows.
+func (m *FixedBlockMap[V]) appendHomeEntries(entries []FixedBlockEntry[V], home uint64) []FixedBlockEntry[V] {
+	blockIndex := home
+
+	for {
+		block := &m.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl != 0x0 && ctrl != 0x1 && m.hashToBlock(block.keys[i]) == home {
+				entries = append(entries, FixedBlockEntry[V]{
+					Key:   block.keys[i],
+					Value: block.values[i],
+				})
+			}
+		}
+
+		if matchEmpty(block.control) != 0x0 {
+			return entries
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+
+		// Stop once the whole map has been probed
+		if blockIndex == home {
+			return entries
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scanAll runs a full scan, calling between after every batch
+func scanAll(m *FixedBlockMap[testValue], count int, between func(batch int)) map[FixedBlockKey]int {
+	seen := make(map[FixedBlockKey]int)
+	cursor := FixedBlockCursor(0)
+
+	for batch := 0; ; batch++ {
+		var entries []FixedBlockEntry[testValue]
+		entries, cursor = m.Scan(cursor, count)
+		for _, entry := range entries {
+			seen[entry.Key]++
+		}
+
+		if cursor == 0 {
+			return seen
+		}
+
+		if between != nil {
+			between(batch)
+		}
+	}
+}
+
+func TestFixedBlockMap_Scan(t *testing.T) {
+	m, keys := newTestMap(t, 512, "scan", 300)
+
+	seen := scanAll(m, 10, nil)
+	require.Len(t, seen, 300)
+	for _, key := range keys {
+		assert.Equal(t, 1, seen[key], "every entry is returned exactly once without changes")
+	}
+
+	// Values are copied into the batch
+	entries, _ := m.Scan(0, 1000)
+	for _, entry := range entries {
+		val, found := m.Get(entry.Key)
+		require.True(t, found)
+		assert.Equal(t, *val, entry.Value)
+	}
+
+	// Empty map
+	empty := NewFixedBlockMap[testValue](16)
+	entries, cursor := empty.Scan(0, 10)
+	assert.Empty(t, entries)
+	assert.Equal(t, FixedBlockCursor(0), cursor)
+}
+
+func TestFixedBlockMap_ScanBatchSize(t *testing.T) {
+	m, _ := newTestMap(t, 512, "batch", 200)
+
+	entries, cursor := m.Scan(0, 20)
+	assert.GreaterOrEqual(t, len(entries), 20)
+	assert.NotEqual(t, FixedBlockCursor(0), cursor)
+
+	// A zero count still makes progress
+	entries, cursor = m.Scan(0, 0)
+	assert.NotEqual(t, FixedBlockCursor(0), cursor)
+}
+
+func TestFixedBlockMap_ScanWithGrow(t *testing.T) {
+	m, keys := newTestMap(t, 64, "grow", 40)
+
+	// Grow twice in the middle of the scan
+	seen := scanAll(m, 4, func(batch int) {
+		if batch == 2 || batch == 5 {
+			require.NoError(t, m.Grow(m.Capacity()*2))
+		}
+	})
+
+	for _, key := range keys {
+		assert.GreaterOrEqual(t, seen[key], 1, "entry missed after grow")
+	}
+}
+
+func TestFixedBlockMap_ScanWithMutations(t *testing.T) {
+	m, keys := newTestMap(t, 256, "mutate", 100)
+
+	// Keys 0-49 are stable, keys 50-99 get deleted during the scan, and new
+	// keys get added
+	seen := scanAll(m, 5, func(batch int) {
+		if batch < 50 {
+			m.Delete(keys[50+batch])
+
+			var key FixedBlockKey
+			key.FromString(fmt.Sprintf("new%d", batch))
+			require.NoError(t, m.Put(key, testValue{}))
+		}
+	})
+
+	for i := 0; i < 50; i++ {
+		assert.GreaterOrEqual(t, seen[keys[i]], 1, "stable entry missed")
+	}
+}
+
+func TestFixedBlockMap_ScanResume(t *testing.T) {
+	m, keys := newTestMap(t, 256, "resume", 150)
+
+	// Scan part of the map and persist the cursor
+	seen := make(map[FixedBlockKey]bool)
+	entries, cursor := m.Scan(0, 30)
+	for _, entry := range entries {
+		seen[entry.Key] = true
+	}
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	// Resume the scan on a map loaded elsewhere
+	other := NewFixedBlockMap[testValue](256)
+	_, err = other.ReadFrom(&buf)
+	require.NoError(t, err)
+
+	for cursor != 0 {
+		entries, cursor = other.Scan(cursor, 30)
+		for _, entry := range entries {
+			seen[entry.Key] = true
+		}
+	}
+
+	for _, key := range keys {
+		assert.True(t, seen[key])
+	}
+}
@@ -0,0 +1,213 @@
+package collections
+
+import (
+	"iter"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// FixedBlockMapSnapshot is a frozen, read-only view of a FixedBlockMap taken
+// at a point in time. Creating a snapshot does not copy any entries. Instead
+// the map copies a block into the snapshot right before the block is first
+// modified (copy-on-write at block granularity), so the snapshot only pays
+// for the blocks that change while it is alive.
+//
+// A snapshot can be read from a different goroutine than the one mutating the
+// map. Snapshot itself must be called from the goroutine that mutates the map
+// (or under the same lock), while Release may be called from anywhere.
+// Snapshots should be released as soon as they are no longer needed, since
+// every live snapshot adds work to each write on the map.
+type FixedBlockMapSnapshot[V any] struct {
+	mu       sync.Mutex
+	source   *FixedBlockMap[V]
+	saved    []*FixedBlock[V] // blocks copied before the map modified them
+	detached bool             // set once every block has been saved
+	mask     uint64
+	count    uint64
+	released atomic.Bool
+}
+
+// Snapshot returns a consistent view of the map's current contents. The map
+// can keep being modified, including by Grow and Rehash, without affecting
+// what the snapshot observes. Values modified in place through the pointers
+// returned by Get or Iter bypass the copy-on-write and are visible to the
+// snapshot. The snapshot must be released with Release.
+func (m *FixedBlockMap[V]) Snapshot() *FixedBlockMapSnapshot[V] {
+	m.pruneSnapshots()
+
+	s := &FixedBlockMapSnapshot[V]{
+		source: m,
+		saved:  make([]*FixedBlock[V], len(m.blocks)),
+		mask:   m.mask,
+		count:  m.count,
+	}
+
+	m.snapshots = append(m.snapshots, s)
+
+	return s
+}
+
+// preserve gives every live snapshot a copy of the block before it is modified
+func (m *FixedBlockMap[V]) preserve(blockIndex uint64) {
+	if len(m.snapshots) == 0 {
+		return
+	}
+
+	m.pruneSnapshots()
+
+	for _, s := range m.snapshots {
+		s.save(blockIndex)
+	}
+}
+
+// preserveAll gives every live snapshot a copy of all blocks it has not saved
+// yet. Afterwards the snapshots no longer depend on the map, so they are
+// detached from it.
+func (m *FixedBlockMap[V]) preserveAll() {
+	for _, s := range m.snapshots {
+		s.saveAll()
+	}
+
+	clear(m.snapshots)
+	m.snapshots = m.snapshots[:0]
+}
+
+// pruneSnapshots drops snapshots that have been released
+func (m *FixedBlockMap[V]) pruneSnapshots() {
+	live := m.snapshots[:0]
+
+	for _, s := range m.snapshots {
+		if !s.released.Load() {
+			live = append(live, s)
+		}
+	}
+
+	clear(m.snapshots[len(live):])
+	m.snapshots = live
+}
+
+// save copies the block from the source map unless it was already saved
+func (s *FixedBlockMapSnapshot[V]) save(blockIndex uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.detached || s.released.Load() || s.saved[blockIndex] != nil {
+		return
+	}
+
+	block := s.source.blocks[blockIndex]
+	s.saved[blockIndex] = &block
+}
+
+// saveAll copies every block that has not been saved yet and detaches the
+// snapshot from the source map
+func (s *FixedBlockMapSnapshot[V]) saveAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.detached || s.released.Load() {
+		return
+	}
+
+	// Copy the unsaved blocks into one allocation
+	unsaved := 0
+	for _, block := range s.saved {
+		if block == nil {
+			unsaved++
+		}
+	}
+
+	copies := make([]FixedBlock[V], 0, unsaved)
+	for blockIndex, block := range s.saved {
+		if block == nil {
+			copies = append(copies, s.source.blocks[blockIndex])
+			s.saved[blockIndex] = &copies[len(copies)-1]
+		}
+	}
+
+	s.detached = true
+	s.source = nil
+}
+
+// block returns the snapshot's version of a block. Blocks that have not been
+// modified since the snapshot was taken are copied into buf.
+func (s *FixedBlockMapSnapshot[V]) block(blockIndex uint64, buf *FixedBlock[V]) *FixedBlock[V] {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved := s.saved[blockIndex]; saved != nil {
+		return saved
+	}
+
+	*buf = s.source.blocks[blockIndex]
+	return buf
+}
+
+// Len returns the number of entries in the snapshot
+func (s *FixedBlockMapSnapshot[V]) Len() uint64 {
+	return s.count
+}
+
+// Get searches the snapshot for a key and returns a copy of its value
+func (s *FixedBlockMapSnapshot[V]) Get(key FixedBlockKey) (V, bool) {
+	var buf FixedBlock[V]
+	var zero V
+
+	blockIndex := key.blockHash() & s.mask
+	tag := key[0] | 0x80
+
+	for probes := uint64(0); probes <= s.mask; probes++ {
+		block := s.block(blockIndex, &buf)
+
+		result := matchTag(block.control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if block.keys[index] == key {
+				return block.values[index], true
+			}
+
+			result &= result - 1
+		}
+
+		if matchEmpty(block.control) != 0x0 {
+			return zero, false
+		}
+
+		blockIndex = (blockIndex + 1) & s.mask
+	}
+
+	return zero, false
+}
+
+// Iter returns an iterator over copies of every entry in the snapshot. Unlike
+// FixedBlockMap.Iter, the map may be modified while the iteration is running.
+func (s *FixedBlockMapSnapshot[V]) Iter() iter.Seq2[FixedBlockKey, V] {
+	return func(yield func(FixedBlockKey, V) bool) {
+		var buf FixedBlock[V]
+
+		for blockIndex := range s.saved {
+			block := s.block(uint64(blockIndex), &buf)
+
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := block.controlByte(i)
+				if ctrl != 0x0 && ctrl != 0x1 {
+					if !yield(block.keys[i], block.values[i]) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Release frees the blocks held by the snapshot and stops the map from
+// copying blocks for it. The snapshot must not be used after it is released.
+func (s *FixedBlockMapSnapshot[V]) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released.Store(true)
+	s.saved = nil
+	s.source = nil
+}
@@ -0,0 +1,176 @@
+package collections
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockMap_Snapshot(t *testing.T) {
+	m, keys := newTestMap(t, 128, "snap", 50)
+
+	snap := m.Snapshot()
+	defer snap.Release()
+
+	// Mutate the map after taking the snapshot
+	require.NoError(t, m.Put(keys[0], testValue{ID: 1000}))
+	m.Delete(keys[1])
+
+	var added FixedBlockKey
+	added.FromString("added")
+	require.NoError(t, m.Put(added, testValue{ID: 2000}))
+
+	// The snapshot still sees the original contents
+	assert.Equal(t, uint64(50), snap.Len())
+
+	val, found := snap.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(0), val.ID)
+
+	val, found = snap.Get(keys[1])
+	require.True(t, found)
+	assert.Equal(t, uint64(1), val.ID)
+
+	_, found = snap.Get(added)
+	assert.False(t, found)
+
+	seen := make(map[FixedBlockKey]testValue)
+	for key, value := range snap.Iter() {
+		seen[key] = value
+	}
+	require.Len(t, seen, 50)
+	for i, key := range keys {
+		assert.Equal(t, uint64(i), seen[key].ID)
+	}
+
+	// The live map has the new contents
+	live, found := m.Get(keys[0])
+	require.True(t, found)
+	assert.Equal(t, uint64(1000), live.ID)
+}
+
+func TestFixedBlockMap_SnapshotMutateDuringIteration(t *testing.T) {
+	m, keys := newTestMap(t, 256, "iter", 100)
+
+	snap := m.Snapshot()
+	defer snap.Release()
+
+	// Every step of the iteration deletes, updates and inserts entries, and
+	// halfway through the map grows
+	count := 0
+	seen := make(map[FixedBlockKey]bool)
+	for key, value := range snap.Iter() {
+		assert.False(t, seen[key], "entry returned twice")
+		seen[key] = true
+
+		m.Delete(keys[count])
+		require.NoError(t, m.Put(keys[(count+1)%len(keys)], testValue{ID: 5000 + value.ID}))
+
+		var extra FixedBlockKey
+		extra.FromString(fmt.Sprintf("extra%d", count))
+		require.NoError(t, m.Put(extra, testValue{}))
+
+		if count == 50 {
+			require.NoError(t, m.Grow(1024))
+		}
+
+		count++
+	}
+
+	assert.Equal(t, 100, count)
+	for _, key := range keys {
+		assert.True(t, seen[key])
+	}
+}
+
+func TestFixedBlockMap_SnapshotRehash(t *testing.T) {
+	m, keys := newTestMap(t, 64, "rehash", 40)
+	for i := 0; i < 20; i++ {
+		m.Delete(keys[i])
+	}
+
+	snap := m.Snapshot()
+	defer snap.Release()
+
+	require.NoError(t, m.Rehash())
+	for i := 20; i < 40; i++ {
+		m.Delete(keys[i])
+	}
+
+	// Snapshots are detached by operations that touch every block
+	assert.Empty(t, m.snapshots)
+
+	assert.Equal(t, uint64(20), snap.Len())
+	for i := 20; i < 40; i++ {
+		val, found := snap.Get(keys[i])
+		require.True(t, found)
+		assert.Equal(t, uint64(i), val.ID)
+	}
+}
+
+func TestFixedBlockMap_SnapshotRelease(t *testing.T) {
+	m, keys := newTestMap(t, 64, "release", 10)
+
+	snap1 := m.Snapshot()
+	snap2 := m.Snapshot()
+	assert.Len(t, m.snapshots, 2)
+
+	// Only modified blocks are copied
+	require.NoError(t, m.Put(keys[0], testValue{ID: 100}))
+	saved := 0
+	for _, block := range snap1.saved {
+		if block != nil {
+			saved++
+		}
+	}
+	assert.Equal(t, 1, saved)
+
+	// Released snapshots are dropped on the next write
+	snap1.Release()
+	m.Delete(keys[1])
+	assert.Len(t, m.snapshots, 1)
+
+	snap2.Release()
+	m.Delete(keys[2])
+	assert.Empty(t, m.snapshots)
+
+	// Releasing twice is harmless
+	snap2.Release()
+}
+
+func TestFixedBlockMap_SnapshotConcurrentWriter(t *testing.T) {
+	m, keys := newTestMap(t, 2048, "concurrent", 1000)
+
+	snap := m.Snapshot()
+	defer snap.Release()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for i, key := range keys {
+			if i%2 == 0 {
+				m.Delete(key)
+			} else {
+				m.Put(key, testValue{ID: 99999})
+			}
+		}
+		m.Grow(8192)
+	}()
+
+	count := 0
+	for key, value := range snap.Iter() {
+		expected, found := snap.Get(key)
+		require.True(t, found)
+		assert.Equal(t, expected, value)
+		assert.NotEqual(t, uint64(99999), value.ID)
+		count++
+	}
+	assert.Equal(t, 1000, count)
+
+	wg.Wait()
+}
@@ -118,6 +118,35 @@ func TestFixedBlockMap_GetNonExistent(t *testing.T) {
 	assert.Nil(t, val2)
 }
 
+func TestFixedBlockMap_GetWithoutEmptySlots(t *testing.T) {
+	var missing FixedBlockKey
+	missing.FromString("missing")
+
+	// Every slot is occupied
+	full, _ := newTestMap(t, 16, "full", 16)
+	_, found := full.Get(missing)
+	assert.False(t, found)
+	full.Delete(missing)
+	assert.Equal(t, uint64(16), full.Len())
+
+	// Every free slot is a tombstone
+	churned, keys := newTestMap(t, 16, "churn", 16)
+	for _, key := range keys {
+		churned.Delete(key)
+	}
+	_, found = churned.Get(keys[0])
+	assert.False(t, found)
+
+	assert.False(t, full.Equal(churned, testValueEqual))
+	assert.True(t, churned.Equal(NewFixedBlockMap[testValue](16), testValueEqual))
+
+	// The zero value holds nothing
+	var zero FixedBlockMap[testValue]
+	_, found = zero.Get(missing)
+	assert.False(t, found)
+	zero.Delete(missing)
+}
+
 func TestFixedBlockMap_Delete(t *testing.T) {
 	m := NewFixedBlockMap[testValue](10)
 
@@ -244,6 +273,39 @@ func TestFixedBlockMap_MultipleOperations(t *testing.T) {
 	assert.Equal(t, updated0, *val)
 }
 
+func TestFixedBlockMap_Len(t *testing.T) {
+	m := NewFixedBlockMap[testValue](10)
+	assert.Equal(t, uint64(0), m.Len())
+
+	var key1, key2 FixedBlockKey
+	key1.FromString("key1")
+	key2.FromString("key2")
+
+	require.NoError(t, m.Put(key1, testValue{ID: 1}))
+	require.NoError(t, m.Put(key2, testValue{ID: 2}))
+	assert.Equal(t, uint64(2), m.Len())
+
+	// Updates do not change the length
+	require.NoError(t, m.Put(key1, testValue{ID: 3}))
+	assert.Equal(t, uint64(2), m.Len())
+
+	// Deleting twice only counts once
+	m.Delete(key1)
+	m.Delete(key1)
+	assert.Equal(t, uint64(1), m.Len())
+
+	// Reinserting into a tombstone
+	require.NoError(t, m.Put(key1, testValue{ID: 4}))
+	assert.Equal(t, uint64(2), m.Len())
+
+	// Rehash and Grow keep the count
+	m.Delete(key2)
+	require.NoError(t, m.Rehash())
+	assert.Equal(t, uint64(1), m.Len())
+	require.NoError(t, m.Grow(100))
+	assert.Equal(t, uint64(1), m.Len())
+}
+
 func TestFixedBlockMap_WriteToAndReadFrom(t *testing.T) {
 	m1 := NewFixedBlockMap[testValue](10)
 
@@ -274,6 +336,7 @@ func TestFixedBlockMap_WriteToAndReadFrom(t *testing.T) {
 	read, err := m2.ReadFrom(&buf)
 	require.NoError(t, err)
 	assert.Equal(t, written, read)
+	assert.Equal(t, uint64(3), m2.Len())
 
 	// Verify all values are preserved
 	val1, found1 := m2.Get(key1)
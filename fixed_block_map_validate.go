@@ -0,0 +1,64 @@
+package collections
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCorruptMap is returned by Validate when the map breaks one of its invariants
+var ErrCorruptMap = errors.New("corrupt map")
+
+// Validate checks the invariants of the map, similar to fsck for a file
+// system, and returns an error wrapping ErrCorruptMap describing the first
+// violation it finds. It checks that:
+//
+//   - the number of blocks is a power of two matching the mask
+//   - every control byte is empty, deleted or a valid tag
+//   - every occupied slot's tag matches its key
+//   - every key is reachable from its home block without crossing a block
+//     with an empty slot, so Get can find it
+//   - no key is stored more than once
+//   - the entry and tombstone counters agree with a scan of the slots
+//
+// Validate reads every slot and follows the probe chain of every key, so it
+// is meant for checking maps after loading them or in tests rather than for
+// regular use.
+func (m *FixedBlockMap[V]) Validate() error {
+	blockCount := uint64(len(m.blocks))
+	if blockCount == 0 || blockCount&(blockCount-1) != 0 || m.mask != blockCount-1 {
+		return fmt.Errorf("%w: %d blocks with mask %#x", ErrCorruptMap, blockCount, m.mask)
+	}
+
+	for blockIndex := range m.blocks {
+		block := &m.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl == 0x0 || ctrl == 0x1 {
+				continue
+			}
+
+			key := block.keys[i]
+			if ctrl != key[0]|0x80 {
+				return fmt.Errorf("%w: block %d slot %d has tag %#x for key %x", ErrCorruptMap, blockIndex, i, ctrl, key)
+			}
+
+			// Get returns the first slot holding the key along its probe chain,
+			// which must be this slot
+			value, found := m.Get(key)
+			if !found {
+				return fmt.Errorf("%w: key %x in block %d slot %d is not reachable from block %d", ErrCorruptMap, key, blockIndex, i, m.hashToBlock(key))
+			}
+			if value != &block.values[i] {
+				return fmt.Errorf("%w: key %x in block %d slot %d is stored more than once", ErrCorruptMap, key, blockIndex, i)
+			}
+		}
+	}
+
+	count, tombstones := m.countSlots(0, len(m.blocks))
+	if count != m.count || tombstones != m.tombstones {
+		return fmt.Errorf("%w: counted %d entries and %d tombstones, expected %d and %d", ErrCorruptMap, count, tombstones, m.count, m.tombstones)
+	}
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package collections
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockMap_Validate(t *testing.T) {
+	m, keys := newTestMap(t, 64, "validate", 45)
+	require.NoError(t, m.Validate())
+
+	for i := 0; i < 45; i += 2 {
+		m.Delete(keys[i])
+	}
+	require.NoError(t, m.Validate())
+
+	require.NoError(t, m.Rehash())
+	require.NoError(t, m.Validate())
+
+	require.NoError(t, m.Grow(512))
+	require.NoError(t, m.Validate())
+
+	require.NoError(t, NewFixedBlockMap[testValue](8).Validate())
+}
+
+// findSlot returns the block and slot holding key
+func findSlot(t *testing.T, m *FixedBlockMap[testValue], key FixedBlockKey) (*FixedBlock[testValue], int) {
+	for blockIndex := range m.blocks {
+		block := &m.blocks[blockIndex]
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl != 0x0 && ctrl != 0x1 && block.keys[i] == key {
+				return block, i
+			}
+		}
+	}
+
+	require.FailNow(t, "key not found")
+	return nil, 0
+}
+
+func TestFixedBlockMap_ValidateCorruption(t *testing.T) {
+	t.Run("tag", func(t *testing.T) {
+		m, keys := newTestMap(t, 64, "tag", 20)
+		block, slot := findSlot(t, m, keys[3])
+		block.setControlByte(slot, block.controlByte(slot)^0x01)
+
+		assert.ErrorIs(t, m.Validate(), ErrCorruptMap)
+	})
+
+	t.Run("invalid control byte", func(t *testing.T) {
+		m, keys := newTestMap(t, 64, "control", 20)
+		block, slot := findSlot(t, m, keys[3])
+		block.setControlByte(slot, 0x42)
+
+		assert.ErrorIs(t, m.Validate(), ErrCorruptMap)
+	})
+
+	t.Run("garbage key", func(t *testing.T) {
+		m, keys := newTestMap(t, 64, "garbage", 20)
+		block, slot := findSlot(t, m, keys[3])
+		block.keys[slot][0] ^= 0x04
+
+		assert.ErrorIs(t, m.Validate(), ErrCorruptMap)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		// Fill a single home block so the last key overflows into the next one
+		m := NewFixedBlockMap[testValue](64)
+		var keys []FixedBlockKey
+		for i := 0; i < FixedBlockSize+1; i++ {
+			key := FixedBlockKey{0x03, byte(i)}
+			keys = append(keys, key)
+			require.NoError(t, m.Put(key, testValue{ID: uint64(i)}))
+		}
+		require.NoError(t, m.Validate())
+
+		// Emptying a slot in the home block without leaving a tombstone cuts
+		// off the overflowed key
+		block, slot := findSlot(t, m, keys[0])
+		block.setControlByte(slot, 0x0)
+		m.count--
+
+		err := m.Validate()
+		assert.ErrorIs(t, err, ErrCorruptMap)
+		assert.ErrorContains(t, err, "not reachable")
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		m, keys := newTestMap(t, 64, "duplicate", 20)
+		block, slot := findSlot(t, m, keys[3])
+		require.NoError(t, m.Put(keys[4], testValue{}))
+
+		// Overwrite another slot with a copy of keys[3]
+		other, otherSlot := findSlot(t, m, keys[4])
+		other.keys[otherSlot] = block.keys[slot]
+		other.setControlByte(otherSlot, block.controlByte(slot))
+
+		assert.ErrorIs(t, m.Validate(), ErrCorruptMap)
+	})
+
+	t.Run("counters", func(t *testing.T) {
+		m, _ := newTestMap(t, 64, "counters", 20)
+		m.count++
+
+		err := m.Validate()
+		assert.ErrorIs(t, err, ErrCorruptMap)
+		assert.ErrorContains(t, err, "counted")
+	})
+}
+
+func TestFixedBlockMap_ReadFromChecksum(t *testing.T) {
+	m, _ := newTestMap(t, 4096, "checksum", 1000)
+
+	var buf bytes.Buffer
+	written, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	data := buf.Bytes()
+
+	t.Run("intact", func(t *testing.T) {
+		var loaded FixedBlockMap[testValue]
+		read, err := loaded.ReadFrom(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, written, read)
+		assert.NoError(t, loaded.Validate())
+		assert.True(t, m.Equal(&loaded, testValueEqual))
+	})
+
+	t.Run("resizes", func(t *testing.T) {
+		loaded := NewFixedBlockMap[testValue](8)
+		_, err := loaded.ReadFrom(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, m.Capacity(), loaded.Capacity())
+		assert.True(t, m.Equal(loaded, testValueEqual))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for _, size := range []int{10, rawHeaderSize, rawHeaderSize + 100, len(data) - 2} {
+			existing, _ := newTestMap(t, 16, "existing", 5)
+			_, err := existing.ReadFrom(bytes.NewReader(data[:size]))
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "size %d", size)
+
+			// The map is left untouched
+			assert.Equal(t, uint64(5), existing.Len())
+			assert.NoError(t, existing.Validate())
+		}
+	})
+
+	t.Run("corrupted chunk", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[len(corrupted)/2] ^= 0x10
+
+		var loaded FixedBlockMap[testValue]
+		_, err := loaded.ReadFrom(bytes.NewReader(corrupted))
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("corrupted header", func(t *testing.T) {
+		corrupted := bytes.Clone(data)
+		corrupted[8] ^= 0x01
+
+		var loaded FixedBlockMap[testValue]
+		_, err := loaded.ReadFrom(bytes.NewReader(corrupted))
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+
+	t.Run("value type", func(t *testing.T) {
+		var loaded FixedBlockMap[packedValue]
+		_, err := loaded.ReadFrom(bytes.NewReader(data))
+		assert.ErrorContains(t, err, "block size")
+	})
+}
@@ -0,0 +1,379 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"slices"
+	"unsafe"
+)
+
+// multiMapHead is the value stored in the index for every key
+type multiMapHead struct {
+	first uint64 // index+1 of the first node of the chain
+	last  uint64 // index+1 of the last node of the chain
+	count uint64 // number of values of the key
+}
+
+// multiMapNode holds a single value. Nodes are linked by indices rather than
+// pointers, so the node array can be written as raw memory.
+type multiMapNode[V any] struct {
+	value V
+	next  uint64 // index+1 of the next node of the chain or free list, 0 at the end
+}
+
+// FixedBlockMultiMap maps every key to a list of values, kept in the order
+// they were added. The index is a FixedBlockMap holding the first and last
+// node of every key's chain, and the values live in a side array of nodes
+// linked by indices, so the whole structure is free of pointers and can be
+// serialized with WriteTo like a FixedBlockMap.
+//
+// Removed nodes are reused by later additions. Rehash compacts the side array
+// so that every key's values are stored contiguously.
+type FixedBlockMultiMap[V comparable] struct {
+	index *FixedBlockMap[multiMapHead]
+	nodes []multiMapNode[V]
+	free  uint64 // index+1 of the first node of the free list
+	count uint64 // number of values in all chains
+}
+
+// NewFixedBlockMultiMap initializes the multimap to support the given number
+// of keys. The index grows when it becomes too full, and the side array grows
+// with the number of values.
+func NewFixedBlockMultiMap[V comparable](capacity uint64) *FixedBlockMultiMap[V] {
+	return &FixedBlockMultiMap[V]{
+		index: NewFixedBlockMap[multiMapHead](capacity),
+	}
+}
+
+// Len returns the number of keys
+func (mm *FixedBlockMultiMap[V]) Len() uint64 {
+	return mm.index.Len()
+}
+
+// ValueCount returns the number of values of all keys
+func (mm *FixedBlockMultiMap[V]) ValueCount() uint64 {
+	return mm.count
+}
+
+// Count returns the number of values of key
+func (mm *FixedBlockMultiMap[V]) Count(key FixedBlockKey) uint64 {
+	head, found := mm.index.Get(key)
+	if !found {
+		return 0
+	}
+
+	return head.count
+}
+
+// Add appends a value to the values of key
+func (mm *FixedBlockMultiMap[V]) Add(key FixedBlockKey, value V) error {
+	head, found := mm.index.Get(key)
+	if !found {
+		if err := mm.index.putGrowing(key, multiMapHead{}); err != nil {
+			return err
+		}
+		head, _ = mm.index.Get(key)
+	}
+
+	// Reuse a removed node when there is one
+	var node uint64
+	if mm.free != 0 {
+		node = mm.free
+		mm.free = mm.nodes[node-1].next
+		mm.nodes[node-1] = multiMapNode[V]{value: value}
+	} else {
+		mm.nodes = append(mm.nodes, multiMapNode[V]{value: value})
+		node = uint64(len(mm.nodes))
+	}
+
+	if head.last != 0 {
+		mm.nodes[head.last-1].next = node
+	} else {
+		head.first = node
+	}
+	head.last = node
+	head.count++
+	mm.count++
+
+	return nil
+}
+
+// GetAll returns an iterator over the values of key, in the order they were
+// added. The multimap must not be modified during iteration.
+func (mm *FixedBlockMultiMap[V]) GetAll(key FixedBlockKey) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		head, found := mm.index.Get(key)
+		if !found {
+			return
+		}
+
+		for node := head.first; node != 0; node = mm.nodes[node-1].next {
+			if !yield(mm.nodes[node-1].value) {
+				return
+			}
+		}
+	}
+}
+
+// RemoveValue removes the first occurrence of value from the values of key
+// and reports whether it was found. The key is removed with its last value.
+func (mm *FixedBlockMultiMap[V]) RemoveValue(key FixedBlockKey, value V) bool {
+	head, found := mm.index.Get(key)
+	if !found {
+		return false
+	}
+
+	var prev uint64
+	for node := head.first; node != 0; prev, node = node, mm.nodes[node-1].next {
+		if mm.nodes[node-1].value != value {
+			continue
+		}
+
+		next := mm.nodes[node-1].next
+		if prev != 0 {
+			mm.nodes[prev-1].next = next
+		} else {
+			head.first = next
+		}
+		if head.last == node {
+			head.last = prev
+		}
+
+		mm.release(node)
+		mm.count--
+
+		if head.count--; head.count == 0 {
+			mm.index.Delete(key)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Delete removes key with all of its values and returns how many there were
+func (mm *FixedBlockMultiMap[V]) Delete(key FixedBlockKey) uint64 {
+	head, found := mm.index.Get(key)
+	if !found {
+		return 0
+	}
+
+	count := head.count
+	for node := head.first; node != 0; {
+		next := mm.nodes[node-1].next
+		mm.release(node)
+		node = next
+	}
+
+	mm.count -= count
+	mm.index.Delete(key)
+
+	return count
+}
+
+// release pushes a node onto the free list
+func (mm *FixedBlockMultiMap[V]) release(node uint64) {
+	mm.nodes[node-1] = multiMapNode[V]{next: mm.free}
+	mm.free = node
+}
+
+// Iter returns an iterator over every key and value. A key with several
+// values is yielded once for each of them.
+func (mm *FixedBlockMultiMap[V]) Iter() iter.Seq2[FixedBlockKey, V] {
+	return func(yield func(FixedBlockKey, V) bool) {
+		for key, head := range mm.index.Iter() {
+			for node := head.first; node != 0; node = mm.nodes[node-1].next {
+				if !yield(key, mm.nodes[node-1].value) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// CollectInfo returns statistics about the index, like FixedBlockMap.CollectInfo
+func (mm *FixedBlockMultiMap[V]) CollectInfo() FixedBlockMapInfo {
+	return mm.index.CollectInfo()
+}
+
+// Rehash removes the deleted slots of the index, like FixedBlockMap.Rehash,
+// and compacts the side array: removed nodes are dropped and the values of
+// every key are moved next to each other, which speeds up GetAll.
+func (mm *FixedBlockMultiMap[V]) Rehash() error {
+	nodes := make([]multiMapNode[V], 0, mm.count)
+
+	for _, head := range mm.index.Iter() {
+		first := uint64(len(nodes)) + 1
+		for node := head.first; node != 0; node = mm.nodes[node-1].next {
+			nodes = append(nodes, multiMapNode[V]{value: mm.nodes[node-1].value, next: uint64(len(nodes)) + 2})
+		}
+
+		nodes[len(nodes)-1].next = 0
+		head.first = first
+		head.last = uint64(len(nodes))
+	}
+
+	mm.nodes = nodes
+	mm.free = 0
+
+	return mm.index.Rehash()
+}
+
+const (
+	multiMapVersion    = 1
+	multiMapHeaderSize = 32
+
+	// multiMapReadNodes is the number of nodes ReadFrom reads at a time, so
+	// the side array only grows with the data actually read and a corrupt
+	// node count cannot request a huge allocation
+	multiMapReadNodes = 1 << 12
+)
+
+var multiMapMagic = [4]byte{'F', 'B', 'M', 'M'}
+
+// nodeBytes maps the memory of the nodes directly to a []byte
+func nodeBytes[V any](nodes []multiMapNode[V]) []byte {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&nodes[0])), int(unsafe.Sizeof(nodes[0]))*len(nodes))
+}
+
+// WriteTo writes the multimap as raw memory: a header, the side array
+// followed by its CRC32C checksum, and the index in the format of
+// FixedBlockMap.WriteTo. Like FixedBlockMap.ReadFrom, ReadFrom requires the
+// same value type and byte order.
+func (mm *FixedBlockMultiMap[V]) WriteTo(w io.Writer) (int64, error) {
+	data := nodeBytes(mm.nodes)
+
+	var header [multiMapHeaderSize]byte
+	copy(header[0:4], multiMapMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], multiMapVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(mm.nodes)))
+	binary.LittleEndian.PutUint64(header[16:24], mm.free)
+	binary.LittleEndian.PutUint32(header[24:28], uint32(unsafe.Sizeof(multiMapNode[V]{})))
+	binary.LittleEndian.PutUint32(header[28:32], crc32.Checksum(data, castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	written, err = w.Write(data)
+	total += int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	indexWritten, err := mm.index.WriteTo(w)
+	return total + indexWritten, err
+}
+
+// ReadFrom replaces the multimap with data written by WriteTo. The chains are
+// checked to stay within the side array, and the multimap is left untouched
+// when an error is returned.
+func (mm *FixedBlockMultiMap[V]) ReadFrom(r io.Reader) (int64, error) {
+	var header [multiMapHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != multiMapMagic {
+		return total, errors.New("invalid multimap data: bad magic")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != multiMapVersion {
+		return total, fmt.Errorf("invalid multimap data: unsupported version %d", version)
+	}
+
+	nodeSize := int(unsafe.Sizeof(multiMapNode[V]{}))
+	if size := binary.LittleEndian.Uint32(header[24:28]); int(size) != nodeSize {
+		return total, fmt.Errorf("invalid multimap data: node size %d does not match %d", size, nodeSize)
+	}
+
+	nodeCount := binary.LittleEndian.Uint64(header[8:16])
+	nodes := make([]multiMapNode[V], 0, min(nodeCount, multiMapReadNodes))
+	var checksum uint32
+
+	for uint64(len(nodes)) < nodeCount {
+		n := int(min(nodeCount-uint64(len(nodes)), multiMapReadNodes))
+		nodes = slices.Grow(nodes, n)[:len(nodes)+n]
+		data := nodeBytes(nodes[len(nodes)-n:])
+
+		read, err = io.ReadFull(r, data)
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+		checksum = crc32.Update(checksum, castagnoliTable, data)
+	}
+	if checksum != binary.LittleEndian.Uint32(header[28:32]) {
+		return total, fmt.Errorf("invalid multimap data: nodes %w", ErrChecksumMismatch)
+	}
+
+	var index FixedBlockMap[multiMapHead]
+	indexRead, err := index.ReadFrom(r)
+	total += indexRead
+	if err != nil {
+		return total, err
+	}
+
+	free := binary.LittleEndian.Uint64(header[16:24])
+	count, err := checkChains(nodes, &index, free)
+	if err != nil {
+		return total, err
+	}
+
+	mm.index = &index
+	mm.nodes = nodes
+	mm.free = free
+	mm.count = count
+
+	return total, nil
+}
+
+// checkChains verifies that every chain and the free list stay within nodes
+// and visit every node exactly once, and returns the number of values
+func checkChains[V any](nodes []multiMapNode[V], index *FixedBlockMap[multiMapHead], free uint64) (uint64, error) {
+	visited := make([]bool, len(nodes))
+	walk := func(node uint64) (uint64, uint64, error) {
+		var last, count uint64
+		for ; node != 0; node = nodes[node-1].next {
+			if node > uint64(len(nodes)) || visited[node-1] {
+				return 0, 0, fmt.Errorf("%w: node %d is out of range or linked twice", ErrCorruptMap, node)
+			}
+			visited[node-1] = true
+			last = node
+			count++
+		}
+		return last, count, nil
+	}
+
+	var total uint64
+	for key, head := range index.Iter() {
+		last, count, err := walk(head.first)
+		if err != nil {
+			return 0, err
+		}
+		if last != head.last || count != head.count || count == 0 {
+			return 0, fmt.Errorf("%w: chain of key %x does not match its head", ErrCorruptMap, key)
+		}
+		total += count
+	}
+
+	if _, freeCount, err := walk(free); err != nil {
+		return 0, err
+	} else if total+freeCount != uint64(len(nodes)) {
+		return 0, fmt.Errorf("%w: %d nodes are not linked", ErrCorruptMap, uint64(len(nodes))-total-freeCount)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,180 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type session struct {
+	ID      uint64
+	Created int64
+}
+
+func TestFixedBlockMultiMap_AddAndGetAll(t *testing.T) {
+	mm := NewFixedBlockMultiMap[session](16)
+
+	var alice, bob FixedBlockKey
+	alice.FromString("alice")
+	bob.FromString("bob")
+
+	for i := uint64(1); i <= 3; i++ {
+		require.NoError(t, mm.Add(alice, session{ID: i}))
+	}
+	require.NoError(t, mm.Add(bob, session{ID: 10}))
+
+	assert.Equal(t, uint64(2), mm.Len())
+	assert.Equal(t, uint64(4), mm.ValueCount())
+	assert.Equal(t, uint64(3), mm.Count(alice))
+	assert.Equal(t, uint64(0), mm.Count(FixedBlockKey{}))
+
+	// Values come back in the order they were added
+	assert.Equal(t, []session{{ID: 1}, {ID: 2}, {ID: 3}}, slices.Collect(mm.GetAll(alice)))
+	assert.Equal(t, []session{{ID: 10}}, slices.Collect(mm.GetAll(bob)))
+	assert.Empty(t, slices.Collect(mm.GetAll(FixedBlockKey{})))
+
+	var pairs int
+	for range mm.Iter() {
+		pairs++
+	}
+	assert.Equal(t, 4, pairs)
+}
+
+func TestFixedBlockMultiMap_RemoveValue(t *testing.T) {
+	mm := NewFixedBlockMultiMap[session](16)
+
+	var key FixedBlockKey
+	key.FromString("user")
+	for i := uint64(1); i <= 4; i++ {
+		require.NoError(t, mm.Add(key, session{ID: i}))
+	}
+
+	// Middle, last and first values
+	assert.True(t, mm.RemoveValue(key, session{ID: 2}))
+	assert.True(t, mm.RemoveValue(key, session{ID: 4}))
+	assert.False(t, mm.RemoveValue(key, session{ID: 4}))
+	assert.Equal(t, []session{{ID: 1}, {ID: 3}}, slices.Collect(mm.GetAll(key)))
+
+	// Appending after removing the last value links to the new last node
+	require.NoError(t, mm.Add(key, session{ID: 5}))
+	assert.True(t, mm.RemoveValue(key, session{ID: 1}))
+	assert.Equal(t, []session{{ID: 3}, {ID: 5}}, slices.Collect(mm.GetAll(key)))
+
+	// The removed nodes were reused instead of growing the side array
+	assert.Len(t, mm.nodes, 4)
+
+	// The key disappears with its last value
+	assert.True(t, mm.RemoveValue(key, session{ID: 3}))
+	assert.True(t, mm.RemoveValue(key, session{ID: 5}))
+	assert.Equal(t, uint64(0), mm.Len())
+	assert.Equal(t, uint64(0), mm.ValueCount())
+	assert.False(t, mm.RemoveValue(key, session{ID: 5}))
+}
+
+func TestFixedBlockMultiMap_Delete(t *testing.T) {
+	mm := NewFixedBlockMultiMap[session](16)
+
+	var key FixedBlockKey
+	key.FromString("user")
+	for i := uint64(1); i <= 3; i++ {
+		require.NoError(t, mm.Add(key, session{ID: i}))
+	}
+
+	assert.Equal(t, uint64(3), mm.Delete(key))
+	assert.Equal(t, uint64(0), mm.Delete(key))
+	assert.Equal(t, uint64(0), mm.ValueCount())
+
+	// All three nodes are reused
+	for i := uint64(1); i <= 3; i++ {
+		require.NoError(t, mm.Add(key, session{ID: i}))
+	}
+	assert.Len(t, mm.nodes, 3)
+}
+
+// newTestMultiMap adds count values spread over keys "multi<i % keys>"
+func newTestMultiMap(t *testing.T, keys, count int) *FixedBlockMultiMap[session] {
+	mm := NewFixedBlockMultiMap[session](8)
+	for i := 0; i < count; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("multi%d", i%keys))
+		require.NoError(t, mm.Add(key, session{ID: uint64(i)}))
+	}
+
+	return mm
+}
+
+func TestFixedBlockMultiMap_Rehash(t *testing.T) {
+	mm := newTestMultiMap(t, 50, 500)
+
+	// Remove every other value, leaving holes in the side array
+	for i := 0; i < 500; i += 2 {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("multi%d", i%50))
+		require.True(t, mm.RemoveValue(key, session{ID: uint64(i)}))
+	}
+
+	before := make(map[FixedBlockKey][]session)
+	for key, value := range mm.Iter() {
+		before[key] = append(before[key], value)
+	}
+
+	require.NoError(t, mm.Rehash())
+	assert.Len(t, mm.nodes, 250)
+	assert.Equal(t, uint64(0), mm.free)
+
+	// Every key's values are contiguous and in the same order
+	for key, values := range before {
+		head, found := mm.index.Get(key)
+		require.True(t, found)
+		assert.Equal(t, head.first+uint64(len(values))-1, head.last)
+		assert.Equal(t, values, slices.Collect(mm.GetAll(key)))
+	}
+
+	_, err := checkChains(mm.nodes, mm.index, mm.free)
+	require.NoError(t, err)
+}
+
+func TestFixedBlockMultiMap_WriteTo(t *testing.T) {
+	mm := newTestMultiMap(t, 20, 100)
+
+	var key FixedBlockKey
+	key.FromString("multi3")
+	require.True(t, mm.RemoveValue(key, session{ID: 23}))
+
+	var buf bytes.Buffer
+	written, err := mm.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	var loaded FixedBlockMultiMap[session]
+	read, err := loaded.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, mm.Len(), loaded.Len())
+	assert.Equal(t, mm.ValueCount(), loaded.ValueCount())
+	assert.Equal(t, slices.Collect(mm.GetAll(key)), slices.Collect(loaded.GetAll(key)))
+
+	// The free list survives, so the removed node is reused
+	require.NoError(t, loaded.Add(key, session{ID: 1000}))
+	assert.Len(t, loaded.nodes, 100)
+
+	// Corrupt nodes are detected
+	corrupted := bytes.Clone(data)
+	corrupted[multiMapHeaderSize+3] ^= 0xFF
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	// A corrupt node count fails on the missing nodes instead of allocating
+	// them up front
+	huge := bytes.Clone(data)
+	binary.LittleEndian.PutUint64(huge[8:16], 1<<40)
+	_, err = loaded.ReadFrom(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, mm.Len(), loaded.Len())
+}
@@ -0,0 +1,282 @@
+package collections
+
+import (
+	"errors"
+	"iter"
+	"math/bits"
+)
+
+// robinHoodMaxStoredDistance is the largest probe distance stored in a slot.
+// Longer distances are stored as this value and computed from the key when
+// the exact value is needed. It keeps every byte below 0x80, which matchLess
+// requires.
+const robinHoodMaxStoredDistance = 127
+
+// robinHoodBlock is a FixedBlock with the probe distance of every slot, the
+// number of blocks between its home block and the block it is stored in,
+// packed into a second control word
+type robinHoodBlock[V any] struct {
+	control   uint64 // tags like FixedBlock, 0x0 for empty slots; there are no tombstones
+	distances uint64 // probe distance of every occupied slot, 0 for empty ones
+	keys      [FixedBlockSize]FixedBlockKey
+	values    [FixedBlockSize]V
+}
+
+// controlByte returns the control byte of slot i
+func (b *robinHoodBlock[V]) controlByte(i int) uint8 {
+	return uint8(b.control >> (i * 8))
+}
+
+// distance returns the stored probe distance of slot i
+func (b *robinHoodBlock[V]) distance(i int) uint8 {
+	return uint8(b.distances >> (i * 8))
+}
+
+// set stores an entry in slot i
+func (b *robinHoodBlock[V]) set(i int, tag uint8, distance uint64, key FixedBlockKey, value V) {
+	shift := i * 8
+	b.control = b.control&^(0xFF<<shift) | uint64(tag)<<shift
+	b.distances = b.distances&^(0xFF<<shift) | min(distance, robinHoodMaxStoredDistance)<<shift
+	b.keys[i] = key
+	b.values[i] = value
+}
+
+// matchLess returns a non-zero mask when a byte of x is less than n. Every
+// byte of x must be below 0x80 and n must be at most 0x80.
+func matchLess(x uint64, n uint8) uint64 {
+	return (x - uint64(n)*0x0101010101010101) & ^x & 0x8080808080808080
+}
+
+// FixedBlockRobinHoodMap is a variant of FixedBlockMap that places entries
+// with Robin Hood displacement at block granularity. Inserting into a full
+// block takes the slot of an entry that is closer to its home block than the
+// new entry is to its own, and moves that entry further along instead. This
+// evens out probe distances, so at high load factors the longest probes are
+// much shorter than with plain linear probing.
+//
+// Because every block on the probe path of a stored key only holds entries at
+// least as far from home as the key is at that point, a lookup stops as soon
+// as it meets a block holding a closer entry, instead of probing until a
+// block with an empty slot. Deletion shifts later entries back towards their
+// home blocks rather than leaving tombstones, so the map never needs Rehash.
+type FixedBlockRobinHoodMap[V any] struct {
+	blocks []robinHoodBlock[V]
+	mask   uint64
+	count  uint64
+}
+
+// NewFixedBlockRobinHoodMap initializes the map to support the given capacity
+func NewFixedBlockRobinHoodMap[V any](capacity uint64) *FixedBlockRobinHoodMap[V] {
+	blockCount := calculateBlockCount(capacity)
+
+	return &FixedBlockRobinHoodMap[V]{
+		blocks: make([]robinHoodBlock[V], blockCount),
+		mask:   blockCount - 1,
+	}
+}
+
+// Len returns the number of entries in the map
+func (m *FixedBlockRobinHoodMap[V]) Len() uint64 {
+	return m.count
+}
+
+// Capacity returns the maximum capacity of the map
+func (m *FixedBlockRobinHoodMap[V]) Capacity() uint64 {
+	return uint64(len(m.blocks)) * FixedBlockSize
+}
+
+// find returns the block and slot holding key, or -1 as the slot
+func (m *FixedBlockRobinHoodMap[V]) find(key FixedBlockKey) (uint64, int) {
+	blockIndex := key.blockHash() & m.mask
+	tag := key[0] | 0x80
+
+	for distance := uint64(0); distance <= m.mask; distance++ {
+		block := &m.blocks[blockIndex]
+
+		result := matchTag(block.control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if block.keys[index] == key {
+				return blockIndex, index
+			}
+
+			result &= result - 1
+		}
+
+		// A block with an empty slot or an entry closer to its home block
+		// than the key would be here ends the search
+		if matchEmpty(block.control) != 0 ||
+			matchLess(block.distances, uint8(min(distance, robinHoodMaxStoredDistance))) != 0 {
+			break
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+	}
+
+	return 0, -1
+}
+
+// Get searches for a key
+func (m *FixedBlockRobinHoodMap[V]) Get(key FixedBlockKey) (*V, bool) {
+	blockIndex, index := m.find(key)
+	if index < 0 {
+		return nil, false
+	}
+
+	return &m.blocks[blockIndex].values[index], true
+}
+
+// Put inserts or updates a key
+func (m *FixedBlockRobinHoodMap[V]) Put(key FixedBlockKey, value V) error {
+	if blockIndex, index := m.find(key); index >= 0 {
+		m.blocks[blockIndex].values[index] = value
+		return nil
+	}
+
+	if m.count == m.Capacity() {
+		return errors.New("map overflow: no empty slots available")
+	}
+
+	m.insert(key, value)
+	m.count++
+	return nil
+}
+
+// insert places a key that is not in the map, which must have an empty slot
+func (m *FixedBlockRobinHoodMap[V]) insert(key FixedBlockKey, value V) {
+	blockIndex := key.blockHash() & m.mask
+	tag := key[0] | 0x80
+	distance := uint64(0)
+
+	for {
+		block := &m.blocks[blockIndex]
+
+		if empty := matchEmpty(block.control); empty != 0 {
+			block.set(bits.TrailingZeros64(empty)/8, tag, distance, key, value)
+			return
+		}
+
+		// Take the slot of the entry closest to its home block if it is
+		// closer than the entry being placed, and carry that entry on
+		richest, richestDistance := -1, min(distance, robinHoodMaxStoredDistance)
+		for i := 0; i < FixedBlockSize; i++ {
+			if d := uint64(block.distance(i)); d < richestDistance {
+				richest, richestDistance = i, d
+			}
+		}
+
+		if richest >= 0 {
+			evictedKey, evictedValue := block.keys[richest], block.values[richest]
+			block.set(richest, tag, distance, key, value)
+
+			key, value, tag = evictedKey, evictedValue, evictedKey[0]|0x80
+			distance = richestDistance
+		}
+
+		blockIndex = (blockIndex + 1) & m.mask
+		distance++
+	}
+}
+
+// Delete removes a key. Entries of the following blocks that are away from
+// their home block are shifted back to fill the gap.
+func (m *FixedBlockRobinHoodMap[V]) Delete(key FixedBlockKey) {
+	blockIndex, index := m.find(key)
+	if index < 0 {
+		return
+	}
+
+	m.count--
+
+	for {
+		nextIndex := (blockIndex + 1) & m.mask
+		next := &m.blocks[nextIndex]
+
+		// Pull back the entry of the next block that is furthest from home
+		pulled, pulledDistance := -1, uint8(0)
+		for i := 0; i < FixedBlockSize; i++ {
+			if next.controlByte(i) != 0x0 && next.distance(i) > pulledDistance {
+				pulled, pulledDistance = i, next.distance(i)
+			}
+		}
+
+		block := &m.blocks[blockIndex]
+		if pulled < 0 || nextIndex == blockIndex {
+			var zero V
+			block.set(index, 0x0, 0, FixedBlockKey{}, zero)
+			return
+		}
+
+		// The stored distance may be capped, so compute it from the key
+		pulledKey := next.keys[pulled]
+		distance := (blockIndex - pulledKey.blockHash()) & m.mask
+		block.set(index, pulledKey[0]|0x80, distance, pulledKey, next.values[pulled])
+
+		blockIndex, index = nextIndex, pulled
+	}
+}
+
+// Iter returns an iterator over the keys and pointers to the values of every
+// entry in the map
+func (m *FixedBlockRobinHoodMap[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
+	return func(yield func(FixedBlockKey, *V) bool) {
+		for blockIndex := range m.blocks {
+			block := &m.blocks[blockIndex]
+
+			for i := 0; i < FixedBlockSize; i++ {
+				if block.controlByte(i) != 0x0 {
+					if !yield(block.keys[i], &block.values[i]) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Grow extends the capacity of the map and places every entry again. Like
+// FixedBlockMap.Grow, it does nothing if the map already has enough blocks.
+func (m *FixedBlockRobinHoodMap[V]) Grow(newCapacity uint64) error {
+	if calculateBlockCount(newCapacity) <= uint64(len(m.blocks)) {
+		return nil
+	}
+
+	grown := NewFixedBlockRobinHoodMap[V](newCapacity)
+	for key, value := range m.Iter() {
+		grown.insert(key, *value)
+	}
+	grown.count = m.count
+
+	*m = *grown
+	return nil
+}
+
+// CollectInfo returns statistics about the map, like FixedBlockMap.CollectInfo.
+// The map has no tombstones, so it never recommends rehashing.
+func (m *FixedBlockRobinHoodMap[V]) CollectInfo() FixedBlockMapInfo {
+	return newFixedBlockMapInfo(m.count, 0, m.Capacity())
+}
+
+// ProbeLengths returns a histogram of how many blocks past their home block
+// entries are stored: element i is the number of entries stored i blocks away
+func (m *FixedBlockRobinHoodMap[V]) ProbeLengths() []uint64 {
+	var histogram []uint64
+	for blockIndex := range m.blocks {
+		block := &m.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			if block.controlByte(i) == 0x0 {
+				continue
+			}
+
+			// The stored distance may be capped, so compute it from the key
+			distance := (uint64(blockIndex) - block.keys[i].blockHash()) & m.mask
+			for uint64(len(histogram)) <= distance {
+				histogram = append(histogram, 0)
+			}
+			histogram[distance]++
+		}
+	}
+
+	return histogram
+}
@@ -0,0 +1,202 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkRobinHood verifies the stored distances and that every block on the
+// probe path of an entry is full of entries at least as far from home
+func checkRobinHood[V any](t *testing.T, m *FixedBlockRobinHoodMap[V]) {
+	t.Helper()
+
+	var count uint64
+	for blockIndex := range m.blocks {
+		block := &m.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			if block.controlByte(i) == 0x0 {
+				require.Equal(t, uint8(0), block.distance(i))
+				continue
+			}
+			count++
+
+			home := block.keys[i].blockHash() & m.mask
+			distance := (uint64(blockIndex) - home) & m.mask
+			require.Equal(t, uint8(min(distance, robinHoodMaxStoredDistance)), block.distance(i))
+
+			for d := uint64(0); d < distance; d++ {
+				path := &m.blocks[(home+d)&m.mask]
+				require.Zero(t, matchEmpty(path.control))
+				require.Zero(t, matchLess(path.distances, uint8(min(d, robinHoodMaxStoredDistance))))
+			}
+		}
+	}
+
+	require.Equal(t, m.count, count)
+}
+
+func TestFixedBlockRobinHoodMap_Operations(t *testing.T) {
+	m := NewFixedBlockRobinHoodMap[int](1024)
+	reference := make(map[FixedBlockKey]int)
+
+	// Random puts and deletes around 90% load
+	rng := rand.New(rand.NewPCG(5, 6))
+	for i := 0; i < 20000; i++ {
+		key := bloomKey("rh", rng.IntN(1100))
+		if rng.IntN(3) == 0 {
+			m.Delete(key)
+			delete(reference, key)
+		} else if m.Len() < 950 {
+			require.NoError(t, m.Put(key, i))
+			reference[key] = i
+		}
+	}
+	checkRobinHood(t, m)
+
+	assert.Equal(t, uint64(len(reference)), m.Len())
+	for key, expected := range reference {
+		value, found := m.Get(key)
+		require.True(t, found)
+		require.Equal(t, expected, *value)
+	}
+	for i := 0; i < 1000; i++ {
+		_, found := m.Get(bloomKey("missing", i))
+		require.False(t, found)
+	}
+
+	var iterated int
+	for key, value := range m.Iter() {
+		require.Equal(t, reference[key], *value)
+		iterated++
+	}
+	assert.Equal(t, len(reference), iterated)
+}
+
+func TestFixedBlockRobinHoodMap_Full(t *testing.T) {
+	m := NewFixedBlockRobinHoodMap[int](64)
+	for i := 0; i < 64; i++ {
+		require.NoError(t, m.Put(bloomKey("full", i), i))
+	}
+	checkRobinHood(t, m)
+
+	assert.Error(t, m.Put(bloomKey("full", 64), 64))
+	require.NoError(t, m.Put(bloomKey("full", 3), 300))
+
+	// Misses terminate without any empty slot in the map
+	_, found := m.Get(bloomKey("missing", 0))
+	assert.False(t, found)
+
+	// Growing to a capacity the map already has does nothing
+	require.NoError(t, m.Grow(32))
+	assert.Equal(t, uint64(64), m.Capacity())
+
+	require.NoError(t, m.Grow(128))
+	checkRobinHood(t, m)
+	require.NoError(t, m.Put(bloomKey("full", 64), 64))
+	value, _ := m.Get(bloomKey("full", 3))
+	assert.Equal(t, 300, *value)
+	assert.Equal(t, uint64(65), m.Len())
+}
+
+func TestFixedBlockRobinHoodMap_ProbeLengths(t *testing.T) {
+	const capacity = 1 << 14
+	robinHood := NewFixedBlockRobinHoodMap[int](capacity)
+	linear := NewFixedBlockMap[int](capacity)
+
+	for i := 0; i < capacity*95/100; i++ {
+		require.NoError(t, robinHood.Put(bloomKey("probe", i), i))
+		require.NoError(t, linear.Put(bloomKey("probe", i), i))
+	}
+	checkRobinHood(t, robinHood)
+
+	var buf bytes.Buffer
+	_, err := linear.WriteTo(&buf)
+	require.NoError(t, err)
+	raw, err := ReadRawFixedBlockMap(&buf, 0)
+	require.NoError(t, err)
+
+	// Robin Hood placement shortens the longest probes
+	robinHoodLengths, linearLengths := robinHood.ProbeLengths(), raw.ProbeLengths()
+	assert.Less(t, len(robinHoodLengths), len(linearLengths))
+	t.Logf("longest probe: robin hood %d, linear %d", len(robinHoodLengths)-1, len(linearLengths)-1)
+}
+
+// benchmarkLoads are the load factors the map benchmarks run at
+var benchmarkLoads = []int{85, 90, 95}
+
+// benchmarkKeys returns keys filling a map of capacity to load percent, and
+// as many keys that are not in the map
+func benchmarkKeys(capacity uint64, load int) (present, missing []FixedBlockKey) {
+	count := int(capacity) * load / 100
+	for i := 0; i < count; i++ {
+		present = append(present, bloomKey("bench", i))
+		missing = append(missing, bloomKey("miss", i))
+	}
+	return present, missing
+}
+
+func BenchmarkFixedBlockRobinHoodMap_Get(b *testing.B) {
+	const capacity = 1 << 17
+
+	for _, load := range benchmarkLoads {
+		present, missing := benchmarkKeys(capacity, load)
+
+		robinHood := NewFixedBlockRobinHoodMap[uint64](capacity)
+		linear := NewFixedBlockMap[uint64](capacity)
+		for i, key := range present {
+			robinHood.Put(key, uint64(i))
+			linear.Put(key, uint64(i))
+		}
+
+		for _, keys := range []struct {
+			name string
+			keys []FixedBlockKey
+		}{{"hit", present}, {"miss", missing}} {
+			b.Run(fmt.Sprintf("load=%d/%s/robinhood", load, keys.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					robinHood.Get(keys.keys[i%len(keys.keys)])
+				}
+			})
+
+			b.Run(fmt.Sprintf("load=%d/%s/linear", load, keys.name), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					linear.Get(keys.keys[i%len(keys.keys)])
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkFixedBlockRobinHoodMap_Fill measures filling an empty map up to the
+// load factor, so an operation is a whole map rather than a single Put
+func BenchmarkFixedBlockRobinHoodMap_Fill(b *testing.B) {
+	const capacity = 1 << 17
+
+	for _, load := range benchmarkLoads {
+		present, _ := benchmarkKeys(capacity, load)
+
+		b.Run(fmt.Sprintf("load=%d/robinhood", load), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := NewFixedBlockRobinHoodMap[uint64](capacity)
+				for j, key := range present {
+					m.Put(key, uint64(j))
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("load=%d/linear", load), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := NewFixedBlockMap[uint64](capacity)
+				for j, key := range present {
+					m.Put(key, uint64(j))
+				}
+			}
+		})
+	}
+}
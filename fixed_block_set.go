@@ -0,0 +1,546 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"math/bits"
+	"unsafe"
+)
+
+// setBlock is a FixedBlock without values: the control word and the keys of
+// 8 slots, 136 bytes in total
+type setBlock struct {
+	control uint64 // same encoding as FixedBlock.control
+	keys    [FixedBlockSize]FixedBlockKey
+}
+
+// controlByte returns the control byte of slot i
+func (b *setBlock) controlByte(i int) uint8 {
+	return uint8(b.control >> (i * 8))
+}
+
+// setControlByte updates the control byte of slot i
+func (b *setBlock) setControlByte(i int, value uint8) {
+	shift := i * 8
+	b.control = b.control&^(0xFF<<shift) | uint64(value)<<shift
+}
+
+// FixedBlockSet is a set of FixedBlockKeys using the block layout and
+// control-word probing of FixedBlockMap. Its blocks only hold the control word
+// and the keys, so a set takes less memory than a FixedBlockMap[struct{}],
+// whose blocks are padded to 144 bytes, and iteration never touches values.
+// It supports the same growth, rehashing and serialization as FixedBlockMap.
+type FixedBlockSet struct {
+	blocks     []setBlock
+	mask       uint64
+	count      uint64 // number of stored keys
+	tombstones uint64 // number of deleted slots
+}
+
+// NewFixedBlockSet initializes the set to support the given capacity
+func NewFixedBlockSet(capacity uint64) *FixedBlockSet {
+	blockCount := calculateBlockCount(capacity)
+
+	return &FixedBlockSet{
+		blocks: make([]setBlock, blockCount),
+		mask:   blockCount - 1,
+	}
+}
+
+// find returns the block and slot holding key, or -1 as the slot. Probing
+// stops at a block with an empty slot, or after visiting every block.
+func (s *FixedBlockSet) find(key FixedBlockKey) (uint64, int) {
+	if len(s.blocks) == 0 {
+		return 0, -1
+	}
+
+	blockIndex := key.blockHash() & s.mask
+	tag := key[0] | 0x80
+
+	for probed := uint64(0); probed <= s.mask; probed++ {
+		block := &s.blocks[blockIndex]
+
+		result := matchTag(block.control, tag)
+		for result != 0 {
+			index := bits.TrailingZeros64(result) / 8
+			if block.keys[index] == key {
+				return blockIndex, index
+			}
+
+			result &= result - 1
+		}
+
+		if matchEmpty(block.control) != 0x0 {
+			break
+		}
+
+		blockIndex = (blockIndex + 1) & s.mask
+	}
+
+	return 0, -1
+}
+
+// Add inserts a key. Adding a key that is already in the set does nothing.
+func (s *FixedBlockSet) Add(key FixedBlockKey) error {
+	if _, index := s.find(key); index >= 0 {
+		return nil
+	}
+
+	if !s.insert(key) {
+		return errors.New("set overflow: no empty slots available")
+	}
+
+	return nil
+}
+
+// insert places a key that is not in the set in the first empty or deleted
+// slot on its probe chain, and reports whether there was one
+func (s *FixedBlockSet) insert(key FixedBlockKey) bool {
+	if len(s.blocks) == 0 {
+		return false
+	}
+
+	blockIndex := key.blockHash() & s.mask
+	tag := key[0] | 0x80
+
+	for probed := uint64(0); probed <= s.mask; probed++ {
+		block := &s.blocks[blockIndex]
+
+		if free := matchEmpty(block.control) | matchTag(block.control, 0x1); free != 0 {
+			// matchEmpty and matchTag may flag bytes above the lowest match,
+			// so only the lowest flagged slot is used
+			index := bits.TrailingZeros64(free) / 8
+			if block.controlByte(index) == 0x1 {
+				s.tombstones--
+			}
+
+			block.setControlByte(index, tag)
+			block.keys[index] = key
+			s.count++
+			return true
+		}
+
+		blockIndex = (blockIndex + 1) & s.mask
+	}
+
+	return false
+}
+
+// Contains reports whether key is in the set
+func (s *FixedBlockSet) Contains(key FixedBlockKey) bool {
+	_, index := s.find(key)
+	return index >= 0
+}
+
+// Remove deletes a key from the set
+func (s *FixedBlockSet) Remove(key FixedBlockKey) {
+	if blockIndex, index := s.find(key); index >= 0 {
+		s.deleteSlot(blockIndex, index)
+	}
+}
+
+// deleteSlot marks an occupied slot as deleted
+func (s *FixedBlockSet) deleteSlot(blockIndex uint64, index int) {
+	s.blocks[blockIndex].setControlByte(index, 0x1)
+	s.count--
+	s.tombstones++
+}
+
+// Len returns the number of keys in the set
+func (s *FixedBlockSet) Len() uint64 {
+	return s.count
+}
+
+// Capacity returns the maximum capacity of the set
+func (s *FixedBlockSet) Capacity() uint64 {
+	return uint64(len(s.blocks)) * FixedBlockSize
+}
+
+// Iter returns an iterator over the keys in the set
+func (s *FixedBlockSet) Iter() iter.Seq[FixedBlockKey] {
+	return func(yield func(FixedBlockKey) bool) {
+		for blockIndex := range s.blocks {
+			block := &s.blocks[blockIndex]
+
+			for i := 0; i < FixedBlockSize; i++ {
+				ctrl := block.controlByte(i)
+				if ctrl != 0x0 && ctrl != 0x1 {
+					if !yield(block.keys[i]) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Clone returns an independent copy of the set
+func (s *FixedBlockSet) Clone() *FixedBlockSet {
+	clone := *s
+	clone.blocks = make([]setBlock, len(s.blocks))
+	copy(clone.blocks, s.blocks)
+
+	return &clone
+}
+
+// Equal reports whether both sets hold the same keys
+func (s *FixedBlockSet) Equal(other *FixedBlockSet) bool {
+	if s.Len() != other.Len() {
+		return false
+	}
+
+	for key := range s.Iter() {
+		if !other.Contains(key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Union adds every key of other to the set, growing the set whenever it
+// becomes too full
+func (s *FixedBlockSet) Union(other *FixedBlockSet) error {
+	for key := range other.Iter() {
+		if err := s.addGrowing(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addGrowing adds a key, making room first like FixedBlockMap.putGrowing:
+// the set is doubled when its keys reach 75% of the slots, and rehashed when
+// tombstones make up the rest
+func (s *FixedBlockSet) addGrowing(key FixedBlockKey) error {
+	if s.Contains(key) {
+		return nil
+	}
+
+	if (s.count+s.tombstones+1)*4 >= s.Capacity()*3 {
+		var err error
+		if (s.count+1)*4 >= s.Capacity()*3 {
+			err = s.Grow(max(s.Capacity()*2, FixedBlockSize))
+		} else {
+			err = s.Rehash()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Add(key)
+}
+
+// Intersect removes every key that is not in other from the set
+func (s *FixedBlockSet) Intersect(other *FixedBlockSet) {
+	s.removeWhere(func(key FixedBlockKey) bool {
+		return !other.Contains(key)
+	})
+}
+
+// Difference removes every key that is in other from the set
+func (s *FixedBlockSet) Difference(other *FixedBlockSet) {
+	if other.Len() < s.Len() {
+		// Looking up the smaller set's keys is cheaper
+		for key := range other.Iter() {
+			s.Remove(key)
+		}
+		return
+	}
+
+	s.removeWhere(other.Contains)
+}
+
+// removeWhere deletes the keys for which pred returns true
+func (s *FixedBlockSet) removeWhere(pred func(key FixedBlockKey) bool) {
+	for blockIndex := range s.blocks {
+		block := &s.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl != 0x0 && ctrl != 0x1 && pred(block.keys[i]) {
+				s.deleteSlot(uint64(blockIndex), i)
+			}
+		}
+	}
+}
+
+// CollectInfo returns statistics about the set, like FixedBlockMap.CollectInfo
+func (s *FixedBlockSet) CollectInfo() FixedBlockMapInfo {
+	return newFixedBlockMapInfo(s.count, s.tombstones, s.Capacity())
+}
+
+// Rehash removes all deleted slots by placing every key again. Unlike
+// FixedBlockMap.Rehash it builds new blocks rather than working in place.
+func (s *FixedBlockSet) Rehash() error {
+	if len(s.blocks) == 0 {
+		return nil
+	}
+
+	return s.rebuild(uint64(len(s.blocks)))
+}
+
+// Grow extends the capacity of the set and places every key again. Like
+// FixedBlockMap.Grow, it does nothing if the set already has enough blocks.
+func (s *FixedBlockSet) Grow(newCapacity uint64) error {
+	blockCount := calculateBlockCount(newCapacity)
+	if blockCount <= uint64(len(s.blocks)) {
+		return nil
+	}
+
+	return s.rebuild(blockCount)
+}
+
+// rebuild places every key into blockCount new blocks
+func (s *FixedBlockSet) rebuild(blockCount uint64) error {
+	rebuilt := &FixedBlockSet{
+		blocks: make([]setBlock, blockCount),
+		mask:   blockCount - 1,
+	}
+
+	for key := range s.Iter() {
+		if !rebuilt.insert(key) {
+			return errors.New("set overflow: no empty slots available")
+		}
+	}
+
+	*s = *rebuilt
+	return nil
+}
+
+// countSlots counts the occupied and deleted slots
+func (s *FixedBlockSet) countSlots() (count, tombstones uint64) {
+	for blockIndex := range s.blocks {
+		block := &s.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			switch block.controlByte(i) {
+			case 0x0:
+			case 0x1:
+				tombstones++
+			default:
+				count++
+			}
+		}
+	}
+
+	return count, tombstones
+}
+
+// recount rebuilds the key and tombstone counters by scanning every slot
+func (s *FixedBlockSet) recount() {
+	s.count, s.tombstones = s.countSlots()
+}
+
+// Validate checks the invariants of the set, like FixedBlockMap.Validate
+func (s *FixedBlockSet) Validate() error {
+	blockCount := uint64(len(s.blocks))
+	if blockCount == 0 || blockCount&(blockCount-1) != 0 || s.mask != blockCount-1 {
+		return fmt.Errorf("%w: %d blocks with mask %#x", ErrCorruptMap, blockCount, s.mask)
+	}
+
+	for blockIndex := range s.blocks {
+		block := &s.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl == 0x0 || ctrl == 0x1 {
+				continue
+			}
+
+			key := block.keys[i]
+			if ctrl != key[0]|0x80 {
+				return fmt.Errorf("%w: block %d slot %d has tag %#x for key %x", ErrCorruptMap, blockIndex, i, ctrl, key)
+			}
+
+			foundBlockIndex, foundIndex := s.find(key)
+			if foundIndex < 0 {
+				return fmt.Errorf("%w: key %x in block %d slot %d is not reachable from block %d", ErrCorruptMap, key, blockIndex, i, key.blockHash()&s.mask)
+			}
+			if foundBlockIndex != uint64(blockIndex) || foundIndex != i {
+				return fmt.Errorf("%w: key %x in block %d slot %d is stored more than once", ErrCorruptMap, key, blockIndex, i)
+			}
+		}
+	}
+
+	count, tombstones := s.countSlots()
+	if count != s.count || tombstones != s.tombstones {
+		return fmt.Errorf("%w: counted %d entries and %d tombstones, expected %d and %d", ErrCorruptMap, count, tombstones, s.count, s.tombstones)
+	}
+
+	return nil
+}
+
+// setBlockBytes maps the memory of the blocks directly to a []byte
+func setBlockBytes(blocks []setBlock) []byte {
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&blocks[0])), int(unsafe.Sizeof(blocks[0]))*len(blocks))
+}
+
+// WriteTo writes the raw memory of the set in the chunked, checksummed
+// format of FixedBlockMap.WriteTo, with the block size of the set
+func (s *FixedBlockSet) WriteTo(w io.Writer) (int64, error) {
+	if len(s.blocks) == 0 {
+		return 0, nil
+	}
+
+	return writeRaw(w, setBlockBytes(s.blocks), int(unsafe.Sizeof(s.blocks[0])))
+}
+
+// ReadFrom replaces the set with data written by WriteTo, like
+// FixedBlockMap.ReadFrom. The set can be the zero value.
+func (s *FixedBlockSet) ReadFrom(r io.Reader) (int64, error) {
+	header, total, err := readRawHeader(r)
+	if err != nil {
+		return total, err
+	}
+
+	blockSize := int(unsafe.Sizeof(setBlock{}))
+	if int(header.blockSize) != blockSize {
+		return total, fmt.Errorf("invalid set data: block size %d does not match %d", header.blockSize, blockSize)
+	}
+
+	// The blocks grow with the data read, like FixedBlockMap.ReadFrom
+	var blocks []setBlock
+	read, err := readRawBlocks(r, &header, rawGrower(&blocks))
+	total += read
+	if err != nil {
+		return total, err
+	}
+
+	s.blocks = blocks
+	s.mask = header.blockCount - 1
+	s.recount()
+
+	return total, nil
+}
+
+// setPortableBlockSize is the size of an encoded block: the control word and
+// the keys
+const setPortableBlockSize = 8 + FixedBlockSize*len(FixedBlockKey{})
+
+// WritePortableTo writes the set in the machine-independent format of
+// FixedBlockMap.WritePortableTo. The data is identical to that of a
+// FixedBlockMap[struct{}] holding the same slots, so either can read it.
+func (s *FixedBlockSet) WritePortableTo(w io.Writer) (int64, error) {
+	var header [portableHeaderSize]byte
+	copy(header[0:4], portableMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], portableVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(s.blocks)))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	var buf [setPortableBlockSize]byte
+	for blockIndex := range s.blocks {
+		block := &s.blocks[blockIndex]
+
+		binary.LittleEndian.PutUint64(buf[0:8], block.control)
+		for i := range block.keys {
+			copy(buf[8+i*len(FixedBlockKey{}):], block.keys[i][:])
+		}
+
+		written, err = w.Write(buf[:])
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// ReadPortableFrom replaces the set with data written by WritePortableTo, or
+// by FixedBlockMap[struct{}].WritePortableTo. The set can be the zero value,
+// and is left untouched when an error is returned.
+func (s *FixedBlockSet) ReadPortableFrom(r io.Reader) (int64, error) {
+	var header [portableHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != portableMagic {
+		return total, errors.New("invalid portable set: bad magic")
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != portableVersion {
+		return total, fmt.Errorf("invalid portable set: unsupported version %d", version)
+	}
+
+	// The zero value set is written with a block count of zero
+	blockCount := binary.LittleEndian.Uint64(header[8:16])
+	if blockCount&(blockCount-1) != 0 {
+		return total, fmt.Errorf("invalid portable set: block count %d is not a power of two", blockCount)
+	}
+	if valueSize := binary.LittleEndian.Uint32(header[16:20]); valueSize != 0 {
+		return total, fmt.Errorf("invalid portable set: value size %d is not zero", valueSize)
+	}
+
+	// The blocks grow with the data read, like FixedBlockMap.ReadPortableFrom
+	blocks := make([]setBlock, 0, min(blockCount, maxPreallocatedBlocks))
+	var buf [setPortableBlockSize]byte
+
+	for blockIndex := uint64(0); blockIndex < blockCount; blockIndex++ {
+		read, err = io.ReadFull(r, buf[:])
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+
+		block := setBlock{control: binary.LittleEndian.Uint64(buf[0:8])}
+		for i := range block.keys {
+			copy(block.keys[i][:], buf[8+i*len(FixedBlockKey{}):])
+		}
+		blocks = append(blocks, block)
+	}
+
+	if blockCount == 0 {
+		blocks = nil
+	}
+
+	s.blocks = blocks
+	s.mask = max(blockCount, 1) - 1
+	s.recount()
+
+	return total, nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the portable format
+func (s *FixedBlockSet) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.WritePortableTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. The set can be the
+// zero value.
+func (s *FixedBlockSet) UnmarshalBinary(data []byte) error {
+	var decoded FixedBlockSet
+	r := bytes.NewReader(data)
+	if _, err := decoded.ReadPortableFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("invalid portable set: %d trailing bytes", r.Len())
+	}
+
+	*s = decoded
+	return nil
+}
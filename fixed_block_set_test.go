@@ -0,0 +1,237 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestSet builds a set holding the keys "set<i>" for i in [lo, hi)
+func newTestSet(t *testing.T, lo, hi int) *FixedBlockSet {
+	s := NewFixedBlockSet(uint64(2 * (hi - lo)))
+	for i := lo; i < hi; i++ {
+		require.NoError(t, s.Add(setKey(i)))
+	}
+
+	return s
+}
+
+func setKey(i int) FixedBlockKey {
+	var key FixedBlockKey
+	key.FromString(fmt.Sprintf("set%d", i))
+	return key
+}
+
+func TestFixedBlockSet_AddContainsRemove(t *testing.T) {
+	s := NewFixedBlockSet(16)
+
+	require.NoError(t, s.Add(setKey(1)))
+	require.NoError(t, s.Add(setKey(1)))
+	require.NoError(t, s.Add(setKey(2)))
+	assert.Equal(t, uint64(2), s.Len())
+
+	assert.True(t, s.Contains(setKey(1)))
+	assert.False(t, s.Contains(setKey(3)))
+
+	s.Remove(setKey(1))
+	assert.False(t, s.Contains(setKey(1)))
+	assert.Equal(t, uint64(1), s.Len())
+
+	var keys []FixedBlockKey
+	for key := range s.Iter() {
+		keys = append(keys, key)
+	}
+	assert.Equal(t, []FixedBlockKey{setKey(2)}, keys)
+}
+
+func TestFixedBlockSet_Algebra(t *testing.T) {
+	t.Run("union", func(t *testing.T) {
+		// The union grows past the capacity of the receiver
+		s := newTestSet(t, 0, 10)
+		require.NoError(t, s.Union(newTestSet(t, 5, 100)))
+		assert.True(t, s.Equal(newTestSet(t, 0, 100)))
+		require.NoError(t, s.Validate())
+	})
+
+	t.Run("intersect", func(t *testing.T) {
+		s := newTestSet(t, 0, 50)
+		s.Intersect(newTestSet(t, 40, 60))
+		assert.True(t, s.Equal(newTestSet(t, 40, 50)))
+		require.NoError(t, s.Validate())
+	})
+
+	t.Run("difference", func(t *testing.T) {
+		s := newTestSet(t, 0, 50)
+		s.Difference(newTestSet(t, 40, 60))
+		assert.True(t, s.Equal(newTestSet(t, 0, 40)))
+
+		// The other set being larger takes the scanning path
+		s.Difference(newTestSet(t, 0, 30))
+		assert.True(t, s.Equal(newTestSet(t, 30, 40)))
+		require.NoError(t, s.Validate())
+	})
+
+	t.Run("self", func(t *testing.T) {
+		s := newTestSet(t, 0, 20)
+		s.Intersect(s)
+		assert.Equal(t, uint64(20), s.Len())
+
+		s.Difference(s)
+		assert.Equal(t, uint64(0), s.Len())
+	})
+}
+
+func TestFixedBlockSet_GrowAndRehash(t *testing.T) {
+	s := newTestSet(t, 0, 30)
+	for i := 0; i < 10; i++ {
+		s.Remove(setKey(i))
+	}
+
+	require.NoError(t, s.Rehash())
+	assert.Equal(t, float32(0), s.CollectInfo().TombstoneFactor)
+
+	require.NoError(t, s.Grow(256))
+	assert.Equal(t, uint64(256), s.Capacity())
+	assert.True(t, s.Equal(newTestSet(t, 10, 30)))
+}
+
+func TestFixedBlockSet_UnionMakesRoom(t *testing.T) {
+	// Keys already in the set never make it grow, even when it is nearly full
+	s := NewFixedBlockSet(64)
+	for i := 0; i < 47; i++ {
+		require.NoError(t, s.Add(setKey(i)))
+	}
+	require.NoError(t, s.Union(s.Clone()))
+	assert.Equal(t, uint64(64), s.Capacity())
+
+	// Tombstones are cleared by rehashing rather than growing the set
+	for i := 0; i < 30; i++ {
+		s.Remove(setKey(i))
+	}
+	require.NoError(t, s.Union(newTestSet(t, 100, 120)))
+	assert.Equal(t, uint64(64), s.Capacity())
+	assert.Equal(t, uint64(37), s.Len())
+
+	// Keys alone past 75% still grow it
+	require.NoError(t, s.Union(newTestSet(t, 200, 220)))
+	assert.Equal(t, uint64(128), s.Capacity())
+	assert.Equal(t, uint64(57), s.Len())
+}
+
+func TestFixedBlockSet_Serialization(t *testing.T) {
+	s := newTestSet(t, 0, 40)
+
+	var buf bytes.Buffer
+	_, err := s.WriteTo(&buf)
+	require.NoError(t, err)
+
+	// A corrupt block count fails on the missing data instead of allocating
+	// the announced blocks
+	var header rawHeader
+	require.NoError(t, header.decode(buf.Bytes()))
+	header.blockCount = 1 << 50
+	huge := bytes.Clone(buf.Bytes())
+	header.encode(huge)
+
+	var raw FixedBlockSet
+	_, err = raw.ReadFrom(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = raw.ReadFrom(&buf)
+	require.NoError(t, err)
+	assert.True(t, s.Equal(&raw))
+
+	buf.Reset()
+	_, err = s.WritePortableTo(&buf)
+	require.NoError(t, err)
+
+	var portable FixedBlockSet
+	_, err = portable.ReadPortableFrom(&buf)
+	require.NoError(t, err)
+	assert.True(t, s.Equal(&portable))
+
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+
+	var binary FixedBlockSet
+	require.NoError(t, binary.UnmarshalBinary(data))
+	assert.True(t, s.Equal(&binary))
+
+	// The zero value round-trips as an empty set
+	var zero, empty FixedBlockSet
+	data, err = zero.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, empty.UnmarshalBinary(data))
+	assert.Equal(t, uint64(0), empty.Len())
+	require.NoError(t, empty.Union(s))
+	assert.True(t, s.Equal(&empty))
+
+	clone := s.Clone()
+	clone.Remove(setKey(0))
+	assert.True(t, s.Contains(setKey(0)))
+}
+
+func TestFixedBlockSet_Layout(t *testing.T) {
+	// Blocks hold only the control word and the keys
+	assert.Equal(t, uintptr(8+FixedBlockSize*16), unsafe.Sizeof(setBlock{}))
+	assert.Less(t, unsafe.Sizeof(setBlock{}), unsafe.Sizeof(FixedBlock[struct{}]{}))
+}
+
+func TestFixedBlockSet_Full(t *testing.T) {
+	s := NewFixedBlockSet(16)
+	for i := 0; i < 16; i++ {
+		require.NoError(t, s.Add(setKey(i)))
+	}
+	assert.Error(t, s.Add(setKey(16)))
+
+	// Lookups end without an empty slot in the set
+	assert.False(t, s.Contains(setKey(16)))
+
+	// Deleted slots are reused
+	s.Remove(setKey(3))
+	require.NoError(t, s.Add(setKey(16)))
+	assert.True(t, s.Contains(setKey(16)))
+	require.NoError(t, s.Validate())
+
+	// The zero value grows when keys are added through Union
+	var empty FixedBlockSet
+	assert.Error(t, empty.Add(setKey(0)))
+	assert.False(t, empty.Contains(setKey(0)))
+	require.NoError(t, empty.Union(s))
+	assert.True(t, empty.Equal(s))
+}
+
+func TestFixedBlockSet_PortableMatchesMap(t *testing.T) {
+	s := newTestSet(t, 0, 40)
+	s.Remove(setKey(7))
+
+	m := NewFixedBlockMap[struct{}](s.Capacity())
+	for key := range s.Iter() {
+		require.NoError(t, m.Put(key, struct{}{}))
+	}
+
+	// A set and a map of zero-size values read each other's portable data
+	data, err := m.MarshalBinary()
+	require.NoError(t, err)
+
+	var fromMap FixedBlockSet
+	require.NoError(t, fromMap.UnmarshalBinary(data))
+	assert.True(t, s.Equal(&fromMap))
+	require.NoError(t, fromMap.Validate())
+
+	data, err = s.MarshalBinary()
+	require.NoError(t, err)
+
+	var fromSet FixedBlockMap[struct{}]
+	require.NoError(t, fromSet.UnmarshalBinary(data))
+	assert.Equal(t, s.Len(), fromSet.Len())
+	for key := range s.Iter() {
+		_, found := fromSet.Get(key)
+		assert.True(t, found)
+	}
+}
@@ -0,0 +1,204 @@
+package collections
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+const (
+	// HyperLogLog precisions supported by NewFixedBlockHyperLogLog
+	minHyperLogLogPrecision = 4
+	maxHyperLogLogPrecision = 18
+
+	// recommendedLoadFactor matches the load at which CollectInfo recommends
+	// growing a FixedBlockMap
+	recommendedLoadFactor = 0.75
+)
+
+// FixedBlockHyperLogLog estimates the number of distinct FixedBlockKeys added
+// to it in a fixed amount of memory. Keys are already well-mixed hashes, so
+// they are used as the hash directly. With precision p it keeps 2^p one-byte
+// registers and its estimates have a standard error of about 1.04/sqrt(2^p),
+// for example 0.8% with the default precision of 14 in 16 KiB.
+type FixedBlockHyperLogLog struct {
+	registers []uint8
+	precision uint8
+}
+
+// NewFixedBlockHyperLogLog creates an estimator with 2^precision registers.
+// The precision is clamped to [4, 18]; 14 is a good default.
+func NewFixedBlockHyperLogLog(precision uint8) *FixedBlockHyperLogLog {
+	precision = min(max(precision, minHyperLogLogPrecision), maxHyperLogLogPrecision)
+
+	return &FixedBlockHyperLogLog{
+		registers: make([]uint8, 1<<precision),
+		precision: precision,
+	}
+}
+
+// Add records a key
+func (h *FixedBlockHyperLogLog) Add(key FixedBlockKey) {
+	hash := key.blockHash()
+
+	// The top bits pick the register, the rank is the position of the first
+	// set bit in the rest. The guard bit bounds the rank if the rest is zero.
+	register := hash >> (64 - h.precision)
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+
+	if rank > h.registers[register] {
+		h.registers[register] = rank
+	}
+}
+
+// Estimate returns the estimated number of distinct keys added
+func (h *FixedBlockHyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+
+	var sum float64
+	var zeros int
+	for _, register := range h.registers {
+		sum += math.Ldexp(1, -int(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	var alpha float64
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+
+	estimate := alpha * m * m / sum
+
+	// Linear counting is more accurate while many registers are still empty
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(math.Round(estimate))
+}
+
+// StandardError returns the relative standard error of Estimate
+func (h *FixedBlockHyperLogLog) StandardError() float64 {
+	return 1.04 / math.Sqrt(float64(len(h.registers)))
+}
+
+// RecommendedCapacity returns a capacity for NewFixedBlockMap that holds the
+// estimated number of keys, allowing for two standard errors of
+// underestimation, without the map reaching the load at which CollectInfo
+// recommends growing it
+func (h *FixedBlockHyperLogLog) RecommendedCapacity() uint64 {
+	keys := float64(h.Estimate()) * (1 + 2*h.StandardError())
+	return uint64(math.Ceil(keys / recommendedLoadFactor))
+}
+
+// Merge adds the keys of other, so that the estimate covers the union of both.
+// Both must have the same precision.
+func (h *FixedBlockHyperLogLog) Merge(other *FixedBlockHyperLogLog) error {
+	if h.precision != other.precision {
+		return fmt.Errorf("cannot merge HyperLogLog of precision %d with precision %d", other.precision, h.precision)
+	}
+
+	for i, register := range other.registers {
+		h.registers[i] = max(h.registers[i], register)
+	}
+
+	return nil
+}
+
+// FixedBlockCountMinSketch keeps approximate counts of FixedBlockKeys in a
+// fixed amount of memory, for example to find the heavy hitters of a stream.
+// Counts are never underestimated, and with width w and depth d they are
+// overestimated by more than e/w times the total of all counts with a
+// probability of at most e^-d.
+type FixedBlockCountMinSketch struct {
+	counters []uint64 // depth rows of width counters
+	mask     uint64   // width - 1
+	depth    int
+	total    uint64
+}
+
+// NewFixedBlockCountMinSketch creates a sketch whose counts exceed the true
+// count by at most epsilon times the total of all counts, with probability
+// 1 - delta. For example, an epsilon of 0.001 and a delta of 0.01 use 5 rows
+// of 4096 counters.
+func NewFixedBlockCountMinSketch(epsilon, delta float64) *FixedBlockCountMinSketch {
+	width := uint64(math.Ceil(math.E / max(epsilon, 1e-9)))
+	width = 1 << (64 - bits.LeadingZeros64(max(width, 2)-1)) // next power of two
+	depth := max(1, int(math.Ceil(math.Log(1/min(max(delta, 1e-9), 0.5)))))
+
+	return &FixedBlockCountMinSketch{
+		counters: make([]uint64, width*uint64(depth)),
+		mask:     width - 1,
+		depth:    depth,
+	}
+}
+
+// Width returns the number of counters per row
+func (s *FixedBlockCountMinSketch) Width() uint64 {
+	return s.mask + 1
+}
+
+// Depth returns the number of rows
+func (s *FixedBlockCountMinSketch) Depth() int {
+	return s.depth
+}
+
+// countMinHashes returns the double hashes picking the counter of key in every row
+func countMinHashes(key *FixedBlockKey) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(key[0:8]), binary.LittleEndian.Uint64(key[8:16]) | 1
+}
+
+// Add increases the count of key
+func (s *FixedBlockCountMinSketch) Add(key FixedBlockKey, count uint64) {
+	h1, h2 := countMinHashes(&key)
+	width := s.mask + 1
+
+	for row := 0; row < s.depth; row++ {
+		s.counters[uint64(row)*width+(h1+uint64(row)*h2)&s.mask] += count
+	}
+
+	s.total += count
+}
+
+// Count returns the estimated count of key, which is never below the true count
+func (s *FixedBlockCountMinSketch) Count(key FixedBlockKey) uint64 {
+	h1, h2 := countMinHashes(&key)
+	width := s.mask + 1
+
+	estimate := uint64(math.MaxUint64)
+	for row := 0; row < s.depth; row++ {
+		estimate = min(estimate, s.counters[uint64(row)*width+(h1+uint64(row)*h2)&s.mask])
+	}
+
+	return estimate
+}
+
+// Total returns the sum of all counts added
+func (s *FixedBlockCountMinSketch) Total() uint64 {
+	return s.total
+}
+
+// Merge adds the counts of other. Both sketches must have the same width and
+// depth.
+func (s *FixedBlockCountMinSketch) Merge(other *FixedBlockCountMinSketch) error {
+	if s.mask != other.mask || s.depth != other.depth {
+		return fmt.Errorf("cannot merge count-min sketch of %dx%d with %dx%d", other.depth, other.Width(), s.depth, s.Width())
+	}
+
+	for i, counter := range other.counters {
+		s.counters[i] += counter
+	}
+	s.total += other.total
+
+	return nil
+}
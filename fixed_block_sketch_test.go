@@ -0,0 +1,86 @@
+package collections
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFixedBlockHyperLogLog_Estimate(t *testing.T) {
+	for _, distinct := range []int{0, 10, 1000, 100000} {
+		h := NewFixedBlockHyperLogLog(14)
+
+		// Duplicates don't change the estimate
+		for i := 0; i < distinct; i++ {
+			h.Add(bloomKey("key", i))
+			h.Add(bloomKey("key", i))
+		}
+
+		assert.InEpsilon(t, float64(distinct)+1, float64(h.Estimate())+1, 3*h.StandardError(), "distinct %d", distinct)
+	}
+}
+
+func TestFixedBlockHyperLogLog_Merge(t *testing.T) {
+	a := NewFixedBlockHyperLogLog(12)
+	b := NewFixedBlockHyperLogLog(12)
+	for i := 0; i < 20000; i++ {
+		a.Add(bloomKey("key", i))
+		b.Add(bloomKey("key", i+10000))
+	}
+
+	require.NoError(t, a.Merge(b))
+	assert.InEpsilon(t, 30000, float64(a.Estimate()), 3*a.StandardError())
+
+	assert.Error(t, a.Merge(NewFixedBlockHyperLogLog(14)))
+}
+
+func TestFixedBlockHyperLogLog_RecommendedCapacity(t *testing.T) {
+	h := NewFixedBlockHyperLogLog(14)
+	for i := 0; i < 10000; i++ {
+		h.Add(bloomKey("key", i))
+	}
+
+	// A map of the recommended capacity holds the keys without needing to grow
+	m := NewFixedBlockMap[int](h.RecommendedCapacity())
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, m.Put(bloomKey("key", i), i))
+	}
+	assert.False(t, m.CollectInfo().RecommendGrow)
+}
+
+func TestFixedBlockCountMinSketch_Count(t *testing.T) {
+	s := NewFixedBlockCountMinSketch(0.001, 0.01)
+	assert.Equal(t, uint64(4096), s.Width())
+	assert.Equal(t, 5, s.Depth())
+
+	// A few heavy hitters among many light keys
+	for i := 0; i < 10000; i++ {
+		s.Add(bloomKey("light", i), 1)
+	}
+	for i := 0; i < 5; i++ {
+		s.Add(bloomKey("heavy", i), 1000)
+	}
+	assert.Equal(t, uint64(15000), s.Total())
+
+	bound := uint64(0.001 * float64(s.Total()))
+	for i := 0; i < 5; i++ {
+		count := s.Count(bloomKey("heavy", i))
+		assert.GreaterOrEqual(t, count, uint64(1000))
+		assert.LessOrEqual(t, count, 1000+bound)
+	}
+	assert.Equal(t, uint64(0), NewFixedBlockCountMinSketch(0.001, 0.01).Count(bloomKey("heavy", 0)))
+}
+
+func TestFixedBlockCountMinSketch_Merge(t *testing.T) {
+	a := NewFixedBlockCountMinSketch(0.01, 0.01)
+	b := NewFixedBlockCountMinSketch(0.01, 0.01)
+	a.Add(bloomKey("key", 1), 3)
+	b.Add(bloomKey("key", 1), 4)
+
+	require.NoError(t, a.Merge(b))
+	assert.GreaterOrEqual(t, a.Count(bloomKey("key", 1)), uint64(7))
+	assert.Equal(t, uint64(7), a.Total())
+
+	assert.Error(t, a.Merge(NewFixedBlockCountMinSketch(0.001, 0.01)))
+}
@@ -0,0 +1,200 @@
+package collections
+
+import (
+	"io"
+	"iter"
+	"time"
+)
+
+// FixedBlockTTLOptions configures a FixedBlockTTLMap
+type FixedBlockTTLOptions struct {
+	// Clock returns the current time. Defaults to time.Now; tests can
+	// replace it to control expiry deterministically.
+	Clock func() time.Time
+}
+
+// ttlEntry is a value stored with its expiration time
+type ttlEntry[V any] struct {
+	Value   V
+	Expires int64 // Unix time in nanoseconds, or 0 for entries that never expire
+}
+
+// expired reports whether the entry has expired at now
+func (e *ttlEntry[V]) expired(now int64) bool {
+	return e.Expires != 0 && e.Expires <= now
+}
+
+// FixedBlockTTLMap is a FixedBlockMap whose entries can expire. Expired entries
+// are never returned, and are removed lazily: Get deletes the expired entries
+// it finds, and Sweep deletes them a few blocks at a time, so callers can
+// spread the cleanup over time instead of scanning the whole map at once.
+//
+// The expiration time is stored next to each value, so the map can be
+// serialized with WriteTo and ReadFrom like any FixedBlockMap.
+type FixedBlockTTLMap[V any] struct {
+	m     *FixedBlockMap[ttlEntry[V]]
+	clock func() time.Time
+	sweep uint64 // next block to sweep
+}
+
+// NewFixedBlockTTLMap initializes the map to support the given capacity
+func NewFixedBlockTTLMap[V any](capacity uint64, options FixedBlockTTLOptions) *FixedBlockTTLMap[V] {
+	if options.Clock == nil {
+		options.Clock = time.Now
+	}
+
+	return &FixedBlockTTLMap[V]{
+		m:     NewFixedBlockMap[ttlEntry[V]](capacity),
+		clock: options.Clock,
+	}
+}
+
+// now returns the current time of the clock in Unix nanoseconds
+func (t *FixedBlockTTLMap[V]) now() int64 {
+	return t.clock().UnixNano()
+}
+
+// Put inserts or updates a key that never expires
+func (t *FixedBlockTTLMap[V]) Put(key FixedBlockKey, value V) error {
+	return t.m.Put(key, ttlEntry[V]{Value: value})
+}
+
+// PutWithTTL inserts or updates a key that expires once ttl has passed
+func (t *FixedBlockTTLMap[V]) PutWithTTL(key FixedBlockKey, value V, ttl time.Duration) error {
+	return t.PutWithExpiry(key, value, t.clock().Add(ttl))
+}
+
+// PutWithExpiry inserts or updates a key that expires at the given time. The
+// zero time means the key never expires.
+func (t *FixedBlockTTLMap[V]) PutWithExpiry(key FixedBlockKey, value V, expires time.Time) error {
+	entry := ttlEntry[V]{Value: value}
+	if !expires.IsZero() {
+		entry.Expires = expires.UnixNano()
+	}
+
+	return t.m.Put(key, entry)
+}
+
+// lookup returns the entry stored for key, deleting it if it has expired
+func (t *FixedBlockTTLMap[V]) lookup(key FixedBlockKey) (*ttlEntry[V], bool) {
+	entry, found := t.m.Get(key)
+	if !found {
+		return nil, false
+	}
+
+	if entry.expired(t.now()) {
+		t.m.Delete(key)
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// Get returns the value stored for key, unless it has expired
+func (t *FixedBlockTTLMap[V]) Get(key FixedBlockKey) (*V, bool) {
+	entry, found := t.lookup(key)
+	if !found {
+		return nil, false
+	}
+
+	return &entry.Value, true
+}
+
+// Expiry returns the time at which key expires, which is the zero time for
+// keys that never expire
+func (t *FixedBlockTTLMap[V]) Expiry(key FixedBlockKey) (time.Time, bool) {
+	entry, found := t.lookup(key)
+	if !found {
+		return time.Time{}, false
+	}
+
+	if entry.Expires == 0 {
+		return time.Time{}, true
+	}
+
+	return time.Unix(0, entry.Expires), true
+}
+
+// Delete removes a key
+func (t *FixedBlockTTLMap[V]) Delete(key FixedBlockKey) {
+	t.m.Delete(key)
+}
+
+// Sweep deletes the expired entries in the next blocks blocks, continuing
+// where the previous call stopped and wrapping around at the end of the map,
+// and returns the number of deleted entries. Calling it regularly with a small
+// number of blocks keeps expired entries from piling up without pausing for a
+// scan of the whole map.
+func (t *FixedBlockTTLMap[V]) Sweep(blocks int) int {
+	m := t.m
+	now := t.now()
+	deleted := 0
+
+	for n := 0; n < blocks && n < len(m.blocks); n++ {
+		blockIndex := t.sweep & m.mask
+		block := &m.blocks[blockIndex]
+
+		for i := 0; i < FixedBlockSize; i++ {
+			ctrl := block.controlByte(i)
+			if ctrl != 0x0 && ctrl != 0x1 && block.values[i].expired(now) {
+				m.deleteSlot(blockIndex, i)
+				deleted++
+			}
+		}
+
+		t.sweep = (blockIndex + 1) & m.mask
+	}
+
+	return deleted
+}
+
+// Len returns the number of entries in the map, including expired entries
+// that have not been deleted yet
+func (t *FixedBlockTTLMap[V]) Len() uint64 {
+	return t.m.Len()
+}
+
+// Capacity returns the maximum capacity of the map
+func (t *FixedBlockTTLMap[V]) Capacity() uint64 {
+	return t.m.Capacity()
+}
+
+// Iter returns an iterator over the keys and pointers to the values of every
+// entry that has not expired. Expired entries are skipped but not deleted.
+func (t *FixedBlockTTLMap[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
+	return func(yield func(FixedBlockKey, *V) bool) {
+		now := t.now()
+
+		for key, entry := range t.m.Iter() {
+			if !entry.expired(now) && !yield(key, &entry.Value) {
+				return
+			}
+		}
+	}
+}
+
+// CollectInfo returns statistics about the underlying map. Expired entries
+// that have not been deleted count as stored entries.
+func (t *FixedBlockTTLMap[V]) CollectInfo() FixedBlockMapInfo {
+	return t.m.CollectInfo()
+}
+
+// Rehash removes all deleted slots, like FixedBlockMap.Rehash
+func (t *FixedBlockTTLMap[V]) Rehash() error {
+	return t.m.Rehash()
+}
+
+// Grow extends the capacity of the map, like FixedBlockMap.Grow
+func (t *FixedBlockTTLMap[V]) Grow(newCapacity uint64) error {
+	return t.m.Grow(newCapacity)
+}
+
+// WriteTo writes the map, including expiration times, like FixedBlockMap.WriteTo
+func (t *FixedBlockTTLMap[V]) WriteTo(w io.Writer) (int64, error) {
+	return t.m.WriteTo(w)
+}
+
+// ReadFrom replaces the map with data written by WriteTo, like FixedBlockMap.ReadFrom
+func (t *FixedBlockTTLMap[V]) ReadFrom(r io.Reader) (int64, error) {
+	return t.m.ReadFrom(r)
+}
@@ -0,0 +1,131 @@
+package collections
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestTTLMap(capacity uint64) (*FixedBlockTTLMap[testValue], *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return NewFixedBlockTTLMap[testValue](capacity, FixedBlockTTLOptions{Clock: clock.Now}), clock
+}
+
+func TestFixedBlockTTLMap_Expiry(t *testing.T) {
+	m, clock := newTestTTLMap(64)
+
+	var session, permanent FixedBlockKey
+	session.FromString("session")
+	permanent.FromString("permanent")
+
+	require.NoError(t, m.PutWithTTL(session, testValue{ID: 1}, time.Minute))
+	require.NoError(t, m.Put(permanent, testValue{ID: 2}))
+
+	expires, found := m.Expiry(session)
+	require.True(t, found)
+	assert.Equal(t, clock.now.Add(time.Minute), expires.UTC())
+
+	expires, found = m.Expiry(permanent)
+	require.True(t, found)
+	assert.True(t, expires.IsZero())
+
+	clock.Advance(59 * time.Second)
+	value, found := m.Get(session)
+	require.True(t, found)
+	assert.Equal(t, uint64(1), value.ID)
+
+	// Get deletes the entry once it has expired
+	clock.Advance(time.Second)
+	_, found = m.Get(session)
+	assert.False(t, found)
+	assert.Equal(t, uint64(1), m.Len())
+
+	_, found = m.Get(permanent)
+	assert.True(t, found)
+
+	// Putting again resets the expiry
+	require.NoError(t, m.PutWithExpiry(session, testValue{ID: 3}, time.Time{}))
+	clock.Advance(time.Hour)
+	_, found = m.Get(session)
+	assert.True(t, found)
+}
+
+func TestFixedBlockTTLMap_Sweep(t *testing.T) {
+	m, clock := newTestTTLMap(256)
+
+	for i := 0; i < 100; i++ {
+		var key FixedBlockKey
+		key.FromString(fmt.Sprintf("ttl%d", i))
+		if i%2 == 0 {
+			require.NoError(t, m.PutWithTTL(key, testValue{ID: uint64(i)}, time.Second))
+		} else {
+			require.NoError(t, m.Put(key, testValue{ID: uint64(i)}))
+		}
+	}
+
+	// Nothing has expired yet
+	assert.Equal(t, 0, m.Sweep(1000))
+
+	clock.Advance(time.Second)
+
+	// Expired entries are hidden from iteration before they are swept
+	var live int
+	for _, value := range m.Iter() {
+		assert.Equal(t, uint64(1), value.ID%2)
+		live++
+	}
+	assert.Equal(t, 50, live)
+	assert.Equal(t, uint64(100), m.Len())
+
+	// Sweeping a few blocks at a time eventually covers the whole map
+	blockCount := int(m.Capacity() / FixedBlockSize)
+	deleted := 0
+	for i := 0; i < blockCount; i += 4 {
+		deleted += m.Sweep(4)
+	}
+	assert.Equal(t, 50, deleted)
+	assert.Equal(t, uint64(50), m.Len())
+	assert.Equal(t, 0, m.Sweep(blockCount))
+	require.NoError(t, m.m.Validate())
+}
+
+func TestFixedBlockTTLMap_WriteTo(t *testing.T) {
+	m, clock := newTestTTLMap(64)
+
+	var key FixedBlockKey
+	key.FromString("persisted")
+	require.NoError(t, m.PutWithTTL(key, testValue{ID: 7}, time.Minute))
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	loaded := NewFixedBlockTTLMap[testValue](8, FixedBlockTTLOptions{Clock: clock.Now})
+	_, err = loaded.ReadFrom(&buf)
+	require.NoError(t, err)
+
+	// The expiry survives the round trip
+	_, found := loaded.Get(key)
+	assert.True(t, found)
+
+	clock.Advance(time.Minute)
+	_, found = loaded.Get(key)
+	assert.False(t, found)
+}
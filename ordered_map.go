@@ -0,0 +1,670 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"math"
+	"slices"
+	"unsafe"
+)
+
+const (
+	// orderedNodeSize is the number of entries of a leaf and the number of
+	// children of an inner node
+	orderedNodeSize = 32
+
+	orderedVersion    = 1
+	orderedHeaderSize = 56
+
+	// orderedReadNodes is the number of nodes ReadFrom reads at a time, like
+	// multiMapReadNodes
+	orderedReadNodes = 1 << 10
+)
+
+var orderedMagic = [4]byte{'F', 'B', 'O', 'M'}
+
+// orderedLeaf holds sorted entries. Leaves are linked in key order by index
+// rather than pointer.
+type orderedLeaf[K, V any] struct {
+	count  int32
+	prev   uint32 // index+1 of the previous leaf, 0 for the first
+	next   uint32 // index+1 of the next leaf, 0 for the last
+	keys   [orderedNodeSize]K
+	values [orderedNodeSize]V
+}
+
+// orderedInner routes a search to one of its children. keys[i] is a lower
+// bound of the keys below children[i+1] and an upper bound of the keys below
+// children[i].
+type orderedInner[K any] struct {
+	count    int32 // number of children
+	keys     [orderedNodeSize - 1]K
+	children [orderedNodeSize]uint32 // indices of leaves or inner nodes
+}
+
+// OrderedMap is a B+tree that keeps its entries sorted by key, for range and
+// prefix scans that the hash order of FixedBlockMap cannot provide. Leaves and
+// inner nodes live in two arrays and refer to each other by index, so the tree
+// is free of pointers and can be written as raw memory like a FixedBlockMap.
+//
+// Like FixedBlockMap, deletion does not restructure the map: entries are
+// removed from their leaf, which may leave leaves partially filled or empty.
+// Compact rebuilds the tree with full leaves.
+type OrderedMap[K, V any] struct {
+	cmp    func(a, b K) int
+	leaves []orderedLeaf[K, V]
+	inners []orderedInner[K]
+	root   uint32 // index of the root, an inner node unless height is 0
+	height int    // number of inner levels above the leaves
+	tail   uint32 // index of the last leaf
+	count  uint64
+}
+
+// NewOrderedMap creates an ordered map of FixedBlockKeys, sorted by their
+// bytes. Since keys are usually hashes, this is mostly useful for keys built
+// with a sortable layout, such as a big-endian timestamp followed by an ID.
+func NewOrderedMap[V any]() *OrderedMap[FixedBlockKey, V] {
+	return NewOrderedMapFunc[FixedBlockKey, V](func(a, b FixedBlockKey) int {
+		return bytes.Compare(a[:], b[:])
+	})
+}
+
+// NewOrderedMapFunc creates an ordered map sorted by cmp, which returns a
+// negative number when a < b, a positive number when a > b and zero when they
+// are equal, like cmp.Compare
+func NewOrderedMapFunc[K, V any](cmp func(a, b K) int) *OrderedMap[K, V] {
+	return &OrderedMap[K, V]{
+		cmp:    cmp,
+		leaves: make([]orderedLeaf[K, V], 1),
+	}
+}
+
+// Len returns the number of entries in the map
+func (m *OrderedMap[K, V]) Len() uint64 {
+	return m.count
+}
+
+// lowerBound returns the index of the first of keys that is not less than key
+func (m *OrderedMap[K, V]) lowerBound(keys []K, key K) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if m.cmp(keys[mid], key) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
+// upperBound returns the index of the first of keys that is greater than key
+func (m *OrderedMap[K, V]) upperBound(keys []K, key K) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if m.cmp(keys[mid], key) <= 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
+// orderedStep records the inner node and child taken while descending
+type orderedStep struct {
+	node  uint32
+	child int
+}
+
+// descend returns the leaf that key belongs to and the position of key in
+// it. When path is not nil, the inner nodes passed are appended to it.
+func (m *OrderedMap[K, V]) descend(key K, path *[]orderedStep) (uint32, int) {
+	node := m.root
+	for level := m.height; level > 0; level-- {
+		inner := &m.inners[node]
+		child := m.upperBound(inner.keys[:inner.count-1], key)
+		if path != nil {
+			*path = append(*path, orderedStep{node: node, child: child})
+		}
+		node = inner.children[child]
+	}
+
+	leaf := &m.leaves[node]
+	return node, m.lowerBound(leaf.keys[:leaf.count], key)
+}
+
+// Get retrieves the value for a key
+func (m *OrderedMap[K, V]) Get(key K) (*V, bool) {
+	node, i := m.descend(key, nil)
+
+	leaf := &m.leaves[node]
+	if i < int(leaf.count) && m.cmp(leaf.keys[i], key) == 0 {
+		return &leaf.values[i], true
+	}
+
+	return nil, false
+}
+
+// Put inserts or replaces the value for a key. Full leaves are split in half,
+// and splits propagate up the tree.
+func (m *OrderedMap[K, V]) Put(key K, value V) {
+	var steps [16]orderedStep
+	path := steps[:0]
+
+	node, i := m.descend(key, &path)
+	leaf := &m.leaves[node]
+
+	if i < int(leaf.count) && m.cmp(leaf.keys[i], key) == 0 {
+		leaf.values[i] = value
+		return
+	}
+	m.count++
+
+	if leaf.count < orderedNodeSize {
+		leaf.insert(i, key, value)
+		return
+	}
+
+	// Move the upper half of the leaf into a new leaf after it
+	right := m.splitLeaf(node)
+	if i <= orderedNodeSize/2 {
+		m.leaves[node].insert(i, key, value)
+	} else {
+		m.leaves[right].insert(i-orderedNodeSize/2, key, value)
+	}
+
+	// Insert the new node into its parent, splitting full parents
+	separator := m.leaves[right].keys[0]
+	for level := len(path) - 1; level >= 0; level-- {
+		step := path[level]
+		if m.inners[step.node].count < orderedNodeSize {
+			m.inners[step.node].insert(step.child, separator, right)
+			return
+		}
+
+		separator, right = m.splitInner(step.node, step.child, separator, right)
+	}
+
+	// The root was split, so the tree grows by a level
+	root := orderedInner[K]{count: 2}
+	root.keys[0] = separator
+	root.children[0], root.children[1] = m.root, right
+
+	m.inners = append(m.inners, root)
+	m.root = uint32(len(m.inners) - 1)
+	m.height++
+}
+
+// insert places an entry at position i of a leaf that is not full
+func (leaf *orderedLeaf[K, V]) insert(i int, key K, value V) {
+	copy(leaf.keys[i+1:leaf.count+1], leaf.keys[i:leaf.count])
+	copy(leaf.values[i+1:leaf.count+1], leaf.values[i:leaf.count])
+	leaf.keys[i] = key
+	leaf.values[i] = value
+	leaf.count++
+}
+
+// insert adds a child and its lower bound after children[i] of an inner node
+// that is not full
+func (inner *orderedInner[K]) insert(i int, separator K, child uint32) {
+	copy(inner.keys[i+1:inner.count], inner.keys[i:inner.count-1])
+	copy(inner.children[i+2:inner.count+1], inner.children[i+1:inner.count])
+	inner.keys[i] = separator
+	inner.children[i+1] = child
+	inner.count++
+}
+
+// splitLeaf moves the upper half of a full leaf into a new leaf, linked after
+// it, and returns the index of the new leaf
+func (m *OrderedMap[K, V]) splitLeaf(node uint32) uint32 {
+	m.leaves = append(m.leaves, orderedLeaf[K, V]{})
+	right := uint32(len(m.leaves) - 1)
+
+	leaf, newLeaf := &m.leaves[node], &m.leaves[right]
+	half := orderedNodeSize / 2
+
+	copy(newLeaf.keys[:], leaf.keys[half:])
+	copy(newLeaf.values[:], leaf.values[half:])
+	newLeaf.count = int32(orderedNodeSize - half)
+	leaf.count = int32(half)
+
+	// Clear the moved values so they don't linger in the raw memory
+	clear(leaf.keys[half:])
+	clear(leaf.values[half:])
+
+	newLeaf.prev, newLeaf.next = node+1, leaf.next
+	if leaf.next != 0 {
+		m.leaves[leaf.next-1].prev = right + 1
+	} else {
+		m.tail = right
+	}
+	leaf.next = right + 1
+
+	return right
+}
+
+// splitInner inserts a child after children[i] of a full inner node and
+// splits it in two. It returns the lower bound and the index of the new node.
+func (m *OrderedMap[K, V]) splitInner(node uint32, i int, separator K, child uint32) (K, uint32) {
+	inner := &m.inners[node]
+
+	// Assemble the keys and children including the new one
+	var keys [orderedNodeSize]K
+	var children [orderedNodeSize + 1]uint32
+	copy(keys[:i], inner.keys[:i])
+	keys[i] = separator
+	copy(keys[i+1:], inner.keys[i:])
+	copy(children[:i+1], inner.children[:i+1])
+	children[i+1] = child
+	copy(children[i+2:], inner.children[i+1:])
+
+	// The left node keeps the first half of the children, the key between the
+	// halves moves up, and the right node gets the rest
+	half := (orderedNodeSize + 1) / 2
+	left := orderedInner[K]{count: int32(half)}
+	copy(left.keys[:], keys[:half-1])
+	copy(left.children[:], children[:half])
+
+	right := orderedInner[K]{count: int32(orderedNodeSize + 1 - half)}
+	copy(right.keys[:], keys[half:])
+	copy(right.children[:], children[half:])
+
+	m.inners[node] = left
+	m.inners = append(m.inners, right)
+
+	return keys[half-1], uint32(len(m.inners) - 1)
+}
+
+// Delete removes a key from the map and reports whether it was found. The
+// leaf is not merged with its neighbors, even when it becomes empty.
+func (m *OrderedMap[K, V]) Delete(key K) bool {
+	node, i := m.descend(key, nil)
+
+	leaf := &m.leaves[node]
+	if i >= int(leaf.count) || m.cmp(leaf.keys[i], key) != 0 {
+		return false
+	}
+
+	copy(leaf.keys[i:], leaf.keys[i+1:leaf.count])
+	copy(leaf.values[i:], leaf.values[i+1:leaf.count])
+	leaf.count--
+
+	var zeroKey K
+	var zeroValue V
+	leaf.keys[leaf.count] = zeroKey
+	leaf.values[leaf.count] = zeroValue
+
+	m.count--
+	return true
+}
+
+// from returns an iterator over the entries starting at position i of a leaf
+func (m *OrderedMap[K, V]) from(node uint32, i int) iter.Seq2[K, *V] {
+	return func(yield func(K, *V) bool) {
+		for {
+			leaf := &m.leaves[node]
+			for ; i < int(leaf.count); i++ {
+				if !yield(leaf.keys[i], &leaf.values[i]) {
+					return
+				}
+			}
+
+			if leaf.next == 0 {
+				return
+			}
+			node, i = leaf.next-1, 0
+		}
+	}
+}
+
+// Iter returns an iterator over the keys and pointers to the values of every
+// entry in ascending key order. The map must not be modified during
+// iteration.
+func (m *OrderedMap[K, V]) Iter() iter.Seq2[K, *V] {
+	return m.from(0, 0)
+}
+
+// Backward returns an iterator over the entries in descending key order
+func (m *OrderedMap[K, V]) Backward() iter.Seq2[K, *V] {
+	return func(yield func(K, *V) bool) {
+		for node := m.tail + 1; node != 0; node = m.leaves[node-1].prev {
+			leaf := &m.leaves[node-1]
+			for i := int(leaf.count) - 1; i >= 0; i-- {
+				if !yield(leaf.keys[i], &leaf.values[i]) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Seek returns an iterator over the entries with keys greater than or equal
+// to key, in ascending order
+func (m *OrderedMap[K, V]) Seek(key K) iter.Seq2[K, *V] {
+	node, i := m.descend(key, nil)
+	return m.from(node, i)
+}
+
+// Range returns an iterator over the entries with keys in [lo, hi), in
+// ascending order
+func (m *OrderedMap[K, V]) Range(lo, hi K) iter.Seq2[K, *V] {
+	return func(yield func(K, *V) bool) {
+		for key, value := range m.Seek(lo) {
+			if m.cmp(key, hi) >= 0 || !yield(key, value) {
+				return
+			}
+		}
+	}
+}
+
+// Min returns the entry with the smallest key
+func (m *OrderedMap[K, V]) Min() (K, *V, bool) {
+	for key, value := range m.Iter() {
+		return key, value, true
+	}
+
+	var zero K
+	return zero, nil, false
+}
+
+// Max returns the entry with the largest key
+func (m *OrderedMap[K, V]) Max() (K, *V, bool) {
+	for key, value := range m.Backward() {
+		return key, value, true
+	}
+
+	var zero K
+	return zero, nil, false
+}
+
+// Compact rebuilds the tree with full leaves, dropping the space left behind
+// by deleted entries
+func (m *OrderedMap[K, V]) Compact() {
+	leafCount := max(1, (m.count+orderedNodeSize-1)/orderedNodeSize)
+	leaves := make([]orderedLeaf[K, V], 0, leafCount)
+
+	for key, value := range m.Iter() {
+		if len(leaves) == 0 || leaves[len(leaves)-1].count == orderedNodeSize {
+			leaves = append(leaves, orderedLeaf[K, V]{})
+		}
+
+		leaf := &leaves[len(leaves)-1]
+		leaf.keys[leaf.count] = key
+		leaf.values[leaf.count] = *value
+		leaf.count++
+	}
+
+	if len(leaves) == 0 {
+		leaves = append(leaves, orderedLeaf[K, V]{})
+	}
+	for i := range leaves {
+		leaves[i].prev = uint32(i)
+		if i+1 < len(leaves) {
+			leaves[i].next = uint32(i + 2)
+		}
+	}
+
+	// Build the inner levels bottom up, tracking the smallest key below
+	// every node for the separators
+	var inners []orderedInner[K]
+	level := make([]uint32, len(leaves))
+	lows := make([]K, len(leaves))
+	for i := range leaves {
+		level[i] = uint32(i)
+		lows[i] = leaves[i].keys[0]
+	}
+
+	height := 0
+	for len(level) > 1 {
+		var nextLevel []uint32
+		var nextLows []K
+
+		for start := 0; start < len(level); start += orderedNodeSize {
+			end := min(start+orderedNodeSize, len(level))
+
+			inner := orderedInner[K]{count: int32(end - start)}
+			copy(inner.children[:], level[start:end])
+			copy(inner.keys[:], lows[start+1:end])
+
+			inners = append(inners, inner)
+			nextLevel = append(nextLevel, uint32(len(inners)-1))
+			nextLows = append(nextLows, lows[start])
+		}
+
+		level, lows = nextLevel, nextLows
+		height++
+	}
+
+	m.leaves = leaves
+	m.inners = inners
+	m.root = level[0]
+	m.height = height
+	m.tail = uint32(len(leaves) - 1)
+}
+
+// WriteTo writes the map as raw memory: a header followed by the leaves and
+// the inner nodes. Like FixedBlockMap.WriteTo, the key and value types must
+// not contain pointers, and ReadFrom requires the same types and byte order.
+func (m *OrderedMap[K, V]) WriteTo(w io.Writer) (int64, error) {
+	leaves, inners := sliceBytes(m.leaves), sliceBytes(m.inners)
+	checksum := crc32.Update(crc32.Checksum(leaves, castagnoliTable), castagnoliTable, inners)
+
+	var header [orderedHeaderSize]byte
+	copy(header[0:4], orderedMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], orderedVersion)
+	binary.LittleEndian.PutUint64(header[8:16], m.count)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(len(m.leaves)))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(m.inners)))
+	binary.LittleEndian.PutUint32(header[32:36], m.root)
+	binary.LittleEndian.PutUint32(header[36:40], uint32(m.height))
+	binary.LittleEndian.PutUint32(header[40:44], uint32(unsafe.Sizeof(orderedLeaf[K, V]{})))
+	binary.LittleEndian.PutUint32(header[44:48], uint32(unsafe.Sizeof(orderedInner[K]{})))
+	binary.LittleEndian.PutUint32(header[48:52], checksum)
+	binary.LittleEndian.PutUint32(header[52:56], crc32.Checksum(header[0:52], castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	for _, data := range [][]byte{leaves, inners} {
+		written, err = w.Write(data)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// ReadFrom replaces the map with data written by WriteTo. The compare function
+// is not part of the data, so the map must be created with the same one
+// before reading into it. The structure of the tree and the order of the keys
+// are verified, and the map is left untouched when an error is returned.
+func (m *OrderedMap[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	if m.cmp == nil {
+		return 0, errors.New("ordered map has no compare function: create it with NewOrderedMap or NewOrderedMapFunc")
+	}
+
+	var header [orderedHeaderSize]byte
+	read, err := io.ReadFull(r, header[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	if [4]byte(header[0:4]) != orderedMagic {
+		return total, errors.New("invalid ordered map data: bad magic")
+	}
+	if crc32.Checksum(header[0:52], castagnoliTable) != binary.LittleEndian.Uint32(header[52:56]) {
+		return total, fmt.Errorf("invalid ordered map data: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(header[4:8]); version != orderedVersion {
+		return total, fmt.Errorf("invalid ordered map data: unsupported version %d", version)
+	}
+	if size := binary.LittleEndian.Uint32(header[40:44]); size != uint32(unsafe.Sizeof(orderedLeaf[K, V]{})) {
+		return total, fmt.Errorf("invalid ordered map data: leaf size %d does not match %d", size, unsafe.Sizeof(orderedLeaf[K, V]{}))
+	}
+	if size := binary.LittleEndian.Uint32(header[44:48]); size != uint32(unsafe.Sizeof(orderedInner[K]{})) {
+		return total, fmt.Errorf("invalid ordered map data: inner node size %d does not match %d", size, unsafe.Sizeof(orderedInner[K]{}))
+	}
+
+	leafCount := binary.LittleEndian.Uint64(header[16:24])
+	innerCount := binary.LittleEndian.Uint64(header[24:32])
+	if leafCount > math.MaxUint32 || innerCount > math.MaxUint32 {
+		return total, fmt.Errorf("invalid ordered map data: %d leaves and %d inner nodes cannot be indexed", leafCount, innerCount)
+	}
+
+	loaded := OrderedMap[K, V]{
+		cmp:    m.cmp,
+		root:   binary.LittleEndian.Uint32(header[32:36]),
+		height: int(binary.LittleEndian.Uint32(header[36:40])),
+		count:  binary.LittleEndian.Uint64(header[8:16]),
+	}
+
+	var checksum uint32
+	loaded.leaves, read, err = readOrderedNodes[orderedLeaf[K, V]](r, leafCount, &checksum)
+	total += int64(read)
+	if err != nil {
+		return total, err
+	}
+	loaded.inners, read, err = readOrderedNodes[orderedInner[K]](r, innerCount, &checksum)
+	total += int64(read)
+	if err != nil {
+		return total, err
+	}
+	if checksum != binary.LittleEndian.Uint32(header[48:52]) {
+		return total, fmt.Errorf("invalid ordered map data: nodes %w", ErrChecksumMismatch)
+	}
+
+	if err := loaded.validate(); err != nil {
+		return total, err
+	}
+
+	*m = loaded
+	return total, nil
+}
+
+// readOrderedNodes reads count nodes, orderedReadNodes at a time so the array
+// only grows with the data actually read, and adds them to the checksum
+func readOrderedNodes[T any](r io.Reader, count uint64, checksum *uint32) ([]T, int, error) {
+	nodes := make([]T, 0, min(count, orderedReadNodes))
+	total := 0
+
+	for uint64(len(nodes)) < count {
+		n := int(min(count-uint64(len(nodes)), orderedReadNodes))
+		nodes = slices.Grow(nodes, n)[:len(nodes)+n]
+		data := sliceBytes(nodes[len(nodes)-n:])
+
+		read, err := io.ReadFull(r, data)
+		total += read
+		if err != nil {
+			return nil, total, unexpectedEOF(err)
+		}
+		*checksum = crc32.Update(*checksum, castagnoliTable, data)
+	}
+
+	return nodes, total, nil
+}
+
+// validate checks that every node index is in range, that the tree reaches
+// every leaf exactly once, that the leaf chain visits every leaf once in
+// ascending key order, and sets the tail
+func (m *OrderedMap[K, V]) validate() error {
+	if len(m.leaves) == 0 {
+		return fmt.Errorf("%w: no leaves", ErrCorruptMap)
+	}
+
+	// Walk the tree, checking every index and node size. Every node must be
+	// reached once, so a node that is its own child or shared by two parents
+	// is rejected instead of being walked over and over.
+	seenLeaves := make([]uint64, (len(m.leaves)+63)/64)
+	seenInners := make([]uint64, (len(m.inners)+63)/64)
+	seen := func(bitmap []uint64, node uint32) bool {
+		bit := uint64(1) << (node % 64)
+		if bitmap[node/64]&bit != 0 {
+			return true
+		}
+		bitmap[node/64] |= bit
+		return false
+	}
+
+	var reachedLeaves int
+	var walk func(node uint32, level int) error
+	walk = func(node uint32, level int) error {
+		if level == 0 {
+			if node >= uint32(len(m.leaves)) || uint32(m.leaves[node].count) > orderedNodeSize {
+				return fmt.Errorf("%w: leaf %d is out of range or overfull", ErrCorruptMap, node)
+			}
+			if seen(seenLeaves, node) {
+				return fmt.Errorf("%w: leaf %d is reached twice", ErrCorruptMap, node)
+			}
+			reachedLeaves++
+			return nil
+		}
+
+		if node >= uint32(len(m.inners)) || m.inners[node].count < 1 || m.inners[node].count > orderedNodeSize {
+			return fmt.Errorf("%w: inner node %d is out of range or has a bad size", ErrCorruptMap, node)
+		}
+		if seen(seenInners, node) {
+			return fmt.Errorf("%w: inner node %d is reached twice", ErrCorruptMap, node)
+		}
+		for _, child := range m.inners[node].children[:m.inners[node].count] {
+			if err := walk(child, level-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if m.height > 64 {
+		return fmt.Errorf("%w: height %d", ErrCorruptMap, m.height)
+	}
+	if err := walk(m.root, m.height); err != nil {
+		return err
+	}
+	if reachedLeaves != len(m.leaves) {
+		return fmt.Errorf("%w: the tree reaches %d of %d leaves", ErrCorruptMap, reachedLeaves, len(m.leaves))
+	}
+
+	// Follow the leaf chain from the first leaf
+	var count uint64
+	var visited uint64
+	var last *K
+	prev := uint32(0)
+	for node := uint32(1); node != 0; node = m.leaves[node-1].next {
+		if node > uint32(len(m.leaves)) || visited == uint64(len(m.leaves)) || m.leaves[node-1].prev != prev {
+			return fmt.Errorf("%w: leaf chain is broken at leaf %d", ErrCorruptMap, node-1)
+		}
+
+		leaf := &m.leaves[node-1]
+		for i := range leaf.keys[:leaf.count] {
+			if last != nil && m.cmp(*last, leaf.keys[i]) >= 0 {
+				return fmt.Errorf("%w: keys of leaf %d are out of order", ErrCorruptMap, node-1)
+			}
+			last = &leaf.keys[i]
+		}
+
+		count += uint64(leaf.count)
+		visited++
+		prev = node
+		m.tail = node - 1
+	}
+
+	if visited != uint64(len(m.leaves)) || count != m.count {
+		return fmt.Errorf("%w: leaf chain holds %d of %d leaves and %d of %d entries", ErrCorruptMap, visited, len(m.leaves), count, m.count)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package collections
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// orderedKey builds a key that sorts by n
+func orderedKey(n uint64) FixedBlockKey {
+	var key FixedBlockKey
+	binary.BigEndian.PutUint64(key[0:8], n)
+	return key
+}
+
+// collectKeys returns the keys of an iterator in order
+func collectKeys[K, V any](seq func(yield func(K, V) bool)) []K {
+	var keys []K
+	for key := range seq {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestOrderedMap_PutGetDelete(t *testing.T) {
+	m := NewOrderedMapFunc[int, int](cmp.Compare[int])
+	reference := make(map[int]int)
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 20000; i++ {
+		key := rng.IntN(5000)
+		if rng.IntN(4) == 0 {
+			_, found := reference[key]
+			assert.Equal(t, found, m.Delete(key))
+			delete(reference, key)
+		} else {
+			m.Put(key, i)
+			reference[key] = i
+		}
+	}
+
+	assert.Equal(t, uint64(len(reference)), m.Len())
+	for key, expected := range reference {
+		value, found := m.Get(key)
+		require.True(t, found)
+		require.Equal(t, expected, *value)
+	}
+	_, found := m.Get(-1)
+	assert.False(t, found)
+
+	// Iteration is sorted in both directions
+	keys := collectKeys(m.Iter())
+	assert.Len(t, keys, len(reference))
+	assert.True(t, slices.IsSorted(keys))
+
+	backward := collectKeys(m.Backward())
+	slices.Reverse(backward)
+	assert.Equal(t, keys, backward)
+
+	require.NoError(t, m.validate())
+}
+
+func TestOrderedMap_SeekAndRange(t *testing.T) {
+	m := NewOrderedMapFunc[int, string](cmp.Compare[int])
+	for i := 0; i < 1000; i += 10 {
+		m.Put(i, "")
+	}
+
+	assert.Equal(t, []int{500, 510, 520}, collectKeys(m.Range(495, 521)))
+	assert.Equal(t, []int{500, 510}, collectKeys(m.Range(500, 520)))
+	assert.Empty(t, collectKeys(m.Range(501, 509)))
+	assert.Equal(t, []int{980, 990}, collectKeys(m.Seek(971)))
+	assert.Empty(t, collectKeys(m.Seek(991)))
+
+	key, _, found := m.Min()
+	require.True(t, found)
+	assert.Equal(t, 0, key)
+
+	key, _, found = m.Max()
+	require.True(t, found)
+	assert.Equal(t, 990, key)
+
+	// Emptied leaves are skipped
+	for i := 900; i < 1000; i += 10 {
+		require.True(t, m.Delete(i))
+	}
+	key, _, _ = m.Max()
+	assert.Equal(t, 890, key)
+	assert.Equal(t, []int{890}, collectKeys(m.Range(885, 2000)))
+
+	_, _, found = NewOrderedMapFunc[int, string](cmp.Compare[int]).Min()
+	assert.False(t, found)
+}
+
+func TestOrderedMap_FixedBlockKeys(t *testing.T) {
+	m := NewOrderedMap[uint64]()
+	for _, n := range rand.New(rand.NewPCG(3, 4)).Perm(1000) {
+		m.Put(orderedKey(uint64(n)), uint64(n))
+	}
+
+	var values []uint64
+	for _, value := range m.Range(orderedKey(100), orderedKey(105)) {
+		values = append(values, *value)
+	}
+	assert.Equal(t, []uint64{100, 101, 102, 103, 104}, values)
+}
+
+func TestOrderedMap_Compact(t *testing.T) {
+	m := NewOrderedMapFunc[int, int](cmp.Compare[int])
+	for i := 0; i < 5000; i++ {
+		m.Put(i, i)
+	}
+	for i := 0; i < 5000; i++ {
+		if i%7 != 0 {
+			m.Delete(i)
+		}
+	}
+
+	before := collectKeys(m.Iter())
+	leaves := len(m.leaves)
+
+	m.Compact()
+	require.NoError(t, m.validate())
+	assert.Less(t, len(m.leaves), leaves/3)
+	assert.Equal(t, before, collectKeys(m.Iter()))
+	for _, key := range before {
+		_, found := m.Get(key)
+		require.True(t, found)
+	}
+
+	// The compacted tree keeps accepting inserts
+	for i := 0; i < 5000; i++ {
+		m.Put(i, i)
+	}
+	assert.Equal(t, uint64(5000), m.Len())
+	require.NoError(t, m.validate())
+
+	empty := NewOrderedMapFunc[int, int](cmp.Compare[int])
+	empty.Compact()
+	empty.Put(1, 1)
+	assert.Equal(t, []int{1}, collectKeys(empty.Iter()))
+}
+
+func TestOrderedMap_WriteTo(t *testing.T) {
+	m := NewOrderedMap[uint64]()
+	for i := uint64(0); i < 3000; i++ {
+		m.Put(orderedKey(i*3), i)
+	}
+
+	var buf bytes.Buffer
+	written, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	loaded := NewOrderedMap[uint64]()
+	read, err := loaded.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, collectKeys(m.Iter()), collectKeys(loaded.Iter()))
+	key, _, _ := loaded.Max()
+	assert.Equal(t, orderedKey(2999*3), key)
+
+	corrupted := bytes.Clone(data)
+	corrupted[orderedHeaderSize+20] ^= 0xFF
+	_, err = loaded.ReadFrom(bytes.NewReader(corrupted))
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// A different order is detected
+	reversed := NewOrderedMapFunc[FixedBlockKey, uint64](func(a, b FixedBlockKey) int {
+		return bytes.Compare(b[:], a[:])
+	})
+	_, err = reversed.ReadFrom(bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrCorruptMap)
+
+	var zero OrderedMap[FixedBlockKey, uint64]
+	_, err = zero.ReadFrom(bytes.NewReader(data))
+	assert.Error(t, err)
+}
+
+func TestOrderedMap_ReadFromCorruptStructure(t *testing.T) {
+	m := NewOrderedMap[uint64]()
+	for i := uint64(0); i < 3000; i++ {
+		m.Put(orderedKey(i), i)
+	}
+	require.Greater(t, m.height, 0)
+
+	var buf bytes.Buffer
+	_, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+
+	// A corrupt leaf count fails on the missing data instead of allocating
+	// the announced leaves
+	huge := bytes.Clone(buf.Bytes())
+	binary.LittleEndian.PutUint64(huge[16:24], 1<<31)
+	binary.LittleEndian.PutUint32(huge[52:56], crc32.Checksum(huge[0:52], castagnoliTable))
+	loaded := NewOrderedMap[uint64]()
+	_, err = loaded.ReadFrom(bytes.NewReader(huge))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// An inner node that is its own child is rejected after one visit
+	cyclic := *m
+	cyclic.inners = slices.Clone(m.inners)
+	cyclic.height = 64
+	for i := range cyclic.inners[cyclic.root].children {
+		cyclic.inners[cyclic.root].children[i] = cyclic.root
+	}
+	cyclic.inners[cyclic.root].count = orderedNodeSize
+	assert.ErrorIs(t, cyclic.validate(), ErrCorruptMap)
+
+	// So is a leaf reached twice, and a leaf the tree never reaches
+	small := NewOrderedMap[uint64]()
+	for i := uint64(0); i < 100; i++ {
+		small.Put(orderedKey(i), i)
+	}
+	require.Equal(t, 1, small.height)
+
+	shared := *small
+	shared.inners = slices.Clone(small.inners)
+	shared.inners[shared.root].children[1] = shared.inners[shared.root].children[0]
+	assert.ErrorContains(t, shared.validate(), "reached twice")
+
+	dropped := *small
+	dropped.inners = slices.Clone(small.inners)
+	dropped.inners[dropped.root].count--
+	assert.ErrorContains(t, dropped.validate(), "reaches")
+}
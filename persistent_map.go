@@ -0,0 +1,474 @@
+package collections
+
+import (
+	"encoding/binary"
+	"iter"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// persistentBits is the number of key bits consumed by every level
+	persistentBits = 5
+
+	persistentFanout = 1 << persistentBits
+)
+
+// ChangeKind tells how an entry differs between two versions of a map
+type ChangeKind uint8
+
+const (
+	ChangeAdded    ChangeKind = iota + 1 // the key is only in the newer version
+	ChangeRemoved                        // the key is only in the older version
+	ChangeModified                       // the key is in both with different values
+)
+
+// String returns the name of the change kind
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	default:
+		return "unknown"
+	}
+}
+
+// Change describes how an entry differs between two versions of a map. Old is
+// the zero value for added entries and New for removed ones.
+type Change[V any] struct {
+	Kind ChangeKind
+	Key  FixedBlockKey
+	Old  V
+	New  V
+}
+
+// persistentEdit marks the nodes created by one bulk build, which may be
+// modified in place until the build finishes
+type persistentEdit struct {
+	_ byte // non-zero size, so every edit has its own address
+}
+
+// persistentNode is a node of a compressed hash array mapped trie (CHAMP).
+// Every fragment of 5 key bits selects one of 32 positions, which holds an
+// entry, a child node, or nothing. The two bitmaps record which positions are
+// used, and the slices hold only the used positions in order.
+type persistentNode[V any] struct {
+	dataMap  uint32
+	nodeMap  uint32
+	keys     []FixedBlockKey
+	values   []V
+	children []*persistentNode[V]
+	edit     *persistentEdit
+}
+
+// PersistentMap is an immutable map of FixedBlockKeys. Put and Delete return a
+// new version of the map and leave the original unchanged, copying only the
+// O(log n) nodes on the path to the modified entry; every other node is shared
+// between the versions. Versions are therefore cheap to keep and to hand to
+// concurrent readers, and Diff skips the subtrees two versions share.
+//
+// The trie is kept in canonical form, so maps holding the same keys have the
+// same shape regardless of the order of the updates.
+type PersistentMap[V any] struct {
+	root  *persistentNode[V]
+	count uint64
+}
+
+// NewPersistentMap returns an empty map
+func NewPersistentMap[V any]() *PersistentMap[V] {
+	return &PersistentMap[V]{root: &persistentNode[V]{}}
+}
+
+// PersistentMapFrom builds a persistent map holding the entries of m. The
+// nodes are modified in place while they are built, so this is much cheaper
+// than calling Put for every entry.
+func PersistentMapFrom[V any](m *FixedBlockMap[V]) *PersistentMap[V] {
+	edit := &persistentEdit{}
+	root := &persistentNode[V]{edit: edit}
+
+	var count uint64
+	for key, value := range m.Iter() {
+		var added bool
+		root, added = root.put(key, *value, 0, edit)
+		if added {
+			count++
+		}
+	}
+
+	// Ending the edit freezes the nodes
+	root.freeze(edit)
+	return &PersistentMap[V]{root: root, count: count}
+}
+
+// freeze clears the edit of the nodes created by it
+func (n *persistentNode[V]) freeze(edit *persistentEdit) {
+	if n.edit != edit {
+		return
+	}
+
+	n.edit = nil
+	for _, child := range n.children {
+		child.freeze(edit)
+	}
+}
+
+// ToFixedBlockMap copies the entries into a new FixedBlockMap with room to
+// grow before CollectInfo recommends it
+func (p *PersistentMap[V]) ToFixedBlockMap() *FixedBlockMap[V] {
+	m := NewFixedBlockMap[V](p.count + p.count/3 + 1)
+	for key, value := range p.Iter() {
+		// The capacity leaves a quarter of the slots empty, so Put cannot fail
+		_ = m.Put(key, value)
+	}
+
+	return m
+}
+
+// Len returns the number of entries in the map
+func (p *PersistentMap[V]) Len() uint64 {
+	return p.count
+}
+
+// persistentFragment returns the 5 bits of the key that select a position at
+// the given bit offset
+func persistentFragment(key *FixedBlockKey, shift uint) uint32 {
+	lo := binary.LittleEndian.Uint64(key[0:8])
+	hi := binary.LittleEndian.Uint64(key[8:16])
+
+	if shift >= 64 {
+		return uint32(hi>>(shift-64)) & (persistentFanout - 1)
+	}
+
+	return uint32(lo>>shift|hi<<(64-shift)) & (persistentFanout - 1)
+}
+
+// persistentIndex returns the position of bit among the used positions
+func persistentIndex(bitmap, bit uint32) int {
+	return bits.OnesCount32(bitmap & (bit - 1))
+}
+
+// Get retrieves the value for a key
+func (p *PersistentMap[V]) Get(key FixedBlockKey) (V, bool) {
+	node := p.root
+	for shift := uint(0); ; shift += persistentBits {
+		bit := uint32(1) << persistentFragment(&key, shift)
+
+		if node.dataMap&bit != 0 {
+			i := persistentIndex(node.dataMap, bit)
+			if node.keys[i] == key {
+				return node.values[i], true
+			}
+			break
+		}
+		if node.nodeMap&bit == 0 {
+			break
+		}
+
+		node = node.children[persistentIndex(node.nodeMap, bit)]
+	}
+
+	var zero V
+	return zero, false
+}
+
+// Put returns a version of the map in which key has the given value
+func (p *PersistentMap[V]) Put(key FixedBlockKey, value V) *PersistentMap[V] {
+	root, added := p.root.put(key, value, 0, nil)
+
+	count := p.count
+	if added {
+		count++
+	}
+
+	return &PersistentMap[V]{root: root, count: count}
+}
+
+// Delete returns a version of the map without key. The map itself is
+// returned when it does not hold the key.
+func (p *PersistentMap[V]) Delete(key FixedBlockKey) *PersistentMap[V] {
+	root, removed := p.root.delete(key, 0, nil)
+	if !removed {
+		return p
+	}
+
+	return &PersistentMap[V]{root: root, count: p.count - 1}
+}
+
+// editable returns a node that may be modified for the given edit: the node
+// itself if the edit created it, or a copy otherwise
+func (n *persistentNode[V]) editable(edit *persistentEdit) *persistentNode[V] {
+	if edit != nil && n.edit == edit {
+		return n
+	}
+
+	return &persistentNode[V]{
+		dataMap:  n.dataMap,
+		nodeMap:  n.nodeMap,
+		keys:     slices.Clone(n.keys),
+		values:   slices.Clone(n.values),
+		children: slices.Clone(n.children),
+		edit:     edit,
+	}
+}
+
+// put returns the node with key set to value and whether the key was added
+func (n *persistentNode[V]) put(key FixedBlockKey, value V, shift uint, edit *persistentEdit) (*persistentNode[V], bool) {
+	bit := uint32(1) << persistentFragment(&key, shift)
+
+	switch {
+	case n.dataMap&bit != 0:
+		i := persistentIndex(n.dataMap, bit)
+		if n.keys[i] == key {
+			node := n.editable(edit)
+			node.values[i] = value
+			return node, false
+		}
+
+		// Two keys share the position, so push both down into a new child
+		child := newPersistentPair(n.keys[i], n.values[i], key, value, shift+persistentBits, edit)
+
+		node := n.editable(edit)
+		node.keys = slices.Delete(node.keys, i, i+1)
+		node.values = slices.Delete(node.values, i, i+1)
+		node.dataMap &^= bit
+		node.nodeMap |= bit
+		node.children = slices.Insert(node.children, persistentIndex(node.nodeMap, bit), child)
+		return node, true
+
+	case n.nodeMap&bit != 0:
+		j := persistentIndex(n.nodeMap, bit)
+		child, added := n.children[j].put(key, value, shift+persistentBits, edit)
+
+		node := n.editable(edit)
+		node.children[j] = child
+		return node, added
+
+	default:
+		i := persistentIndex(n.dataMap, bit)
+
+		node := n.editable(edit)
+		node.keys = slices.Insert(node.keys, i, key)
+		node.values = slices.Insert(node.values, i, value)
+		node.dataMap |= bit
+		return node, true
+	}
+}
+
+// newPersistentPair returns a node holding two different keys, nested as
+// deep as needed for their fragments to differ
+func newPersistentPair[V any](key1 FixedBlockKey, value1 V, key2 FixedBlockKey, value2 V, shift uint, edit *persistentEdit) *persistentNode[V] {
+	fragment1, fragment2 := persistentFragment(&key1, shift), persistentFragment(&key2, shift)
+
+	if fragment1 == fragment2 {
+		child := newPersistentPair(key1, value1, key2, value2, shift+persistentBits, edit)
+		return &persistentNode[V]{
+			nodeMap:  1 << fragment1,
+			children: []*persistentNode[V]{child},
+			edit:     edit,
+		}
+	}
+
+	if fragment1 > fragment2 {
+		key1, value1, key2, value2 = key2, value2, key1, value1
+	}
+
+	return &persistentNode[V]{
+		dataMap: 1<<fragment1 | 1<<fragment2,
+		keys:    []FixedBlockKey{key1, key2},
+		values:  []V{value1, value2},
+		edit:    edit,
+	}
+}
+
+// delete returns the node without key and whether the key was found. A child
+// left with a single entry is replaced by that entry, which keeps the trie
+// canonical.
+func (n *persistentNode[V]) delete(key FixedBlockKey, shift uint, edit *persistentEdit) (*persistentNode[V], bool) {
+	bit := uint32(1) << persistentFragment(&key, shift)
+
+	switch {
+	case n.dataMap&bit != 0:
+		i := persistentIndex(n.dataMap, bit)
+		if n.keys[i] != key {
+			return n, false
+		}
+
+		node := n.editable(edit)
+		node.keys = slices.Delete(node.keys, i, i+1)
+		node.values = slices.Delete(node.values, i, i+1)
+		node.dataMap &^= bit
+		return node, true
+
+	case n.nodeMap&bit != 0:
+		j := persistentIndex(n.nodeMap, bit)
+		child, removed := n.children[j].delete(key, shift+persistentBits, edit)
+		if !removed {
+			return n, false
+		}
+
+		node := n.editable(edit)
+		if child.nodeMap == 0 && len(child.keys) == 1 {
+			// Inline the last entry of the child
+			node.children = slices.Delete(node.children, j, j+1)
+			node.nodeMap &^= bit
+
+			i := persistentIndex(node.dataMap, bit)
+			node.keys = slices.Insert(node.keys, i, child.keys[0])
+			node.values = slices.Insert(node.values, i, child.values[0])
+			node.dataMap |= bit
+		} else {
+			node.children[j] = child
+		}
+		return node, true
+
+	default:
+		return n, false
+	}
+}
+
+// Iter returns an iterator over every entry in the map. The map is immutable,
+// so it can be iterated while other versions are being created.
+func (p *PersistentMap[V]) Iter() iter.Seq2[FixedBlockKey, V] {
+	return func(yield func(FixedBlockKey, V) bool) {
+		p.root.each(yield)
+	}
+}
+
+// each calls yield for every entry below the node until it returns false
+func (n *persistentNode[V]) each(yield func(FixedBlockKey, V) bool) bool {
+	for i := range n.keys {
+		if !yield(n.keys[i], n.values[i]) {
+			return false
+		}
+	}
+
+	for _, child := range n.children {
+		if !child.each(yield) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Diff returns an iterator over the changes that turn the map into other:
+// entries only in other are added, entries only in the map are removed, and
+// entries whose values differ according to eq are modified. Subtrees shared
+// by both versions are skipped without being visited, so diffing a version
+// against one derived from it costs time proportional to the changes.
+func (p *PersistentMap[V]) Diff(other *PersistentMap[V], eq func(a, b *V) bool) iter.Seq[Change[V]] {
+	return func(yield func(Change[V]) bool) {
+		diffPersistentNodes(p.root, other.root, eq, yield)
+	}
+}
+
+// diffPersistentNodes yields the changes from node a to node b at the same
+// position of two tries
+func diffPersistentNodes[V any](a, b *persistentNode[V], eq func(a, b *V) bool, yield func(Change[V]) bool) bool {
+	if a == b {
+		return true
+	}
+
+	for fragment := 0; fragment < persistentFanout; fragment++ {
+		bit := uint32(1) << fragment
+
+		switch {
+		case a.dataMap&bit != 0 && b.dataMap&bit != 0:
+			i, j := persistentIndex(a.dataMap, bit), persistentIndex(b.dataMap, bit)
+			if a.keys[i] == b.keys[j] {
+				if !eq(&a.values[i], &b.values[j]) &&
+					!yield(Change[V]{Kind: ChangeModified, Key: a.keys[i], Old: a.values[i], New: b.values[j]}) {
+					return false
+				}
+			} else if !yield(Change[V]{Kind: ChangeRemoved, Key: a.keys[i], Old: a.values[i]}) ||
+				!yield(Change[V]{Kind: ChangeAdded, Key: b.keys[j], New: b.values[j]}) {
+				return false
+			}
+
+		case a.nodeMap&bit != 0 && b.nodeMap&bit != 0:
+			if !diffPersistentNodes(a.children[persistentIndex(a.nodeMap, bit)], b.children[persistentIndex(b.nodeMap, bit)], eq, yield) {
+				return false
+			}
+
+		case a.dataMap&bit != 0 && b.nodeMap&bit != 0:
+			// Compare the entry against the subtree that replaced it
+			i := persistentIndex(a.dataMap, bit)
+			single := &persistentNode[V]{keys: a.keys[i : i+1], values: a.values[i : i+1]}
+			if !diffEntries(single, b.children[persistentIndex(b.nodeMap, bit)], eq, yield) {
+				return false
+			}
+
+		case a.nodeMap&bit != 0 && b.dataMap&bit != 0:
+			j := persistentIndex(b.dataMap, bit)
+			single := &persistentNode[V]{keys: b.keys[j : j+1], values: b.values[j : j+1]}
+			if !diffEntries(a.children[persistentIndex(a.nodeMap, bit)], single, eq, yield) {
+				return false
+			}
+
+		case a.dataMap&bit != 0:
+			i := persistentIndex(a.dataMap, bit)
+			if !yield(Change[V]{Kind: ChangeRemoved, Key: a.keys[i], Old: a.values[i]}) {
+				return false
+			}
+
+		case b.dataMap&bit != 0:
+			j := persistentIndex(b.dataMap, bit)
+			if !yield(Change[V]{Kind: ChangeAdded, Key: b.keys[j], New: b.values[j]}) {
+				return false
+			}
+
+		case a.nodeMap&bit != 0:
+			if !a.children[persistentIndex(a.nodeMap, bit)].each(func(key FixedBlockKey, value V) bool {
+				return yield(Change[V]{Kind: ChangeRemoved, Key: key, Old: value})
+			}) {
+				return false
+			}
+
+		case b.nodeMap&bit != 0:
+			if !b.children[persistentIndex(b.nodeMap, bit)].each(func(key FixedBlockKey, value V) bool {
+				return yield(Change[V]{Kind: ChangeAdded, Key: key, New: value})
+			}) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// diffEntries yields the changes between two small subtrees at different
+// depths by comparing their entries directly
+func diffEntries[V any](a, b *persistentNode[V], eq func(a, b *V) bool, yield func(Change[V]) bool) bool {
+	before := make(map[FixedBlockKey]V)
+	a.each(func(key FixedBlockKey, value V) bool {
+		before[key] = value
+		return true
+	})
+
+	if !b.each(func(key FixedBlockKey, value V) bool {
+		old, found := before[key]
+		if !found {
+			return yield(Change[V]{Kind: ChangeAdded, Key: key, New: value})
+		}
+
+		delete(before, key)
+		if eq(&old, &value) {
+			return true
+		}
+		return yield(Change[V]{Kind: ChangeModified, Key: key, Old: old, New: value})
+	}) {
+		return false
+	}
+
+	for key, value := range before {
+		if !yield(Change[V]{Kind: ChangeRemoved, Key: key, Old: value}) {
+			return false
+		}
+	}
+
+	return true
+}
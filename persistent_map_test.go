@@ -0,0 +1,181 @@
+package collections
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func intEqual(a, b *int) bool {
+	return *a == *b
+}
+
+// collectChanges returns the changes of a diff by key
+func collectChanges(p, other *PersistentMap[int]) map[FixedBlockKey]Change[int] {
+	changes := make(map[FixedBlockKey]Change[int])
+	for change := range p.Diff(other, intEqual) {
+		changes[change.Key] = change
+	}
+	return changes
+}
+
+func TestPersistentMap_Versions(t *testing.T) {
+	empty := NewPersistentMap[int]()
+	v1 := empty.Put(bloomKey("key", 1), 1).Put(bloomKey("key", 2), 2)
+	v2 := v1.Put(bloomKey("key", 1), 10).Delete(bloomKey("key", 2))
+
+	// Every version keeps its own entries
+	assert.Equal(t, uint64(0), empty.Len())
+	assert.Equal(t, uint64(2), v1.Len())
+	assert.Equal(t, uint64(1), v2.Len())
+
+	value, found := v1.Get(bloomKey("key", 1))
+	require.True(t, found)
+	assert.Equal(t, 1, value)
+
+	value, found = v2.Get(bloomKey("key", 1))
+	require.True(t, found)
+	assert.Equal(t, 10, value)
+
+	_, found = v2.Get(bloomKey("key", 2))
+	assert.False(t, found)
+
+	// Deleting a missing key returns the same version
+	assert.Same(t, v2, v2.Delete(bloomKey("key", 3)))
+}
+
+func TestPersistentMap_Random(t *testing.T) {
+	p := NewPersistentMap[int]()
+	reference := make(map[FixedBlockKey]int)
+
+	rng := rand.New(rand.NewPCG(7, 8))
+	for i := 0; i < 20000; i++ {
+		key := bloomKey("key", rng.IntN(3000))
+		if rng.IntN(3) == 0 {
+			p = p.Delete(key)
+			delete(reference, key)
+		} else {
+			p = p.Put(key, i)
+			reference[key] = i
+		}
+	}
+
+	assert.Equal(t, uint64(len(reference)), p.Len())
+	for key, expected := range reference {
+		value, found := p.Get(key)
+		require.True(t, found)
+		require.Equal(t, expected, value)
+	}
+
+	var iterated int
+	for key, value := range p.Iter() {
+		require.Equal(t, reference[key], value)
+		iterated++
+	}
+	assert.Equal(t, len(reference), iterated)
+
+	// The trie is canonical: the same entries added in another order, or
+	// built in bulk, produce a map with no differences
+	rebuilt := NewPersistentMap[int]()
+	for key, value := range reference {
+		rebuilt = rebuilt.Put(key, value)
+	}
+	assert.Empty(t, collectChanges(p, rebuilt))
+	assert.True(t, samePersistentShape(p.root, rebuilt.root))
+}
+
+// samePersistentShape reports whether two tries have the same nodes and entries
+func samePersistentShape(a, b *persistentNode[int]) bool {
+	if a.dataMap != b.dataMap || a.nodeMap != b.nodeMap {
+		return false
+	}
+	for i := range a.keys {
+		if a.keys[i] != b.keys[i] || a.values[i] != b.values[i] {
+			return false
+		}
+	}
+	for i := range a.children {
+		if !samePersistentShape(a.children[i], b.children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPersistentMap_StructuralSharing(t *testing.T) {
+	v1 := NewPersistentMap[int]()
+	for i := 0; i < 10000; i++ {
+		v1 = v1.Put(bloomKey("key", i), i)
+	}
+	v2 := v1.Put(bloomKey("key", 5), 500)
+
+	// Only the path to the modified entry is copied
+	shared := 0
+	for i, child := range v2.root.children {
+		if child == v1.root.children[i] {
+			shared++
+		}
+	}
+	assert.Equal(t, len(v1.root.children)-1, shared)
+}
+
+func TestPersistentMap_Diff(t *testing.T) {
+	v1 := NewPersistentMap[int]()
+	for i := 0; i < 1000; i++ {
+		v1 = v1.Put(bloomKey("key", i), i)
+	}
+
+	v2 := v1.Put(bloomKey("key", 1), 100).
+		Put(bloomKey("key", 2), 2).
+		Delete(bloomKey("key", 3)).
+		Put(bloomKey("new", 0), 0)
+
+	changes := collectChanges(v1, v2)
+	assert.Equal(t, map[FixedBlockKey]Change[int]{
+		bloomKey("key", 1): {Kind: ChangeModified, Key: bloomKey("key", 1), Old: 1, New: 100},
+		bloomKey("key", 3): {Kind: ChangeRemoved, Key: bloomKey("key", 3), Old: 3},
+		bloomKey("new", 0): {Kind: ChangeAdded, Key: bloomKey("new", 0), New: 0},
+	}, changes)
+
+	// Diffs against unrelated versions match too
+	other := NewPersistentMap[int]().Put(bloomKey("key", 1), 1).Put(bloomKey("other", 0), 0)
+	changes = collectChanges(other, v1)
+	assert.Len(t, changes, 1000)
+	assert.Equal(t, ChangeRemoved, changes[bloomKey("other", 0)].Kind)
+	assert.NotContains(t, changes, bloomKey("key", 1))
+	assert.Equal(t, ChangeAdded, changes[bloomKey("key", 2)].Kind)
+	assert.Equal(t, "added", ChangeAdded.String())
+
+	// The iterator stops early
+	var seen int
+	for range v1.Diff(other, intEqual) {
+		seen++
+		break
+	}
+	assert.Equal(t, 1, seen)
+}
+
+func TestPersistentMap_FixedBlockMap(t *testing.T) {
+	m := NewFixedBlockMap[int](2000)
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, m.Put(bloomKey("key", i), i))
+	}
+
+	p := PersistentMapFrom(m)
+	assert.Equal(t, uint64(1000), p.Len())
+
+	// Nodes built in bulk are frozen, so later versions copy them
+	updated := p.Put(bloomKey("key", 1), 100)
+	value, _ := p.Get(bloomKey("key", 1))
+	assert.Equal(t, 1, value)
+	assert.Len(t, collectChanges(p, updated), 1)
+
+	back := updated.ToFixedBlockMap()
+	assert.Equal(t, uint64(1000), back.Len())
+	assert.False(t, back.CollectInfo().RecommendGrow)
+	stored, found := back.Get(bloomKey("key", 1))
+	require.True(t, found)
+	assert.Equal(t, 100, *stored)
+}
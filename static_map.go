@@ -0,0 +1,482 @@
+package collections
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"iter"
+	"math"
+	"math/bits"
+	"slices"
+	"unsafe"
+)
+
+const (
+	// staticGamma is the number of bits per remaining key in every level.
+	// Larger values place more keys in the first levels at the cost of space.
+	staticGamma = 2.0
+
+	// staticMaxLevels bounds the number of levels. Distinct keys are all
+	// placed after a handful of levels, so only duplicates reach it.
+	staticMaxLevels = 64
+
+	// staticRankWords is the number of bit words covered by a rank sample
+	staticRankWords = 8
+
+	staticVersion    = 1
+	staticHeaderSize = 40
+
+	// staticReadSize is the number of bytes ReadFrom reads at a time, a
+	// multiple of 8
+	staticReadSize = 64 * 1024
+)
+
+var staticMagic = [4]byte{'F', 'B', 'S', 'M'}
+
+// staticLevel describes one level of the hash within the concatenated bits
+type staticLevel struct {
+	offset uint64 // position of the first bit of the level
+	size   uint64 // number of bits, a multiple of 64
+}
+
+// StaticMap is a read-only map built with a minimal perfect hash function in
+// the style of BBHash. Every key is hashed into a series of bit arrays until
+// it lands on a bit no other remaining key hits; the rank of that bit, the
+// number of set bits before it, is the index of the entry. Entries are stored
+// densely, without empty slots or probing, so a lookup reads a few bit words
+// and a single entry, whose key is compared to reject keys that are not in
+// the map. The hash costs about 3.7 bits per key.
+//
+// The serialized form written by WriteTo is laid out so that OpenStaticMap can
+// use it in place, for example from a memory-mapped file, without copying or
+// decoding. Like FixedBlockMap.WriteTo, it is raw memory, so the value type
+// must not contain pointers and the data must be read on a machine with the
+// same byte order.
+type StaticMap[V any] struct {
+	levels []staticLevel
+	words  []uint64 // bits of all levels
+	ranks  []uint64 // number of set bits before every staticRankWords words
+	keys   []FixedBlockKey
+	values []V
+}
+
+// staticPosition returns the bit a key hits in a level of the given size
+func staticPosition(key *FixedBlockKey, level int, size uint64) uint64 {
+	// Mix both halves of the key differently for every level, so keys that
+	// collide in one level are independent in the next
+	hash := binary.LittleEndian.Uint64(key[0:8]) ^ bits.RotateLeft64(binary.LittleEndian.Uint64(key[8:16]), level)
+	hash += uint64(level) * 0x9E3779B97F4A7C15
+	hash ^= hash >> 33
+	hash *= 0xFF51AFD7ED558CCD
+	hash ^= hash >> 33
+	hash *= 0xC4CEB9FE1A85EC53
+	hash ^= hash >> 33
+
+	position, _ := bits.Mul64(hash, size)
+	return position
+}
+
+// BuildStaticMap builds a StaticMap from the entries of an iterator, such as
+// FixedBlockMap.All. Keys must be unique.
+func BuildStaticMap[V any](entries iter.Seq2[FixedBlockKey, V]) (*StaticMap[V], error) {
+	var keys []FixedBlockKey
+	var values []V
+	for key, value := range entries {
+		keys = append(keys, key)
+		values = append(values, value)
+	}
+
+	remaining := make([]int, len(keys))
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	// Global bit position of every key
+	positions := make([]uint64, len(keys))
+
+	var levels []staticLevel
+	var words []uint64
+	for level := 0; len(remaining) > 0; level++ {
+		if level == staticMaxLevels {
+			return nil, unplacedKeysError(keys, remaining)
+		}
+
+		size := (uint64(float64(len(remaining))*staticGamma) + 63) &^ 63
+		seen := make([]uint64, size/64)
+		collided := make([]uint64, size/64)
+
+		for _, i := range remaining {
+			position := staticPosition(&keys[i], level, size)
+			if seen[position/64]&(1<<(position%64)) != 0 {
+				collided[position/64] |= 1 << (position % 64)
+			}
+			seen[position/64] |= 1 << (position % 64)
+		}
+
+		// Keep the bits hit by exactly one key
+		for w := range seen {
+			seen[w] &^= collided[w]
+		}
+
+		offset := uint64(len(words)) * 64
+		next := remaining[:0]
+		for _, i := range remaining {
+			position := staticPosition(&keys[i], level, size)
+			if seen[position/64]&(1<<(position%64)) != 0 {
+				positions[i] = offset + position
+			} else {
+				next = append(next, i)
+			}
+		}
+
+		remaining = next
+		levels = append(levels, staticLevel{offset: offset, size: size})
+		words = append(words, seen...)
+	}
+
+	sm := &StaticMap[V]{
+		levels: levels,
+		words:  words,
+		ranks:  staticRanks(words),
+		keys:   make([]FixedBlockKey, len(keys)),
+		values: make([]V, len(keys)),
+	}
+
+	for i, position := range positions {
+		index := sm.rank(position)
+		sm.keys[index] = keys[i]
+		sm.values[index] = values[i]
+	}
+
+	return sm, nil
+}
+
+// unplacedKeysError explains why keys could not be placed in any level
+func unplacedKeysError(keys []FixedBlockKey, remaining []int) error {
+	seen := make(map[FixedBlockKey]struct{}, len(remaining))
+	for _, i := range remaining {
+		if _, found := seen[keys[i]]; found {
+			return fmt.Errorf("cannot build static map: duplicate key %s", keys[i])
+		}
+		seen[keys[i]] = struct{}{}
+	}
+
+	return fmt.Errorf("cannot build static map: %d keys collide in every level", len(remaining))
+}
+
+// staticRankCount returns the number of rank samples for the given number of
+// bit words: one for every staticRankWords words and the total
+func staticRankCount(words uint64) uint64 {
+	ranks := words/staticRankWords + 1
+	if words%staticRankWords != 0 {
+		ranks++
+	}
+
+	return ranks
+}
+
+// staticRanks returns the number of set bits before every staticRankWords
+// words, followed by the total number of set bits
+func staticRanks(words []uint64) []uint64 {
+	ranks := make([]uint64, 0, staticRankCount(uint64(len(words))))
+
+	var count uint64
+	for w, word := range words {
+		if w%staticRankWords == 0 {
+			ranks = append(ranks, count)
+		}
+		count += uint64(bits.OnesCount64(word))
+	}
+
+	return append(ranks, count)
+}
+
+// rank returns the number of set bits before position
+func (sm *StaticMap[V]) rank(position uint64) uint64 {
+	word := position / 64
+	rank := sm.ranks[word/staticRankWords]
+
+	for w := word &^ (staticRankWords - 1); w < word; w++ {
+		rank += uint64(bits.OnesCount64(sm.words[w]))
+	}
+
+	return rank + uint64(bits.OnesCount64(sm.words[word]&(1<<(position%64)-1)))
+}
+
+// Get retrieves the value for a key. The value is shared with the map, which
+// may be backed by read-only memory, so it must not be modified.
+func (sm *StaticMap[V]) Get(key FixedBlockKey) (*V, bool) {
+	for level, l := range sm.levels {
+		position := l.offset + staticPosition(&key, level, l.size)
+		if sm.words[position/64]&(1<<(position%64)) == 0 {
+			continue
+		}
+
+		// A stored key is placed in the first level where its bit is set,
+		// so there is no need to look further
+		index := sm.rank(position)
+		if sm.keys[index] != key {
+			return nil, false
+		}
+
+		return &sm.values[index], true
+	}
+
+	return nil, false
+}
+
+// Len returns the number of entries in the map
+func (sm *StaticMap[V]) Len() uint64 {
+	return uint64(len(sm.keys))
+}
+
+// Iter returns an iterator over the keys and pointers to the values of every
+// entry, in the order of their index
+func (sm *StaticMap[V]) Iter() iter.Seq2[FixedBlockKey, *V] {
+	return func(yield func(FixedBlockKey, *V) bool) {
+		for i := range sm.keys {
+			if !yield(sm.keys[i], &sm.values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// staticHeader describes the sections following the header
+type staticHeader struct {
+	count     uint64
+	levels    uint32
+	valueSize uint32
+	words     uint64
+	checksum  uint32
+	length    uint64 // total size of the serialized map, set by decodeStaticHeader
+}
+
+// size returns the total size of the serialized map. The header checksum can
+// be computed over any counts, so every section is checked for overflow and
+// the total must fit in memory.
+func (h *staticHeader) size() (uint64, error) {
+	sections := [][2]uint64{
+		{uint64(h.levels), uint64(unsafe.Sizeof(staticLevel{}))},
+		{h.words, 8},
+		{staticRankCount(h.words), 8},
+		{h.count, uint64(unsafe.Sizeof(FixedBlockKey{}))},
+		{h.count, uint64(h.valueSize)},
+	}
+
+	size := uint64(staticHeaderSize)
+	for _, section := range sections {
+		hi, lo := bits.Mul64(section[0], section[1])
+		sum, carry := bits.Add64(size, lo, 0)
+		if hi != 0 || carry != 0 || sum > math.MaxInt {
+			return 0, fmt.Errorf("invalid static map data: %d entries in %d words do not fit in memory", h.count, h.words)
+		}
+		size = sum
+	}
+
+	return size, nil
+}
+
+// sliceBytes maps the memory of a slice directly to a []byte
+func sliceBytes[T any](s []T) []byte {
+	if len(s) == 0 || unsafe.Sizeof(s[0]) == 0 {
+		return nil
+	}
+
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s[0])), int(unsafe.Sizeof(s[0]))*len(s))
+}
+
+// bytesSlice maps n elements of type T at the start of data
+func bytesSlice[T any](data []byte, n uint64) []T {
+	if n == 0 {
+		return nil
+	}
+	if len(data) == 0 {
+		// Zero-size types occupy no bytes
+		return make([]T, n)
+	}
+
+	return unsafe.Slice((*T)(unsafe.Pointer(&data[0])), n)
+}
+
+// WriteTo writes the map in the layout used by OpenStaticMap: a header
+// followed by the level table, the bits, the rank samples, the keys and the
+// values. Every section starts at a multiple of 8 bytes.
+func (sm *StaticMap[V]) WriteTo(w io.Writer) (int64, error) {
+	sections := [][]byte{
+		sliceBytes(sm.levels),
+		sliceBytes(sm.words),
+		sliceBytes(sm.ranks),
+		sliceBytes(sm.keys),
+		sliceBytes(sm.values),
+	}
+
+	checksum := uint32(0)
+	for _, section := range sections {
+		checksum = crc32.Update(checksum, castagnoliTable, section)
+	}
+
+	var header [staticHeaderSize]byte
+	copy(header[0:4], staticMagic[:])
+	binary.LittleEndian.PutUint32(header[4:8], staticVersion)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(sm.keys)))
+	binary.LittleEndian.PutUint32(header[16:20], uint32(len(sm.levels)))
+	binary.LittleEndian.PutUint32(header[20:24], uint32(unsafe.Sizeof(*new(V))))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(sm.words)))
+	binary.LittleEndian.PutUint32(header[32:36], checksum)
+	binary.LittleEndian.PutUint32(header[36:40], crc32.Checksum(header[0:36], castagnoliTable))
+
+	written, err := w.Write(header[:])
+	total := int64(written)
+	if err != nil {
+		return total, err
+	}
+
+	for _, section := range sections {
+		written, err = w.Write(section)
+		total += int64(written)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// decodeStaticHeader reads and verifies the header written by WriteTo
+func decodeStaticHeader[V any](src []byte) (staticHeader, error) {
+	if [4]byte(src[0:4]) != staticMagic {
+		return staticHeader{}, errors.New("invalid static map data: bad magic")
+	}
+	if crc32.Checksum(src[0:36], castagnoliTable) != binary.LittleEndian.Uint32(src[36:40]) {
+		return staticHeader{}, fmt.Errorf("invalid static map data: header %w", ErrChecksumMismatch)
+	}
+	if version := binary.LittleEndian.Uint32(src[4:8]); version != staticVersion {
+		return staticHeader{}, fmt.Errorf("invalid static map data: unsupported version %d", version)
+	}
+
+	header := staticHeader{
+		count:     binary.LittleEndian.Uint64(src[8:16]),
+		levels:    binary.LittleEndian.Uint32(src[16:20]),
+		valueSize: binary.LittleEndian.Uint32(src[20:24]),
+		words:     binary.LittleEndian.Uint64(src[24:32]),
+		checksum:  binary.LittleEndian.Uint32(src[32:36]),
+	}
+
+	if valueSize := uint32(unsafe.Sizeof(*new(V))); header.valueSize != valueSize {
+		return staticHeader{}, fmt.Errorf("invalid static map data: value size %d does not match %d", header.valueSize, valueSize)
+	}
+
+	length, err := header.size()
+	if err != nil {
+		return staticHeader{}, err
+	}
+	header.length = length
+
+	return header, nil
+}
+
+// OpenStaticMap returns a map that uses data written by WriteTo in place,
+// without copying it. The data must start at an 8-byte aligned address, which
+// memory-mapped files and buffers allocated as []uint64 always do, and must
+// not be modified while the map is in use. The checksum is verified, which
+// reads the data once.
+func OpenStaticMap[V any](data []byte) (*StaticMap[V], error) {
+	if len(data) < staticHeaderSize {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if uintptr(unsafe.Pointer(&data[0]))%8 != 0 {
+		return nil, errors.New("invalid static map data: not 8-byte aligned")
+	}
+
+	header, err := decodeStaticHeader[V](data)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) != header.length {
+		return nil, fmt.Errorf("invalid static map data: %d bytes, expected %d", len(data), header.length)
+	}
+
+	payload := data[staticHeaderSize:]
+	if crc32.Checksum(payload, castagnoliTable) != header.checksum {
+		return nil, fmt.Errorf("invalid static map data: %w", ErrChecksumMismatch)
+	}
+
+	// Split the payload into its sections
+	take := func(size uint64) []byte {
+		section := payload[:size]
+		payload = payload[size:]
+		return section
+	}
+
+	levelCount := uint64(header.levels)
+	rankCount := staticRankCount(header.words)
+
+	sm := &StaticMap[V]{
+		levels: bytesSlice[staticLevel](take(levelCount*uint64(unsafe.Sizeof(staticLevel{}))), levelCount),
+		words:  bytesSlice[uint64](take(header.words*8), header.words),
+		ranks:  bytesSlice[uint64](take(rankCount*8), rankCount),
+		keys:   bytesSlice[FixedBlockKey](take(header.count*uint64(unsafe.Sizeof(FixedBlockKey{}))), header.count),
+		values: bytesSlice[V](take(header.count*uint64(header.valueSize)), header.count),
+	}
+
+	// The levels must tile the bits and the ranks must count every entry, so
+	// lookups stay within the sections
+	var offset uint64
+	for _, level := range sm.levels {
+		if level.offset != offset || level.size%64 != 0 {
+			return nil, fmt.Errorf("%w: level at bit %d does not follow bit %d", ErrCorruptMap, level.offset, offset)
+		}
+		offset += level.size
+	}
+	if offset != header.words*64 || sm.ranks[rankCount-1] != header.count {
+		return nil, fmt.Errorf("%w: levels and ranks do not match %d entries", ErrCorruptMap, header.count)
+	}
+
+	return sm, nil
+}
+
+// ReadFrom replaces the map with data written by WriteTo, reading it into an
+// aligned buffer and opening it with OpenStaticMap. The map can be the zero
+// value.
+func (sm *StaticMap[V]) ReadFrom(r io.Reader) (int64, error) {
+	var headerBytes [staticHeaderSize]byte
+	read, err := io.ReadFull(r, headerBytes[:])
+	total := int64(read)
+	if err != nil {
+		return total, err
+	}
+
+	header, err := decodeStaticHeader[V](headerBytes[:])
+	if err != nil {
+		return total, err
+	}
+
+	// Allocating whole words guarantees the alignment. The buffer grows with
+	// the data read, so a corrupt header cannot request a huge allocation.
+	buf := make([]uint64, staticHeaderSize/8, min(header.length, staticReadSize)/8+1)
+	copy(sliceBytes(buf), headerBytes[:])
+
+	for filled := uint64(staticHeaderSize); filled < header.length; {
+		n := min(header.length-filled, staticReadSize)
+		words := int((n + 7) / 8)
+		buf = slices.Grow(buf, words)[:len(buf)+words]
+
+		read, err = io.ReadFull(r, sliceBytes(buf)[filled:filled+n])
+		total += int64(read)
+		if err != nil {
+			return total, unexpectedEOF(err)
+		}
+		filled += n
+	}
+
+	opened, err := OpenStaticMap[V](sliceBytes(buf)[:header.length])
+	if err != nil {
+		return total, err
+	}
+
+	*sm = *opened
+	return total, nil
+}
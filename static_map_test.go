@@ -0,0 +1,150 @@
+package collections
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStaticMap builds a static map from count entries "in<i>" -> i
+func newTestStaticMap(t *testing.T, count int) *StaticMap[uint64] {
+	m := NewFixedBlockMap[uint64](uint64(2 * count))
+	for i := 0; i < count; i++ {
+		require.NoError(t, m.Put(bloomKey("in", i), uint64(i)))
+	}
+
+	sm, err := BuildStaticMap(m.All())
+	require.NoError(t, err)
+	return sm
+}
+
+// alignedCopy copies data into memory that is 8-byte aligned
+func alignedCopy(data []byte) []byte {
+	buf := make([]uint64, (len(data)+7)/8+1)
+	aligned := unsafe.Slice((*byte)(unsafe.Pointer(&buf[0])), len(buf)*8)
+	copy(aligned, data)
+	return aligned[:len(data)]
+}
+
+func TestStaticMap_Get(t *testing.T) {
+	for _, count := range []int{0, 1, 100, 50000} {
+		sm := newTestStaticMap(t, count)
+		assert.Equal(t, uint64(count), sm.Len())
+
+		for i := 0; i < count; i++ {
+			value, found := sm.Get(bloomKey("in", i))
+			require.True(t, found, "key %d", i)
+			require.Equal(t, uint64(i), *value)
+		}
+
+		for i := 0; i < 1000; i++ {
+			_, found := sm.Get(bloomKey("out", i))
+			require.False(t, found)
+		}
+
+		var seen int
+		for key, value := range sm.Iter() {
+			assert.Equal(t, bloomKey("in", int(*value)), key)
+			seen++
+		}
+		assert.Equal(t, count, seen)
+	}
+}
+
+func TestStaticMap_Size(t *testing.T) {
+	sm := newTestStaticMap(t, 100000)
+
+	bitsPerKey := float64(64*(len(sm.words)+len(sm.ranks))) / float64(sm.Len())
+	assert.Less(t, bitsPerKey, 4.0)
+	assert.Less(t, len(sm.levels), 32)
+}
+
+func TestStaticMap_DuplicateKeys(t *testing.T) {
+	entries := func(yield func(FixedBlockKey, int) bool) {
+		_ = yield(bloomKey("in", 1), 1) && yield(bloomKey("in", 2), 2) && yield(bloomKey("in", 1), 3)
+	}
+
+	_, err := BuildStaticMap(entries)
+	assert.ErrorContains(t, err, "duplicate key")
+}
+
+func TestStaticMap_WriteTo(t *testing.T) {
+	sm := newTestStaticMap(t, 1000)
+
+	var buf bytes.Buffer
+	written, err := sm.WriteTo(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), written)
+	data := buf.Bytes()
+
+	var loaded StaticMap[uint64]
+	read, err := loaded.ReadFrom(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, written, read)
+	assert.Equal(t, sm.keys, loaded.keys)
+	assert.Equal(t, sm.values, loaded.values)
+
+	// Opening the data in place shares its memory
+	aligned := alignedCopy(data)
+	opened, err := OpenStaticMap[uint64](aligned)
+	require.NoError(t, err)
+	value, found := opened.Get(bloomKey("in", 7))
+	require.True(t, found)
+	assert.Equal(t, uint64(7), *value)
+	assert.Equal(t, unsafe.Pointer(&aligned[len(aligned)-8*1000]), unsafe.Pointer(&opened.values[0]))
+
+	_, err = OpenStaticMap[uint64](alignedCopy(append([]byte{0}, data...))[1:])
+	assert.ErrorContains(t, err, "aligned")
+
+	_, err = OpenStaticMap[uint32](aligned)
+	assert.ErrorContains(t, err, "value size")
+
+	corrupted := alignedCopy(data)
+	corrupted[len(corrupted)-1] ^= 0xFF
+	_, err = OpenStaticMap[uint64](corrupted)
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	_, err = OpenStaticMap[uint64](aligned[:len(aligned)-8])
+	assert.Error(t, err)
+
+	// A corrupt count fails on the missing data instead of allocating the
+	// announced sections, and one whose sections overflow is rejected
+	for count, expected := range map[uint64]string{1 << 40: io.ErrUnexpectedEOF.Error(), 1 << 60: "do not fit in memory"} {
+		huge := bytes.Clone(data)
+		binary.LittleEndian.PutUint64(huge[8:16], count)
+		binary.LittleEndian.PutUint32(huge[36:40], crc32.Checksum(huge[0:36], castagnoliTable))
+
+		_, err = loaded.ReadFrom(bytes.NewReader(huge))
+		assert.ErrorContains(t, err, expected)
+		_, err = OpenStaticMap[uint64](alignedCopy(huge))
+		assert.Error(t, err)
+	}
+	assert.Equal(t, sm.keys, loaded.keys)
+}
+
+func TestStaticMap_ZeroSizeValues(t *testing.T) {
+	m := NewFixedBlockMap[struct{}](256)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, m.Put(bloomKey("in", i), struct{}{}))
+	}
+
+	sm, err := BuildStaticMap(m.All())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = sm.WriteTo(&buf)
+	require.NoError(t, err)
+
+	var loaded StaticMap[struct{}]
+	_, err = loaded.ReadFrom(&buf)
+	require.NoError(t, err)
+
+	_, found := loaded.Get(bloomKey("in", 42))
+	assert.True(t, found)
+}